@@ -0,0 +1,100 @@
+// Package animate turns a sequence of creature renderings, such as a
+// lineage, into an animated GIF or APNG.
+package animate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"sort"
+
+	"github.com/fogleman/gg"
+)
+
+// Frame is one picture of the animation. Label, if set and enabled in
+// Options, is drawn in the top left corner.
+type Frame struct {
+	Image image.Image
+	Label string
+}
+
+type Options struct {
+	// Delay is the time each frame is shown, in hundredths of a second.
+	Delay int
+	// Hold is added to the delay of the final frame.
+	Hold int
+	// LoopCount follows image/gif: 0 loops forever, -1 plays once and n
+	// plays n+1 times.
+	LoopCount int
+	Palette   color.Palette
+	Labels    bool
+}
+
+// Palettes are the palettes selectable by name.
+var Palettes = map[string]color.Palette{
+	"mono":    {color.White, color.Black},
+	"gray":    gray_palette(16),
+	"plan9":   palette.Plan9,
+	"websafe": palette.WebSafe,
+}
+
+func gray_palette(n int) color.Palette {
+	p := make(color.Palette, n)
+	for i := range p {
+		y := uint8(255 - i*255/(n-1))
+		p[i] = color.Gray{y}
+	}
+	return p
+}
+
+func PaletteNames() []string {
+	var names []string
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetPalette(name string) (color.Palette, error) {
+	p, ok := Palettes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette %q, want one of %v", name, PaletteNames())
+	}
+	return p, nil
+}
+
+func DefaultOptions() Options {
+	return Options{Delay: 20, Hold: 100, Palette: Palettes["mono"]}
+}
+
+// LineageLabel is the label of the creature with the given ID at position
+// generation in its lineage.
+func LineageLabel(id uint64, generation int) string {
+	return fmt.Sprintf("#%d gen %d", id, generation)
+}
+
+func (o Options) delay(i int, n int) int {
+	if i == n-1 {
+		return o.Delay + o.Hold
+	}
+	return o.Delay
+}
+
+// paletted draws the frame, with its label if enabled, onto the palette.
+func (o Options) paletted(f Frame) *image.Paletted {
+	img := f.Image
+	if o.Labels && f.Label != "" {
+		dc := gg.NewContextForImage(img)
+		dc.SetColor(color.Black)
+		_, h := dc.MeasureString(f.Label)
+		dc.DrawString(f.Label, 3, 2+h)
+		img = dc.Image()
+	}
+	b := img.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), o.Palette)
+	draw.Draw(p, p.Bounds(), img, b.Min, draw.Src)
+	return p
+}
@@ -0,0 +1,84 @@
+package animate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func test_frames(n int) []Frame {
+	frames := make([]Frame, n)
+	for i := range frames {
+		img := image.NewGray(image.Rect(0, 0, 40, 30))
+		for x := 0; x < 40; x++ {
+			for y := 0; y < 30; y++ {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+		img.SetGray(i, i, color.Gray{0})
+		frames[i] = Frame{img, LineageLabel(uint64(i+1), i)}
+	}
+	return frames
+}
+
+func TestEncodeGif(t *testing.T) {
+	opts := DefaultOptions()
+	opts.LoopCount = 2
+	var buff bytes.Buffer
+	assert.NoError(t, EncodeGif(&buff, test_frames(3), opts))
+	g, err := gif.DecodeAll(&buff)
+	assert.NoError(t, err)
+	assert.Len(t, g.Image, 3)
+	assert.Equal(t, []int{opts.Delay, opts.Delay, opts.Delay + opts.Hold}, g.Delay)
+	assert.Equal(t, 2, g.LoopCount)
+	assert.Equal(t, color.Gray{0}, color.GrayModel.Convert(g.Image[2].At(2, 2)))
+	assert.Equal(t, color.Gray{255}, color.GrayModel.Convert(g.Image[2].At(1, 1)))
+
+	assert.Equal(t, ErrNoFrames, EncodeGif(&buff, nil, opts))
+}
+
+func TestEncodeGifLabels(t *testing.T) {
+	plain, labelled := DefaultOptions(), DefaultOptions()
+	labelled.Labels = true
+	var a, b bytes.Buffer
+	assert.NoError(t, EncodeGif(&a, test_frames(1), plain))
+	assert.NoError(t, EncodeGif(&b, test_frames(1), labelled))
+	assert.NotEqual(t, a.Bytes(), b.Bytes())
+}
+
+func TestEncodeApng(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Palette = Palettes["gray"]
+	var buff bytes.Buffer
+	assert.NoError(t, EncodeApng(&buff, test_frames(3), opts))
+
+	// Viewers without APNG support show the first frame.
+	img, err := png.Decode(bytes.NewReader(buff.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 40, img.Bounds().Dx())
+
+	chunks, err := read_chunks(buff.Bytes())
+	assert.NoError(t, err)
+	counts := map[string]int{}
+	var delays []uint16
+	for _, c := range chunks {
+		counts[c.kind]++
+		if c.kind == "acTL" {
+			assert.Equal(t, uint32(3), binary.BigEndian.Uint32(c.data))
+			assert.Equal(t, uint32(0), binary.BigEndian.Uint32(c.data[4:]))
+		}
+		if c.kind == "fcTL" {
+			delays = append(delays, binary.BigEndian.Uint16(c.data[20:]))
+		}
+	}
+	assert.Equal(t, 1, counts["acTL"])
+	assert.Equal(t, 3, counts["fcTL"])
+	assert.Equal(t, 2, counts["fdAT"])
+	assert.Equal(t, []uint16{20, 20, 120}, delays)
+}
@@ -0,0 +1,130 @@
+package animate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/png"
+	"io"
+)
+
+var (
+	png_signature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+type png_chunk struct {
+	kind string
+	data []byte
+}
+
+// read_chunks splits an encoded PNG into its chunks.
+func read_chunks(b []byte) ([]png_chunk, error) {
+	if !bytes.HasPrefix(b, png_signature) {
+		return nil, errors.New("not a PNG")
+	}
+	b = b[len(png_signature):]
+	var chunks []png_chunk
+	for len(b) >= 12 {
+		n := binary.BigEndian.Uint32(b)
+		if uint64(len(b)) < 12+uint64(n) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, png_chunk{string(b[4:8]), b[8 : 8+n]})
+		b = b[12+n:]
+	}
+	return chunks, nil
+}
+
+func write_chunk(w io.Writer, kind string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+	for _, p := range [][]byte{header[:], data, footer[:]} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeApng writes an animated PNG. Every frame is encoded as a paletted
+// PNG with opts.Palette, so the header and palette of the first frame are
+// valid for all of them.
+func EncodeApng(w io.Writer, frames []Frame, opts Options) error {
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	num_plays := uint32(0)
+	if opts.LoopCount < 0 {
+		num_plays = 1
+	} else if opts.LoopCount > 0 {
+		num_plays = uint32(opts.LoopCount) + 1
+	}
+	if _, err := w.Write(png_signature); err != nil {
+		return err
+	}
+	seq := uint32(0)
+	for i, f := range frames {
+		p := opts.paletted(f)
+		var buff bytes.Buffer
+		if err := png.Encode(&buff, p); err != nil {
+			return err
+		}
+		chunks, err := read_chunks(buff.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			for _, c := range chunks {
+				if c.kind == "IDAT" || c.kind == "IEND" {
+					break
+				}
+				if err := write_chunk(w, c.kind, c.data); err != nil {
+					return err
+				}
+				if c.kind == "IHDR" {
+					actl := make([]byte, 8)
+					binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+					binary.BigEndian.PutUint32(actl[4:], num_plays)
+					if err := write_chunk(w, "acTL", actl); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(p.Rect.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(p.Rect.Dy()))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(opts.delay(i, len(frames))))
+		binary.BigEndian.PutUint16(fctl[22:], 100)
+		seq++
+		if err := write_chunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		for _, c := range chunks {
+			if c.kind != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				err = write_chunk(w, "IDAT", c.data)
+			} else {
+				fdat := make([]byte, 4+len(c.data))
+				binary.BigEndian.PutUint32(fdat, seq)
+				copy(fdat[4:], c.data)
+				seq++
+				err = write_chunk(w, "fdAT", fdat)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return write_chunk(w, "IEND", nil)
+}
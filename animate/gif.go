@@ -0,0 +1,26 @@
+package animate
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+	"io"
+)
+
+var (
+	ErrNoFrames = errors.New("animation has no frames")
+)
+
+func EncodeGif(w io.Writer, frames []Frame, opts Options) error {
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	out := &gif.GIF{LoopCount: opts.LoopCount}
+	for i, f := range frames {
+		out.Image = append(out.Image, opts.paletted(f))
+		out.Delay = append(out.Delay, opts.delay(i, len(frames)))
+		out.Disposal = append(out.Disposal, gif.DisposalNone)
+	}
+	out.Config = image.Config{ColorModel: opts.Palette, Width: out.Image[0].Rect.Dx(), Height: out.Image[0].Rect.Dy()}
+	return gif.EncodeAll(w, out)
+}
@@ -1,8 +1,8 @@
 package biomorph
 
 import (
-	"image"
-	"image/color"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 )
@@ -11,43 +11,126 @@ const (
 	ImageSize = 150
 )
 
+var (
+	ErrUnknownGene     = errors.New("unknown gene")
+	ErrSpeciesMismatch = errors.New("species mismatch")
+)
+
 type GeneRange struct {
 	Min float64
 	Max float64
 }
 
+// GeneType says what values a gene takes. The zero value is GeneFloat.
+type GeneType string
+
+const (
+	GeneFloat GeneType = "float"
+	GeneInt   GeneType = "int"
+)
+
+// Gene is one heritable number. New creatures start with Default, or the
+// middle of the range if it's nil.
 type Gene struct {
-	Range GeneRange
-	Name  string
+	Range   GeneRange
+	Name    string
+	Type    GeneType
+	Default *float64
+}
+
+// Initial is the value a new creature starts with.
+func (g *Gene) Initial() float64 {
+	if g.Default != nil {
+		return g.Fit(*g.Default)
+	}
+	return g.Fit(0.5 * (g.Range.Max + g.Range.Min))
 }
 
+// Fit clamps v into the gene's range, rounding it for integer genes. Those
+// are clamped to the whole numbers in their range, if it has any, so they
+// stay whole.
+func (g *Gene) Fit(v float64) float64 {
+	if g.Type == GeneInt {
+		if lo, hi := math.Ceil(g.Range.Min), math.Floor(g.Range.Max); lo <= hi {
+			return clamp(math.Round(v), GeneRange{lo, hi})
+		}
+	}
+	return clamp(v, g.Range)
+}
+
+// Mutation is how strongly a species mutates: each gene changes with
+// probability Rate, by up to Scale/2 of its range either way.
+type Mutation struct {
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Scale float64 `json:"scale" yaml:"scale"`
+}
+
+var DefaultMutation = Mutation{Rate: 0.3, Scale: 0.3}
+
 type GeneValue struct {
 	Gene  *Gene
 	Value float64
 }
 
+// Rand is the source of randomness for mutation. *rand.Rand satisfies it;
+// a *rand.Rand must not be shared between goroutines.
+type Rand interface {
+	Float64() float64
+}
+
+type global_rand struct{}
+
+func (global_rand) Float64() float64 {
+	return rand.Float64()
+}
+
 func (g *GeneValue) Mutate() *GeneValue {
-	if rand.Float64() > 0.3 {
+	return g.MutateRand(global_rand{})
+}
+
+func (g *GeneValue) MutateRand(r Rand) *GeneValue {
+	return g.mutate(r, DefaultMutation)
+}
+
+func (g *GeneValue) mutate(r Rand, m Mutation) *GeneValue {
+	if r.Float64() > m.Rate {
 		return &GeneValue{g.Gene, g.Value}
 	}
-	new_value := g.Value + (0.5-rand.Float64())*0.3*(g.Gene.Range.Max-g.Gene.Range.Min)
-	if new_value > g.Gene.Range.Max {
-		new_value = g.Gene.Range.Max
-	}
-	if new_value < g.Gene.Range.Min {
-		new_value = g.Gene.Range.Min
-	}
-	return &GeneValue{g.Gene, new_value}
+	new_value := g.Value + (0.5-r.Float64())*m.Scale*(g.Gene.Range.Max-g.Gene.Range.Min)
+	return &GeneValue{g.Gene, g.Gene.Fit(new_value)}
 }
 
+// Species is a set of genes and how to mutate and draw creatures with them.
+// Renderer names an entry of Renderers, or is "program" or "lsystem" for
+// species drawn by their Program or LSystem. Creatures carry every gene in
+// Genes once and a variable number of copies of each of Modules.
 type Species struct {
-	Genes []*Gene
+	Name     string
+	Genes    []*Gene
+	Modules  []*Module
+	Renderer string
+	Mutation Mutation
+	Program  *Program
+	LSystem  *LSystem
 }
 
-func (c *Creature) SetValuesFromMap(m map[string]float64) {
+// SetValuesFromMap sets genes by name, fitting each value to its gene. It
+// changes nothing and returns an error if any name isn't one of c's genes
+// or any value is NaN.
+func (c *Creature) SetValuesFromMap(m map[string]float64) error {
+	for k, v := range m {
+		if c.GetGeneValue(k) == nil {
+			return fmt.Errorf("%w %q for species %s", ErrUnknownGene, k, c.CreatureSpecies.Name)
+		}
+		if math.IsNaN(v) {
+			return fmt.Errorf("gene %q value is NaN", k)
+		}
+	}
 	for k, v := range m {
-		c.GetGeneValue(k).Value = v
+		g := c.GetGeneValue(k)
+		g.Value = g.Gene.Fit(v)
 	}
+	return nil
 }
 
 type Creature struct {
@@ -64,81 +147,165 @@ func (c *Creature) ValuesMap() map[string]float64 {
 }
 
 func TreeGenes() (genes []*Gene) {
-	genes = append(genes, &Gene{GeneRange{float64(ImageSize) * 0.1, float64(ImageSize) * 0.4}, "branch_length"})
-	genes = append(genes, &Gene{GeneRange{2, 5}, "num_gens"})
-	genes = append(genes, &Gene{GeneRange{0.1, 5}, "branch_angle"})
-	genes = append(genes, &Gene{GeneRange{0.1, 2}, "branch_increase"})
-	genes = append(genes, &Gene{GeneRange{0.1, 2}, "angle_increase"})
-	genes = append(genes, &Gene{GeneRange{2, 9}, "num_branches"})
-	genes = append(genes, &Gene{GeneRange{-0.1, 0.1}, "angle_noise"})
-	genes = append(genes, &Gene{GeneRange{-0.1, 0.1}, "length_noise"})
+	genes = append(genes, &Gene{GeneRange{float64(ImageSize) * 0.1, float64(ImageSize) * 0.4}, "branch_length", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{2, 5}, "num_gens", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{0.1, 5}, "branch_angle", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{0.1, 2}, "branch_increase", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{0.1, 2}, "angle_increase", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{2, 9}, "num_branches", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{-0.1, 0.1}, "angle_noise", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{-0.1, 0.1}, "length_noise", GeneFloat, nil})
 	return
 }
 
-func NewSpecies(genes []*Gene) *Species {
-	return &Species{genes}
+// NewSpecies makes a species drawn as a tree with the default mutation.
+func NewSpecies(name string, genes []*Gene) *Species {
+	return &Species{Name: name, Genes: genes, Renderer: "tree", Mutation: DefaultMutation}
 }
 
 func NewTreeSpecies() *Species {
-	return NewSpecies(TreeGenes())
+	return NewSpecies("tree", TreeGenes())
 }
 
+// Gene looks up a gene, or a copy of a module's gene named "m.k.g", by
+// name, returning nil if the species lacks it.
+func (s *Species) Gene(name string) *Gene {
+	for _, g := range s.Genes {
+		if g.Name == name {
+			return g
+		}
+	}
+	for _, m := range s.Modules {
+		for _, copy_genes := range m.copies {
+			for _, g := range copy_genes {
+				if g.Name == name {
+					return g
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NewCreature makes a creature with every gene at its initial value and
+// each module's initial number of copies.
 func NewCreature(species *Species) *Creature {
-	c := &Creature{species, make([]*GeneValue, len(species.Genes))}
-	for i, gene := range species.Genes {
-		c.Values[i] = &GeneValue{gene, 0.5 * (gene.Range.Max + gene.Range.Min)}
+	counts := make([]int, len(species.Modules))
+	for i, m := range species.Modules {
+		counts[i] = m.Initial
+	}
+	return NewCreatureCounts(species, counts)
+}
+
+// RandomCreature picks every gene value uniformly within its range, and
+// each module's number of copies uniformly between its bounds.
+func RandomCreature(species *Species, r Rand) *Creature {
+	counts := make([]int, len(species.Modules))
+	for i, m := range species.Modules {
+		counts[i] = m.Min + pick(r, m.Max-m.Min+1)
+	}
+	c := NewCreatureCounts(species, counts)
+	for _, v := range c.Values {
+		v.Value = v.Gene.Fit(v.Gene.Range.Min + r.Float64()*(v.Gene.Range.Max-v.Gene.Range.Min))
 	}
 	return c
 }
 
 func MutateCreature(creature *Creature) *Creature {
-	new_creature := NewCreature(creature.CreatureSpecies)
+	return MutateCreatureRand(creature, global_rand{})
+}
+
+// MutateCreatureRand is MutateCreature drawing from r instead of the global
+// source, so concurrent callers can each own their randomness.
+func MutateCreatureRand(creature *Creature, r Rand) *Creature {
+	species := creature.CreatureSpecies
+	new_creature := &Creature{species, make([]*GeneValue, len(creature.Values))}
 	for i, v := range creature.Values {
-		new_creature.Values[i] = v.Mutate()
+		new_creature.Values[i] = v.mutate(r, species.Mutation)
+	}
+	if len(species.Modules) == 0 {
+		return new_creature
 	}
-	return new_creature
+	l := new_creature.layout()
+	for i, m := range species.Modules {
+		l.modules[i] = m.mutate_structure(l.modules[i], r)
+	}
+	return l.creature(species)
 }
 
-type point struct {
-	x float64
-	y float64
+// same_genes reports whether a and b define the same genes in the same
+// order.
+func same_genes(a []*Gene, b []*Gene) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, g := range a {
+		if g.Name != b[i].Name || g.Range != b[i].Range || g.Type != b[i].Type {
+			return false
+		}
+	}
+	return true
 }
 
-type line struct {
-	a point
-	b point
+// SameSpecies reports whether a and b have the same name and define the same
+// genes and modules, so creatures of one can be bred with creatures of the
+// other.
+func SameSpecies(a *Species, b *Species) bool {
+	if a.Name != b.Name || !same_genes(a.Genes, b.Genes) || len(a.Modules) != len(b.Modules) {
+		return false
+	}
+	for i, m := range a.Modules {
+		if m.Name != b.Modules[i].Name || !same_genes(m.Genes, b.Modules[i].Genes) {
+			return false
+		}
+	}
+	return true
 }
 
-func DrawLine(img *image.Gray16, l *line) {
-	x := float64(l.a.x)
-	y := float64(l.a.y)
-	distance := math.Sqrt(math.Pow(float64(l.a.x-l.b.x), 2) + math.Pow(float64(l.a.y-l.b.y), 2))
-	slope := float64((l.b.y - l.a.y)) / float64((l.b.x - l.a.x))
-	dir_y := 1.0
-	if l.b.y < l.a.y {
-		dir_y = -1.0
-	}
-	dir_x := dir_y
-	if slope < 0 {
-		dir_x = -dir_y
+// BreedCreatures crosses two creatures of the same species, taking each gene
+// value from either parent with equal chance. Module copies are crossed
+// with cross_copies, so parents may carry different numbers of them. It
+// returns ErrSpeciesMismatch if the parents' species differ.
+func BreedCreatures(a *Creature, b *Creature) (*Creature, error) {
+	return BreedCreaturesRand(a, b, global_rand{})
+}
+
+func BreedCreaturesRand(a *Creature, b *Creature, r Rand) (*Creature, error) {
+	species := a.CreatureSpecies
+	if !SameSpecies(species, b.CreatureSpecies) {
+		return nil, fmt.Errorf("%w: parents are species %q and %q", ErrSpeciesMismatch, species.Name, b.CreatureSpecies.Name)
 	}
-	dx := dir_x
-	dy := dir_y
-	if slope < 0 {
-		slope *= -1
+	if len(species.Modules) == 0 {
+		child := NewCreature(species)
+		for i, v := range a.Values {
+			if r.Float64() < 0.5 {
+				v = b.Values[i]
+			}
+			child.Values[i] = &GeneValue{v.Gene, v.Value}
+		}
+		return child, nil
 	}
-	if slope > 0 {
-		dx /= slope
-	} else {
-		dy *= slope
+	la, lb := a.layout(), b.layout()
+	child := &layout{make([]float64, len(la.fixed)), make([][][]float64, len(la.modules))}
+	for i, v := range la.fixed {
+		if r.Float64() < 0.5 {
+			v = lb.fixed[i]
+		}
+		child.fixed[i] = v
 	}
-	for i := 0; i < int(distance); i++ {
-		img.Set(int(x), int(y), color.Black)
-		x += dx
-		y += dy
+	for i := range child.modules {
+		child.modules[i] = cross_copies(la.modules[i], lb.modules[i], r)
 	}
+	return child.creature(species), nil
+}
+
+type point struct {
+	x float64
+	y float64
 }
 
+// GetGeneValue returns c's value of the named gene, or nil if its species
+// has no such gene.
 func (c *Creature) GetGeneValue(name string) *GeneValue {
 	for _, gene := range c.Values {
 		if gene.Gene.Name == name {
@@ -148,6 +315,9 @@ func (c *Creature) GetGeneValue(name string) *GeneValue {
 	return nil
 }
 
+// GetValue returns the value of the named gene. It panics if c's species
+// has no such gene, since callers name genes their renderer requires; use
+// GetGeneValue for names that may be missing.
 func (c *Creature) GetValue(name string) float64 {
 	return c.GetGeneValue(name).Value
 }
@@ -1,17 +1,265 @@
 package biomorph
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// fixed_rand always returns the same number. 0 mutates every gene, by the
+// most the species allows upwards.
+type fixed_rand float64
+
+func (r fixed_rand) Float64() float64 {
+	return float64(r)
+}
+
 func TestBiomorph(t *testing.T) {
 	creature := NewCreature(NewTreeSpecies())
 	orig_value := creature.Values[0].Value
 	gene := creature.CreatureSpecies.Genes[0]
 	assert.Equal(t, 0.5*(gene.Range.Max+gene.Range.Min), orig_value)
-	mutated_creature := MutateCreature(creature)
+	mutated_creature := MutateCreatureRand(creature, fixed_rand(0))
 	assert.NotEqual(t, orig_value, mutated_creature.Values[0].Value)
 	assert.Equal(t, orig_value, creature.Values[0].Value)
+	// Nothing mutates when every draw is over the rate.
+	assert.Equal(t, creature.ValuesMap(), MutateCreatureRand(creature, fixed_rand(0.99)).ValuesMap())
+}
+
+func TestSetValuesFromMap(t *testing.T) {
+	c := NewCreature(NewTreeSpecies())
+	assert.NoError(t, c.SetValuesFromMap(map[string]float64{"num_gens": 3, "branch_angle": 100}))
+	assert.Equal(t, 3.0, c.GetValue("num_gens"))
+	assert.Equal(t, 5.0, c.GetValue("branch_angle"), "fitted to the range")
+	before := c.ValuesMap()
+	err := c.SetValuesFromMap(map[string]float64{"num_gens": 4, "no_such_gene": 1})
+	assert.ErrorIs(t, err, ErrUnknownGene)
+	assert.Error(t, c.SetValuesFromMap(map[string]float64{"num_gens": math.NaN()}))
+	assert.Equal(t, before, c.ValuesMap(), "a rejected map changes nothing")
+}
+
+// valid_range reports whether min and max make a gene range the fuzzers
+// can test: finite, ordered and not so wide their difference overflows.
+func valid_range(min, max float64) bool {
+	return !math.IsNaN(min) && !math.IsNaN(max) && min <= max && !math.IsInf(max-min, 0)
+}
+
+func FuzzGeneFit(f *testing.F) {
+	f.Add(0.0, 1.0, 0.5, false)
+	f.Add(2.0, 9.0, 100.0, true)
+	f.Add(-1.0, -1.0, 0.0, true)
+	f.Add(0.2, 0.4, 0.3, true)
+	f.Add(-5.0, 5.0, math.Inf(-1), false)
+	f.Fuzz(func(t *testing.T, min, max, v float64, integer bool) {
+		if !valid_range(min, max) || math.IsNaN(v) {
+			t.Skip()
+		}
+		g := &Gene{GeneRange{min, max}, "g", GeneFloat, nil}
+		if integer {
+			g.Type = GeneInt
+		}
+		fit := g.Fit(v)
+		if fit < min || fit > max {
+			t.Fatalf("Fit(%v) = %v, outside [%v, %v]", v, fit, min, max)
+		}
+		if integer && math.Ceil(min) <= max && fit != math.Trunc(fit) {
+			t.Fatalf("int gene Fit(%v) = %v, in [%v, %v]", v, fit, min, max)
+		}
+		if again := g.Fit(fit); again != fit {
+			t.Fatalf("Fit(%v) = %v but Fit(%v) = %v", v, fit, fit, again)
+		}
+		if initial := g.Initial(); initial < min || initial > max {
+			t.Fatalf("Initial() = %v, outside [%v, %v]", initial, min, max)
+		}
+	})
+}
+
+func FuzzGeneValueMutate(f *testing.F) {
+	f.Add(0.0, 1.0, 0.5, 0.3, 0.3, int64(1))
+	f.Add(2.0, 9.0, 9.0, 1.0, 10.0, int64(2))
+	f.Add(-1.0, 1.0, -1.0, 1.0, -3.0, int64(3))
+	f.Fuzz(func(t *testing.T, min, max, v, rate, scale float64, seed int64) {
+		if !valid_range(min, max) || math.IsNaN(v) || math.IsNaN(scale) || math.IsInf(scale, 0) {
+			t.Skip()
+		}
+		g := &Gene{GeneRange{min, max}, "g", GeneFloat, nil}
+		parent := &GeneValue{g, g.Fit(v)}
+		value := parent.Value
+		r := rand.New(rand.NewSource(seed))
+		for _, child := range []*GeneValue{parent.MutateRand(r), parent.mutate(r, Mutation{rate, scale})} {
+			if child == parent || child.Gene != g {
+				t.Fatal("mutation must make a new value of the same gene")
+			}
+			if child.Value < min || child.Value > max {
+				t.Fatalf("mutated %v to %v, outside [%v, %v]", value, child.Value, min, max)
+			}
+		}
+		if parent.Value != value {
+			t.Fatalf("mutation changed the parent from %v to %v", value, parent.Value)
+		}
+	})
+}
+
+// fuzz_species are the species fuzzers pick from: the built-in ones and
+// the examples, which cover modules, programs and L-systems.
+func fuzz_species(t testing.TB) []*Species {
+	species, err := LoadSpeciesDir("species")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]*Species{NewTreeSpecies(), NewTree3dSpecies()}, species...)
+}
+
+// fuzz_creature makes a creature of one of species from fuzz input: a
+// random one from seed with genes then set from data, each byte spread
+// over its gene's range.
+func fuzz_creature(species []*Species, pick uint8, seed int64, data []byte) *Creature {
+	c := RandomCreature(species[int(pick)%len(species)], rand.New(rand.NewSource(seed)))
+	if len(data) > 0 {
+		for i, v := range c.Values {
+			r := v.Gene.Range
+			v.Value = v.Gene.Fit(r.Min + float64(data[i%len(data)])/255*(r.Max-r.Min))
+		}
+	}
+	return c
+}
+
+func check_in_range(t *testing.T, c *Creature) {
+	t.Helper()
+	for _, v := range c.Values {
+		if v.Value < v.Gene.Range.Min || v.Value > v.Gene.Range.Max || math.IsNaN(v.Value) {
+			t.Fatalf("%s gene %s = %v, outside [%v, %v]", c.CreatureSpecies.Name, v.Gene.Name, v.Value, v.Gene.Range.Min, v.Gene.Range.Max)
+		}
+	}
+}
+
+func FuzzMutateCreature(f *testing.F) {
+	species := fuzz_species(f)
+	for i := range species {
+		f.Add(uint8(i), int64(i), int64(i+1), []byte{})
+		f.Add(uint8(i), int64(i), int64(i+2), []byte{0, 255, 128})
+	}
+	f.Fuzz(func(t *testing.T, pick uint8, seed int64, mutate_seed int64, data []byte) {
+		parent := fuzz_creature(species, pick, seed, data)
+		check_in_range(t, parent)
+		before := parent.ValuesMap()
+		counts := parent.Counts()
+		r := rand.New(rand.NewSource(mutate_seed))
+		child := parent
+		for i := 0; i < 5; i++ {
+			child = MutateCreatureRand(child, r)
+			check_in_range(t, child)
+		}
+		other := MutateCreatureRand(parent, r)
+		bred, err := BreedCreaturesRand(parent, other, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []*Creature{bred, child} {
+			check_in_range(t, c)
+			for _, v := range c.Values {
+				for _, p := range parent.Values {
+					if v == p {
+						t.Fatalf("child shares gene %s's value with its parent", v.Gene.Name)
+					}
+				}
+			}
+		}
+		assert.Equal(t, before, parent.ValuesMap(), "the parent must not change")
+		assert.Equal(t, counts, parent.Counts())
+	})
+}
+
+func FuzzSetValuesFromMap(f *testing.F) {
+	f.Add("num_gens", 3.0)
+	f.Add("branch_angle", 1e300)
+	f.Add("no_such_gene", 1.0)
+	f.Add("", 0.0)
+	f.Add("num_gens", math.NaN())
+	f.Fuzz(func(t *testing.T, name string, v float64) {
+		c := NewCreature(NewTreeSpecies())
+		before := c.ValuesMap()
+		err := c.SetValuesFromMap(map[string]float64{name: v})
+		_, known := before[name]
+		if !known || math.IsNaN(v) {
+			if err == nil {
+				t.Fatalf("set %q to %v without an error", name, v)
+			}
+			assert.Equal(t, before, c.ValuesMap())
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		check_in_range(t, c)
+	})
+}
+
+// render_deadline is far more than any in-range genome takes to draw.
+const render_deadline = 20 * time.Second
+
+func FuzzRender(f *testing.F) {
+	species := fuzz_species(f)
+	for i := range species {
+		f.Add(uint8(i), int64(i), []byte{})
+		// The largest value of every gene.
+		f.Add(uint8(i), int64(i), []byte{255})
+		f.Add(uint8(i), int64(i), []byte{0})
+	}
+	f.Fuzz(func(t *testing.T, pick uint8, seed int64, data []byte) {
+		c := fuzz_creature(species, pick, seed, data)
+		opts := RenderOptions{Size: 32, Camera: Camera{Elevation: float64(seed%7) / 7, Distance: float64(seed % 500)}, Budget: RenderBudget{Timeout: render_deadline}}
+		if _, err := RenderContext(context.Background(), c, opts); errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("rendering %s %v took over %v", c.CreatureSpecies.Name, c.ValuesMap(), render_deadline)
+		}
+	})
+}
+
+func TestBreedCreatures(t *testing.T) {
+	a := NewCreature(NewTreeSpecies())
+	b := NewCreature(NewTreeSpecies())
+	for _, v := range b.Values {
+		v.Value = v.Gene.Range.Max
+	}
+	child, err := BreedCreatures(a, b)
+	assert.NoError(t, err)
+	for i, v := range child.Values {
+		assert.Contains(t, []float64{a.Values[i].Value, b.Values[i].Value}, v.Value)
+		assert.False(t, v == a.Values[i] || v == b.Values[i])
+	}
+
+	// Parents of different species, or of the same name with different
+	// genes, can't be crossed either way round.
+	tree3d := NewCreature(NewTree3dSpecies())
+	renamed := NewCreature(NewSpecies("tree", Tree3dGenes()))
+	for _, other := range []*Creature{tree3d, renamed} {
+		_, err = BreedCreatures(a, other)
+		assert.ErrorIs(t, err, ErrSpeciesMismatch)
+		_, err = BreedCreatures(other, a)
+		assert.ErrorIs(t, err, ErrSpeciesMismatch)
+	}
+}
+
+func TestRandomCreature(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := RandomCreature(NewTreeSpecies(), r)
+	for _, v := range c.Values {
+		assert.True(t, v.Value >= v.Gene.Range.Min && v.Value <= v.Gene.Range.Max)
+	}
+	assert.Equal(t, c.ValuesMap(), RandomCreature(NewTreeSpecies(), rand.New(rand.NewSource(1))).ValuesMap())
+}
+
+func TestWriteSvg(t *testing.T) {
+	c := NewCreature(NewTreeSpecies())
+	var buff bytes.Buffer
+	assert.NoError(t, WriteSvg(&buff, c, 300))
+	assert.Contains(t, buff.String(), `width="300"`)
+	assert.Equal(t, len(TreeSegments(c)), strings.Count(buff.String(), "<line "))
 }
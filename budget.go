@@ -0,0 +1,117 @@
+package biomorph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"time"
+)
+
+var ErrRenderBudget = errors.New("render budget exceeded")
+
+// RenderBudget bounds the work of drawing a creature, so a huge genome
+// can't tie up a server. Zero fields don't limit.
+type RenderBudget struct {
+	// MaxSegments bounds the branches drawn. Trees drop whole generations
+	// to fit, so they stay balanced; other species are cut short.
+	MaxSegments int
+	// MaxDepth bounds the generations of trees.
+	MaxDepth int
+	// Timeout bounds the time spent laying out and drawing.
+	Timeout time.Duration
+}
+
+// DefaultRenderBudget is generous for any creature worth looking at, and
+// draws the rest in well under a second.
+var DefaultRenderBudget = RenderBudget{MaxSegments: 20000, MaxDepth: 8, Timeout: 5 * time.Second}
+
+// tree_size is the number of branches of a tree with gens generations and n
+// branches at each fork.
+func tree_size(gens int, n int) float64 {
+	if n == 1 {
+		return float64(gens)
+	}
+	return (math.Pow(float64(n), float64(gens)) - 1) / float64(n-1)
+}
+
+// generations is how many of a tree's gens generations, with n branches at
+// each fork, fit in b. It returns an error if that's fewer than gens.
+func (b RenderBudget) generations(gens int, n int) (int, error) {
+	fit := gens
+	if b.MaxDepth > 0 && fit > b.MaxDepth {
+		fit = b.MaxDepth
+	}
+	for b.MaxSegments > 0 && fit > 0 && tree_size(fit, n) > float64(b.MaxSegments) {
+		fit--
+	}
+	if fit < gens {
+		return fit, fmt.Errorf("%w: drew %d of %d generations", ErrRenderBudget, fit, gens)
+	}
+	return gens, nil
+}
+
+// cut is how many of n segments fit in b. It returns an error if that's
+// fewer than n.
+func (b RenderBudget) cut(n int) (int, error) {
+	if b.MaxSegments > 0 && n > b.MaxSegments {
+		return b.MaxSegments, fmt.Errorf("%w: drew %d of %d branches", ErrRenderBudget, b.MaxSegments, n)
+	}
+	return n, nil
+}
+
+// budget_segments lays out c within b. Trees stop growing at the budget;
+// other species are laid out in full, which their own limits and ctx
+// bound, and then cut.
+func budget_segments(ctx context.Context, c *Creature, b RenderBudget) ([]Segment, error) {
+	if c.CreatureSpecies.Renderer == "tree" {
+		gens, err := b.generations(NumGens(c), NumBranches(c))
+		return tree_segments(c, gens), err
+	}
+	segments, err := creature_segments(ctx, c)
+	if err != nil {
+		return segments, err
+	}
+	n, err := b.cut(len(segments))
+	return segments[:n], err
+}
+
+func budget_segments3(ctx context.Context, c *Creature, b RenderBudget) ([]Segment3, error) {
+	if c.CreatureSpecies.Renderer == "tree3d" {
+		gens, err := b.generations(int(c.GetValue("num_gens")), int(c.GetValue("num_branches")))
+		return tree3d_segments(c, gens), err
+	}
+	segments, err := creature_segments3(ctx, c)
+	if err != nil {
+		return segments, err
+	}
+	n, err := b.cut(len(segments))
+	return segments[:n], err
+}
+
+// RenderContext draws c with opts within opts.Budget, stopping early if ctx
+// is done. When it stops early it returns what it drew so far and an error
+// wrapping ErrRenderBudget or ctx's error.
+func RenderContext(ctx context.Context, c *Creature, opts RenderOptions) (image.Image, error) {
+	if opts.Budget.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Budget.Timeout)
+		defer cancel()
+	}
+	var img image.Image
+	var layout_err, draw_err error
+	if Is3d(c) {
+		var segments []Segment3
+		segments, layout_err = budget_segments3(ctx, c, opts.Budget)
+		img, draw_err = draw_segments3(ctx, segments, opts)
+	} else {
+		var segments []Segment
+		segments, layout_err = budget_segments(ctx, c, opts.Budget)
+		img, draw_err = draw_segments(ctx, segments, opts)
+	}
+	if draw_err != nil {
+		return img, draw_err
+	}
+	return img, layout_err
+}
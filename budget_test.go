@@ -0,0 +1,130 @@
+package biomorph
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetGenerations(t *testing.T) {
+	for _, tc := range []struct {
+		budget   RenderBudget
+		gens, n  int
+		want     int
+		exceeded bool
+	}{
+		{RenderBudget{}, 5, 9, 5, false},
+		{RenderBudget{MaxDepth: 8}, 5, 9, 5, false},
+		{RenderBudget{MaxDepth: 3}, 5, 2, 3, true},
+		{RenderBudget{MaxSegments: 31}, 5, 2, 5, false},
+		{RenderBudget{MaxSegments: 30}, 5, 2, 4, true},
+		{RenderBudget{MaxSegments: 4}, 5, 1, 4, true},
+		{DefaultRenderBudget, 5, 9, 5, false},
+		{DefaultRenderBudget, 9, 3, 8, true},
+		{DefaultRenderBudget, 8, 4, 7, true},
+		{DefaultRenderBudget, 6, 9, 5, true},
+	} {
+		got, err := tc.budget.generations(tc.gens, tc.n)
+		assert.Equal(t, tc.want, got, "%+v", tc)
+		assert.Equal(t, tc.exceeded, errors.Is(err, ErrRenderBudget), "%+v", tc)
+	}
+}
+
+// huge_tree has over half a million branches, which the tree species' genes don't
+// allow but a configured species' might.
+func huge_tree() *Creature {
+	genes := TreeGenes()
+	genes[1].Range.Max = 7
+	c := NewCreature(NewSpecies("huge", genes))
+	c.SetValuesFromMap(map[string]float64{"num_gens": 7, "num_branches": 9})
+	return c
+}
+
+// dark_pixels counts the drawn pixels of an ImageSize image.
+func dark_pixels(img image.Image) int {
+	n := 0
+	for y := 0; y < ImageSize; y++ {
+		for x := 0; x < ImageSize; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 128 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestRenderContextBudget(t *testing.T) {
+	opts := DefaultRenderOptions()
+	img, err := RenderContext(context.Background(), huge_tree(), opts)
+	assert.ErrorIs(t, err, ErrRenderBudget)
+	assert.NotZero(t, dark_pixels(img))
+
+	img, err = DrawTreeCreature(huge_tree())
+	assert.ErrorIs(t, err, ErrRenderBudget)
+	assert.NotZero(t, dark_pixels(img))
+
+	// Small creatures are drawn in full.
+	img, err = DrawTreeCreature(NewCreature(NewTreeSpecies()))
+	assert.NoError(t, err)
+	assert.NotZero(t, dark_pixels(img))
+
+	species, err := LoadSpeciesDir("species")
+	assert.NoError(t, err)
+	SetConfiguredSpecies(species)
+	defer SetConfiguredSpecies(nil)
+	opts.Budget = RenderBudget{MaxSegments: 10}
+	for _, name := range []string{"centipede", "tree3d"} {
+		s, err := GetSpecies(name)
+		assert.NoError(t, err)
+		c := NewCreature(s)
+		_, err = RenderContext(context.Background(), c, opts)
+		assert.ErrorIs(t, err, ErrRenderBudget, name)
+	}
+}
+
+func TestRenderContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, opts := range []RenderOptions{{Size: ImageSize}, {Size: ImageSize, Gray: true}} {
+		img, err := RenderContext(ctx, NewCreature(NewTreeSpecies()), opts)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, ImageSize, img.Bounds().Dx())
+	}
+
+	r, err := NewRenderCache(4, "")
+	assert.NoError(t, err)
+	_, err = r.RenderContext(ctx, NewCreature(NewTreeSpecies()), DefaultRenderOptions())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, r.Stats().Entries)
+	_, err = r.RenderContext(context.Background(), NewCreature(NewTreeSpecies()), DefaultRenderOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Stats().Entries)
+}
+
+func TestLayoutContext(t *testing.T) {
+	species, err := LoadSpeciesDir("species")
+	assert.NoError(t, err)
+	SetConfiguredSpecies(species)
+	defer SetConfiguredSpecies(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, name := range []string{"bush", "centipede", "weed"} {
+		s, err := GetSpecies(name)
+		assert.NoError(t, err)
+		c := NewCreature(s)
+		_, err = creature_segments(ctx, c)
+		assert.ErrorIs(t, err, context.Canceled, name)
+		assert.NotEmpty(t, CreatureSegments(c), name)
+	}
+
+	// A program that would run for long is stopped within its first steps.
+	p, err := CompileProgram("def main() { repeat(1e15) { turn(1) } }", nil)
+	assert.NoError(t, err)
+	_, err = p.RunContext(ctx, NewCreature(NewTreeSpecies()), ProgramLimits{MaxSteps: math.MaxInt})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jackdreilly/biomorph"
+)
+
+// Render draws a genome as a PNG or SVG image.
+func Render(args []string) error {
+	fs := new_flag_set("render", "[flags] genome")
+	size := fs.Int("size", biomorph.ImageSize, "image size in pixels")
+	out := fs.String("out", "creature.png", "output image, .png or .svg")
+	camera := camera_flags(fs, 0)
+	quality := quality_flags(fs)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	opts := biomorph.RenderOptions{Size: *size, Camera: camera()}
+	if err := quality(&opts); err != nil {
+		return err
+	}
+	c, _, err := read_genome(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return write_image(*out, c, opts)
+}
+
+// max_mesh_cells bounds Export's grid, which takes memory by its cube.
+const max_mesh_cells = 256
+
+// Export writes a genome as a watertight 3D mesh for printing: binary STL,
+// OBJ or binary PLY. Creatures of 2D species are exported flat.
+func Export(args []string) error {
+	fs := new_flag_set("export", "[flags] genome")
+	out := fs.String("out", "creature.stl", "output mesh, .stl, .obj or .ply")
+	cells := fs.Int("cells", biomorph.DefaultMeshCells, "grid cells across the creature's longest side, finer is smoother and larger")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(*out)), ".")
+	if !slices.Contains(biomorph.MeshFormats, format) {
+		return fmt.Errorf("%w %q, want one of %v", biomorph.ErrMeshFormat, *out, biomorph.MeshFormats)
+	}
+	if *cells < 1 || *cells > max_mesh_cells {
+		return fmt.Errorf("-cells must be between 1 and %d", max_mesh_cells)
+	}
+	c, _, err := read_genome(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	f, err := create_output(*out)
+	if err != nil {
+		return err
+	}
+	if err := biomorph.CreatureMesh(c, *cells).Write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Mutate writes a mutant of a genome, adding the intermediate creatures to
+// its lineage.
+func Mutate(args []string) error {
+	fs := new_flag_set("mutate", "[flags] genome")
+	generations := fs.Int("generations", 1, "number of successive mutations")
+	out := fs.String("out", "-", "output genome")
+	rng := seed_flag(fs)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	c, lineage, err := read_genome(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	r := rng()
+	for i := 0; i < *generations; i++ {
+		lineage = append(lineage, c)
+		c = biomorph.MutateCreatureRand(c, r)
+	}
+	return write_genome(*out, c, lineage)
+}
+
+// Breed writes a child of two genomes of the same species. Its lineage
+// follows the first parent.
+func Breed(args []string) error {
+	fs := new_flag_set("breed", "[flags] a b")
+	out := fs.String("out", "-", "output genome")
+	rng := seed_flag(fs)
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	a, lineage, err := read_genome(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, _, err := read_genome(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	child, err := biomorph.BreedCreaturesRand(a, b, rng())
+	if err != nil {
+		return err
+	}
+	return write_genome(*out, child, append(lineage, a))
+}
+
+// Random writes a creature with every gene drawn uniformly from its range.
+func Random(args []string) error {
+	fs := new_flag_set("random", "[flags]")
+	out := fs.String("out", "-", "output genome")
+	rng := seed_flag(fs)
+	species := species_flag(fs)
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	s, err := species()
+	if err != nil {
+		return err
+	}
+	return write_genome(*out, biomorph.RandomCreature(s, rng()), nil)
+}
+
+// Code prints the genome code of a genome, or with -decode writes the
+// genome a code stands for.
+func Code(args []string) error {
+	fs := new_flag_set("code", "[flags] genome | -decode code")
+	decode := fs.String("decode", "", "genome code to decode")
+	out := fs.String("out", "-", "output genome when decoding")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	if (*decode == "") == (fs.NArg() == 0) {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	if *decode != "" {
+		c, err := biomorph.DecodeCode(*decode)
+		if err != nil {
+			return err
+		}
+		return write_genome(*out, c, nil)
+	}
+	c, _, err := read_genome(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(biomorph.EncodeCode(c))
+	return err
+}
+
+// ListSpecies prints every species with its renderer, mutation and genes.
+func ListSpecies(args []string) error {
+	fs := new_flag_set("species", "")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	for _, name := range biomorph.SpeciesNames() {
+		s, err := biomorph.GetSpecies(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (renderer %s, mutation rate %v scale %v)\n", s.Name, s.Renderer, s.Mutation.Rate, s.Mutation.Scale)
+		for _, g := range s.Genes {
+			fmt.Printf("  %-16s %-5s [%v, %v]\n", g.Name, g.Type, g.Range.Min, g.Range.Max)
+		}
+		for _, m := range s.Modules {
+			fmt.Printf("  module %s: %d to %d copies\n", m.Name, m.Min, m.Max)
+			for _, g := range m.Genes {
+				fmt.Printf("    %-14s %-5s [%v, %v]\n", g.Name, g.Type, g.Range.Min, g.Range.Max)
+			}
+		}
+	}
+	return nil
+}
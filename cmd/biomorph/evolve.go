@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/jackdreilly/biomorph"
+)
+
+// Evolve runs a headless evolution from a genome, or a default creature,
+// and writes the fittest creature found with each improvement in its
+// lineage. Interrupting it keeps the best so far.
+func Evolve(args []string) error {
+	var names []string
+	for name := range biomorph.Fitnesses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fs := new_flag_set("evolve", "[flags] [genome]")
+	fitness_name := fs.String("fitness", "ink", "fitness function, one of "+strings.Join(names, ", "))
+	generations := fs.Int("generations", 50, "number of generations")
+	population := fs.Int("population", 20, "mutants tried per generation")
+	out := fs.String("out", "-", "output genome for the fittest creature")
+	image_out := fs.String("image", "", "also render the fittest creature to this .png or .svg")
+	quiet := fs.Bool("quiet", false, "don't report progress on stderr")
+	species := species_flag(fs)
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	fitness, err := biomorph.GetFitness(*fitness_name)
+	if err != nil {
+		return err
+	}
+	s, err := species()
+	if err != nil {
+		return err
+	}
+	c := biomorph.NewCreature(s)
+	var lineage []*biomorph.Creature
+	if fs.NArg() == 1 {
+		if c, lineage, err = read_genome(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	last := c
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	best, err := biomorph.Evolve(ctx, c, *generations, *population, fitness, func(g biomorph.Generation) error {
+		if g.Best != last {
+			lineage = append(lineage, last)
+			last = g.Best
+		}
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "generation %d: %s %.4f\n", g.Number, *fitness_name, g.BestFitness)
+		}
+		return nil
+	})
+	if err != nil && err != context.Canceled {
+		return err
+	}
+	if *image_out != "" {
+		if err := write_image(*image_out, best, biomorph.DefaultRenderOptions()); err != nil {
+			return err
+		}
+	}
+	return write_genome(*out, best, lineage)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/jackdreilly/biomorph/db/store"
+)
+
+// open_input opens path for reading, "-" being stdin.
+func open_input(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// create_output creates path for writing, "-" being stdout.
+func create_output(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// read_genome reads a genome file in either form and returns the creature
+// and its lineage, root first.
+func read_genome(path string) (*biomorph.Creature, []*biomorph.Creature, error) {
+	f, err := open_input(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	g, err := biomorph.ReadGenome(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g.Creatures()
+}
+
+// write_genome writes c and its lineage as a genome file, in the text form
+// for .genome and .txt files and as JSON otherwise.
+func write_genome(path string, c *biomorph.Creature, lineage []*biomorph.Creature) error {
+	f, err := create_output(path)
+	if err != nil {
+		return err
+	}
+	g := biomorph.NewGenome(c, lineage)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".genome", ".txt":
+		err = g.WriteText(f)
+	default:
+		err = g.WriteJson(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// write_image renders c to path, as SVG or PNG depending on its extension.
+func write_image(path string, c *biomorph.Creature, opts biomorph.RenderOptions) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".png" && ext != ".svg" {
+		return fmt.Errorf("unsupported image %q, want .png or .svg", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if ext == ".svg" {
+		err = biomorph.WriteSvgOptions(f, c, opts)
+	} else {
+		err = png.Encode(f, biomorph.Render(c, opts))
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type store_flags struct {
+	backend *string
+	db_path *string
+}
+
+func new_store_flags(fs *flag.FlagSet) store_flags {
+	return store_flags{
+		fs.String("store", "sqlite", "creature store backend, one of "+strings.Join(store.Backends, ", ")),
+		fs.String("db", "bio.db", "database file for the sqlite and bolt stores"),
+	}
+}
+
+func (s store_flags) open() (store.CreatureStore, error) {
+	return store.Open(*s.backend, *s.db_path)
+}
+
+// species_flag adds -species, naming the species of new creatures.
+func species_flag(fs *flag.FlagSet) func() (*biomorph.Species, error) {
+	name := fs.String("species", "tree", "species of new creatures")
+	return func() (*biomorph.Species, error) {
+		return biomorph.GetSpecies(*name)
+	}
+}
+
+// camera_flags adds -azimuth, -elevation and -distance, the view of 3D
+// creatures.
+func camera_flags(fs *flag.FlagSet, elevation float64) func() biomorph.Camera {
+	azimuth := fs.Float64("azimuth", 0, "3D view: angle around the creature in radians")
+	elev := fs.Float64("elevation", elevation, "3D view: angle looking down on the creature in radians")
+	distance := fs.Float64("distance", 0, "3D view: perspective camera distance, 0 for orthographic")
+	return func() biomorph.Camera {
+		return biomorph.Camera{Azimuth: *azimuth, Elevation: *elev, Distance: *distance}
+	}
+}
+
+// quality_flags adds -supersample, -aliased, -gray, -cap and -join, how
+// images are drawn. The returned function sets them on opts.
+func quality_flags(fs *flag.FlagSet) func(opts *biomorph.RenderOptions) error {
+	n := fs.Int("supersample", 1, fmt.Sprintf("draw at up to %d times the size and scale down, for smoother edges", biomorph.MaxSupersample))
+	aliased := fs.Bool("aliased", false, "draw hard-edged pixels")
+	gray := fs.Bool("gray", false, "draw in 16-bit gray with the built-in rasterizer")
+	line_cap := fs.String("cap", "round", "line ends: round, butt or square")
+	join := fs.String("join", "none", "2D branch corners: none, round or bevel")
+	return func(opts *biomorph.RenderOptions) error {
+		if *n < 1 || *n > biomorph.MaxSupersample {
+			return fmt.Errorf("invalid -supersample %d, want 1 to %d", *n, biomorph.MaxSupersample)
+		}
+		c, err := biomorph.ParseLineCap(*line_cap)
+		if err != nil {
+			return err
+		}
+		j, err := biomorph.ParseLineJoin(*join)
+		if err != nil {
+			return err
+		}
+		opts.Supersample, opts.Aliased, opts.Gray, opts.Cap, opts.Join = *n, *aliased, *gray, c, j
+		return nil
+	}
+}
+
+// seed_flag adds -seed, where 0 picks a seed from the clock.
+func seed_flag(fs *flag.FlagSet) func() *rand.Rand {
+	seed := fs.Int64("seed", 0, "random seed, 0 for a different result every run")
+	return func() *rand.Rand {
+		if *seed == 0 {
+			return rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		return rand.New(rand.NewSource(*seed))
+	}
+}
+
+// parse parses args into fs, and checks the number of positional arguments
+// is between min and max, max < 0 meaning any number.
+func parse(fs *flag.FlagSet, args []string, min int, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	return nil
+}
+
+func new_flag_set(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: biomorph %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -0,0 +1,87 @@
+// Command biomorph works with creatures offline, without the db server or
+// the web app. Creatures are read and written as genome files, JSON or the
+// text form for .genome files, and "-" reads or writes them on stdin or
+// stdout, so commands can be piped:
+//
+//	biomorph random | biomorph mutate -generations 5 - | biomorph render -out tree.svg -
+//
+// Species other than the built-in tree come from definition files in the
+// directory given by -species_dir before the command.
+//
+// Run "biomorph help" for the subcommands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jackdreilly/biomorph"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"render":    {"draw a genome as a PNG or SVG image", Render},
+	"mutate":    {"write a mutant of a genome", Mutate},
+	"breed":     {"write a child of two genomes", Breed},
+	"random":    {"write a random creature", Random},
+	"code":      {"print a genome's shareable code, or decode one", Code},
+	"evolve":    {"evolve a creature headlessly with a fitness function", Evolve},
+	"lineage":   {"export the lineage of a stored creature as a GIF or APNG", Lineage},
+	"morph":     {"smooth animation between keyframe creatures or down a stored lineage", Morph},
+	"species":   {"list the species and their genes, checking -species_dir", ListSpecies},
+	"turntable": {"animate a 3D creature turning round as a GIF or APNG", Turntable},
+	"export":    {"write a creature as an STL, OBJ or PLY mesh for 3D printing", Export},
+}
+
+var species_dir = flag.String("species_dir", "", "directory of species definition files (.yaml, .yml, .json)")
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: biomorph [-species_dir dir] <command> [flags]\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		if name == "help" {
+			return
+		}
+		os.Exit(2)
+	}
+	if *species_dir != "" {
+		species, err := biomorph.LoadSpeciesDir(*species_dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "biomorph: failed to load species:\n%v\n", err)
+			os.Exit(1)
+		}
+		biomorph.SetConfiguredSpecies(species)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "biomorph %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
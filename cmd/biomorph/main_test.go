@@ -0,0 +1,95 @@
+package main
+
+import (
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/jackdreilly/biomorph/db/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	assert.NoError(t, Random([]string{"-seed", "3", "-out", file("a.json")}))
+	assert.NoError(t, Mutate([]string{"-seed", "3", "-generations", "5", "-out", file("b.genome"), file("a.json")}))
+	f, err := os.Open(file("b.genome"))
+	assert.NoError(t, err)
+	genome, err := biomorph.ReadGenome(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Len(t, genome.Lineage, 5)
+	assert.NoError(t, Breed([]string{"-out", file("c.json"), file("a.json"), file("b.genome")}))
+	assert.NoError(t, Evolve([]string{"-quiet", "-generations", "2", "-population", "2", "-out", file("d.json"), file("c.json")}))
+	assert.NoError(t, Render([]string{"-size", "64", "-out", file("d.svg"), file("d.json")}))
+	assert.NoError(t, Render([]string{"-out", file("d.png"), file("d.json")}))
+	svg, err := os.ReadFile(file("d.svg"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(svg), "<svg"))
+
+	assert.NoError(t, Morph([]string{"-steps", "2", "-out", file("m.gif"), file("a.json"), file("b.genome")}))
+	f, err = os.Open(file("m.gif"))
+	assert.NoError(t, err)
+	g, err := gif.DecodeAll(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Len(t, g.Image, 4)
+
+	cs, err := store.Open("bolt", file("bio.db"))
+	assert.NoError(t, err)
+	root, err := cs.SaveCreature(&store.Creature{Values: map[string]float64{"num_gens": 2}})
+	assert.NoError(t, err)
+	child, err := cs.SaveCreature(&store.Creature{Values: map[string]float64{"num_gens": 4}, Parents: []uint64{root}})
+	assert.NoError(t, err)
+	assert.NoError(t, cs.Close())
+	assert.NoError(t, Lineage([]string{"-store", "bolt", "-db", file("bio.db"), "-out", file("l.png"), strconv.FormatUint(child, 10)}))
+
+	code := biomorph.EncodeCode(biomorph.NewCreature(biomorph.NewTreeSpecies()))
+	assert.NoError(t, Code([]string{"-decode", code, "-out", file("e.genome")}))
+	assert.NoError(t, Render([]string{"-out", file("e.png"), file("e.genome")}))
+	assert.Error(t, Code([]string{"-decode", "nonsense"}))
+	assert.Error(t, Code([]string{"-decode", code, file("e.genome")}))
+
+	species, err := biomorph.LoadSpeciesDir("../../species")
+	assert.NoError(t, err)
+	biomorph.SetConfiguredSpecies(species)
+	defer biomorph.SetConfiguredSpecies(nil)
+	assert.NoError(t, ListSpecies(nil))
+	assert.NoError(t, Random([]string{"-species", "fern", "-out", file("fern.genome")}))
+	assert.NoError(t, Mutate([]string{"-out", file("fern2.genome"), file("fern.genome")}))
+	assert.Error(t, Random([]string{"-species", "oak"}))
+
+	assert.NoError(t, Random([]string{"-species", "tree3d", "-seed", "1", "-out", file("3d.json")}))
+	assert.NoError(t, Render([]string{"-azimuth", "1", "-distance", "300", "-out", file("3d.png"), file("3d.json")}))
+	err = Breed([]string{"-out", file("mixed.json"), file("3d.json"), file("d.json")})
+	assert.ErrorIs(t, err, biomorph.ErrSpeciesMismatch)
+	assert.ErrorContains(t, err, `parents are species "tree3d" and "tree"`)
+	assert.NoFileExists(t, file("mixed.json"))
+	assert.NoError(t, Render([]string{"-supersample", "2", "-aliased", "-cap", "butt", "-join", "bevel", "-out", file("q.png"), file("d.json")}))
+	assert.NoError(t, Render([]string{"-gray", "-join", "round", "-out", file("q.svg"), file("d.json")}))
+	assert.Error(t, Render([]string{"-supersample", "9", "-out", file("q.png"), file("d.json")}))
+	assert.Error(t, Render([]string{"-cap", "pointy", "-out", file("q.png"), file("d.json")}))
+	assert.NoError(t, Turntable([]string{"-frames", "4", "-size", "48", "-out", file("3d.gif"), file("3d.json")}))
+	f, err = os.Open(file("3d.gif"))
+	assert.NoError(t, err)
+	g, err = gif.DecodeAll(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Len(t, g.Image, 4)
+	assert.NoError(t, Export([]string{"-out", file("3d.stl"), file("3d.json")}))
+	assert.NoError(t, Export([]string{"-out", file("flat.obj"), file("d.json")}))
+	assert.NoError(t, Export([]string{"-cells", "32", "-out", file("3d.ply"), file("3d.json")}))
+	assert.Error(t, Export([]string{"-cells", "0", "-out", file("3d.ply"), file("3d.json")}))
+	assert.Error(t, Export([]string{"-out", file("3d.blend"), file("3d.json")}))
+
+	assert.Error(t, Render([]string{"-out", file("d.bmp"), file("d.json")}))
+	assert.Error(t, Mutate([]string{file("missing.json")}))
+	assert.Error(t, Evolve([]string{"-fitness", "beauty"}))
+	assert.NoError(t, os.WriteFile(file("bad.json"), []byte(`{"version": 1, "species": "tree", "genes": [{"name": "wings", "value": 1}]}`), 0644))
+	assert.ErrorIs(t, Render([]string{"-out", file("bad.png"), file("bad.json")}), biomorph.ErrInvalidGenome)
+}
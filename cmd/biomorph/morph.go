@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/jackdreilly/biomorph/animate"
+	"github.com/jackdreilly/biomorph/db/store"
+)
+
+var animation_encoders = map[string]func(io.Writer, []animate.Frame, animate.Options) error{
+	".gif": animate.EncodeGif,
+	".png": animate.EncodeApng,
+}
+
+// stored_lineage loads a creature and its ancestors, root first. The store
+// doesn't record species, so they are loaded as species.
+func stored_lineage(cs store.CreatureStore, species *biomorph.Species, id uint64) ([]uint64, []*biomorph.Creature, error) {
+	sc, err := cs.GetCreature(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := append(sc.Parents, id)
+	creatures := make([]*biomorph.Creature, len(ids))
+	for i, cid := range ids {
+		if sc, err = cs.GetCreature(cid); err != nil {
+			return nil, nil, err
+		}
+		creatures[i] = species.LoadCreature(sc.Values)
+	}
+	return ids, creatures, nil
+}
+
+type animation_flags struct {
+	size    *int
+	out     *string
+	palette *string
+	delay   *int
+	hold    *int
+	labels  *bool
+	quality func(opts *biomorph.RenderOptions) error
+}
+
+func new_animation_flags(fs *flag.FlagSet, out string, delay int, hold int, palette string) animation_flags {
+	return animation_flags{
+		fs.Int("size", biomorph.ImageSize, "frame size in pixels"),
+		fs.String("out", out, "output file, .gif or .png (APNG)"),
+		fs.String("palette", palette, "frame palette, one of "+strings.Join(animate.PaletteNames(), ", ")),
+		fs.Int("delay", delay, "delay between frames in hundredths of a second"),
+		fs.Int("hold", hold, "extra delay on the last frame in hundredths of a second"),
+		fs.Bool("labels", false, "label frames with their keyframe"),
+		quality_flags(fs),
+	}
+}
+
+// render_options are the size and quality flags as RenderOptions.
+func (a animation_flags) render_options() (biomorph.RenderOptions, error) {
+	opts := biomorph.RenderOptions{Size: *a.size}
+	return opts, a.quality(&opts)
+}
+
+// write renders keyframes with steps interpolated frames between each pair.
+func (a animation_flags) write(ids []uint64, keyframes []*biomorph.Creature, steps int, interp biomorph.Interpolation) error {
+	render_opts, err := a.render_options()
+	if err != nil {
+		return err
+	}
+	morphed := biomorph.Morph(keyframes, steps, interp)
+	frames := make([]animate.Frame, len(morphed))
+	for i, c := range morphed {
+		k := i / (steps + 1)
+		frames[i] = animate.Frame{Image: biomorph.Render(c, render_opts), Label: animate.LineageLabel(ids[k], k)}
+	}
+	return a.encode(frames)
+}
+
+// encode writes frames to -out.
+func (a animation_flags) encode(frames []animate.Frame) error {
+	encode, ok := animation_encoders[strings.ToLower(filepath.Ext(*a.out))]
+	if !ok {
+		return fmt.Errorf("unsupported animation %q, want .gif or .png", *a.out)
+	}
+	opts := animate.DefaultOptions()
+	opts.Delay, opts.Hold, opts.Labels = *a.delay, *a.hold, *a.labels
+	var err error
+	if opts.Palette, err = animate.GetPalette(*a.palette); err != nil {
+		return err
+	}
+	f, err := os.Create(*a.out)
+	if err != nil {
+		return err
+	}
+	if err := encode(f, frames, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Lineage exports the lineage of a stored creature as an animation.
+func Lineage(args []string) error {
+	fs := new_flag_set("lineage", "[flags] id")
+	db := new_store_flags(fs)
+	anim := new_animation_flags(fs, "lineage.gif", animate.DefaultOptions().Delay, animate.DefaultOptions().Hold, "mono")
+	species := species_flag(fs)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	s, err := species()
+	if err != nil {
+		return err
+	}
+	var id uint64
+	if _, err := fmt.Sscan(fs.Arg(0), &id); err != nil || id == 0 {
+		return fmt.Errorf("invalid creature ID %q", fs.Arg(0))
+	}
+	cs, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer cs.Close()
+	ids, creatures, err := stored_lineage(cs, s, id)
+	if err != nil {
+		return err
+	}
+	return anim.write(ids, creatures, 0, biomorph.Linear)
+}
+
+// Morph writes an animation that blends between keyframes: the creatures of
+// several genomes, the lineage of a single genome, or the lineage of -id in
+// a store.
+func Morph(args []string) error {
+	fs := new_flag_set("morph", "[flags] [genome ...]")
+	db := new_store_flags(fs)
+	id := fs.Uint64("id", 0, "animate the lineage of this stored creature instead of genomes")
+	steps := fs.Int("steps", 8, "in-between frames per pair of keyframes")
+	interp_name := fs.String("interp", "linear", "interpolation, linear or spline")
+	anim := new_animation_flags(fs, "morph.gif", 5, animate.DefaultOptions().Hold, "mono")
+	species := species_flag(fs)
+	if err := parse(fs, args, 0, -1); err != nil {
+		return err
+	}
+	if *steps < 0 {
+		return fmt.Errorf("invalid -steps %d", *steps)
+	}
+	interp, err := biomorph.ParseInterpolation(*interp_name)
+	if err != nil {
+		return err
+	}
+	var ids []uint64
+	var keyframes []*biomorph.Creature
+	switch {
+	case *id != 0 && fs.NArg() > 0:
+		return errors.New("give either -id or genomes, not both")
+	case *id != 0:
+		s, err := species()
+		if err != nil {
+			return err
+		}
+		cs, err := db.open()
+		if err != nil {
+			return err
+		}
+		defer cs.Close()
+		if ids, keyframes, err = stored_lineage(cs, s, *id); err != nil {
+			return err
+		}
+	case fs.NArg() == 1:
+		c, lineage, err := read_genome(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		keyframes = append(lineage, c)
+		for i := range keyframes {
+			ids = append(ids, uint64(i+1))
+		}
+	case fs.NArg() > 1:
+		for i, path := range fs.Args() {
+			c, _, err := read_genome(path)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, uint64(i+1))
+			keyframes = append(keyframes, c)
+		}
+	default:
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	return anim.write(ids, keyframes, *steps, interp)
+}
+
+// Turntable writes an animation of a genome turning round once, for 3D
+// creatures. It loops seamlessly, so there's no hold on the last frame.
+func Turntable(args []string) error {
+	fs := new_flag_set("turntable", "[flags] genome")
+	n := fs.Int("frames", 36, "number of frames in one turn")
+	anim := new_animation_flags(fs, "turntable.gif", 8, 0, "gray")
+	camera := camera_flags(fs, 0.3)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	if *n < 1 || *n > 360 {
+		return fmt.Errorf("invalid -frames %d, want 1 to 360", *n)
+	}
+	opts, err := anim.render_options()
+	if err != nil {
+		return err
+	}
+	opts.Camera = camera()
+	c, _, err := read_genome(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	images := biomorph.Turntable(c, opts, *n)
+	frames := make([]animate.Frame, len(images))
+	for i, img := range images {
+		frames[i] = animate.Frame{Image: img, Label: fmt.Sprintf("%d/%d", i+1, len(images))}
+	}
+	return anim.encode(frames)
+}
@@ -0,0 +1,123 @@
+package biomorph
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// code_version is the first byte of every genome code.
+const code_version = 1
+
+var (
+	ErrInvalidCode = errors.New("invalid genome code")
+	// Crockford's alphabet has no I, L, O or U, so codes survive being read
+	// aloud or retyped, and are safe in URLs.
+	code_encoding = base32.NewEncoding("0123456789abcdefghjkmnpqrstvwxyz").WithPadding(base32.NoPadding)
+)
+
+// species_tag identifies a species and its gene definitions, so a code
+// can't be decoded against genes it wasn't made from.
+func species_tag(s *Species) uint16 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s|", s.Name)
+	for _, g := range s.Genes {
+		fmt.Fprintf(h, "%s[%v,%v]|", g.Name, g.Range.Min, g.Range.Max)
+	}
+	for _, m := range s.Modules {
+		fmt.Fprintf(h, "%s{%d,%d}", m.Name, m.Min, m.Max)
+		for _, g := range m.Genes {
+			fmt.Fprintf(h, "%s[%v,%v]|", g.Name, g.Range.Min, g.Range.Max)
+		}
+	}
+	return uint16(h.Sum32())
+}
+
+// EncodeCode packs c into a short string to share: a version byte, a
+// species tag, one byte per gene quantizing its value within its range and
+// a checksum. Each module's copies follow the species' genes, after a byte
+// counting them. Decoding gives back the values to within 1/255 of each
+// range.
+func EncodeCode(c *Creature) string {
+	// The copy count byte holds any count up to MaxModuleCopies.
+	var _ byte = MaxModuleCopies
+	b := []byte{code_version, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], species_tag(c.CreatureSpecies))
+	quantize := func(v *GeneValue) byte {
+		r := v.Gene.Range
+		if r.Max <= r.Min {
+			return 0
+		}
+		return byte(math.Round((clamp(v.Value, r) - r.Min) / (r.Max - r.Min) * 255))
+	}
+	for _, v := range c.Values[:len(c.CreatureSpecies.Genes)] {
+		b = append(b, quantize(v))
+	}
+	for _, m := range c.CreatureSpecies.Modules {
+		copies := c.Copies(m)
+		b = append(b, byte(len(copies)))
+		for _, values := range copies {
+			for _, v := range values {
+				b = append(b, quantize(v))
+			}
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(crc32.ChecksumIEEE(b)))
+	return code_encoding.EncodeToString(b)
+}
+
+// DecodeCode reverses EncodeCode. Codes are case insensitive.
+func DecodeCode(code string) (*Creature, error) {
+	b, err := code_encoding.DecodeString(strings.ToLower(code))
+	if err != nil || len(b) < 5 {
+		return nil, fmt.Errorf("%w %q", ErrInvalidCode, code)
+	}
+	body, sum := b[:len(b)-2], binary.BigEndian.Uint16(b[len(b)-2:])
+	if uint16(crc32.ChecksumIEEE(body)) != sum {
+		return nil, fmt.Errorf("%w %q: checksum mismatch", ErrInvalidCode, code)
+	}
+	if body[0] != code_version {
+		return nil, fmt.Errorf("%w %q: unsupported version %d", ErrInvalidCode, code, body[0])
+	}
+	species, err := species_for_tag(binary.BigEndian.Uint16(body[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidCode, code, err)
+	}
+	values := body[3:]
+	counts := make([]int, len(species.Modules))
+	want := len(species.Genes)
+	for i, m := range species.Modules {
+		if want >= len(values) {
+			return nil, fmt.Errorf("%w %q: missing the number of copies of module %s", ErrInvalidCode, code, m.Name)
+		}
+		counts[i] = int(values[want])
+		if counts[i] < m.Min || counts[i] > m.Max {
+			return nil, fmt.Errorf("%w %q: %d copies of module %s, want [%d, %d]", ErrInvalidCode, code, counts[i], m.Name, m.Min, m.Max)
+		}
+		values = append(values[:want:want], values[want+1:]...)
+		want += counts[i] * len(m.Genes)
+	}
+	if len(values) != want {
+		return nil, fmt.Errorf("%w %q: %d genes, species %s has %d", ErrInvalidCode, code, len(values), species.Name, want)
+	}
+	c := NewCreatureCounts(species, counts)
+	for i, v := range c.Values {
+		r := v.Gene.Range
+		v.Value = v.Gene.Fit(r.Min + float64(values[i])/255*(r.Max-r.Min))
+	}
+	return c, nil
+}
+
+func species_for_tag(tag uint16) (*Species, error) {
+	for _, name := range SpeciesNames() {
+		if s, err := GetSpecies(name); err == nil && species_tag(s) == tag {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown species tag %04x", tag)
+}
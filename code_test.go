@@ -0,0 +1,41 @@
+package biomorph
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCode(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 20; i++ {
+		c := RandomCreature(NewTreeSpecies(), r)
+		code := EncodeCode(c)
+		assert.Len(t, code, 21)
+		decoded, err := DecodeCode(code)
+		assert.NoError(t, err)
+		for j, v := range decoded.Values {
+			want := c.Values[j]
+			assert.True(t, math.Abs(v.Value-want.Value) <= (want.Gene.Range.Max-want.Gene.Range.Min)/510+1e-9, v.Gene.Name)
+		}
+		// Decoded values are already quantized, so codes are stable.
+		assert.Equal(t, code, EncodeCode(decoded))
+		upper, err := DecodeCode(strings.ToUpper(code))
+		assert.NoError(t, err)
+		assert.Equal(t, decoded.ValuesMap(), upper.ValuesMap())
+	}
+}
+
+func TestDecodeCodeErrors(t *testing.T) {
+	code := EncodeCode(NewCreature(NewTreeSpecies()))
+	typo := []byte(code)
+	typo[5] = map[bool]byte{true: '1', false: '0'}[typo[5] == '0']
+	other := NewCreature(NewSpecies("tree", TreeGenes()[1:]))
+	for _, bad := range []string{"", "hello", code[:10], string(typo), "!" + code[1:], EncodeCode(other)} {
+		_, err := DecodeCode(bad)
+		assert.ErrorIs(t, err, ErrInvalidCode, bad)
+	}
+}
@@ -12,6 +12,8 @@ It has these top-level messages:
 	GetCreatureReply
 	SaveCreatureReply
 	SaveCreatureRequest
+	EvolveRequest
+	EvolveProgress
 */
 package db
 
@@ -54,6 +56,7 @@ func (m *GetCreatureRequest) GetId() uint64 {
 type GetCreatureReply struct {
 	Parents []uint64           `protobuf:"varint,1,rep,packed,name=parents" json:"parents,omitempty"`
 	Values  map[string]float64 `protobuf:"bytes,2,rep,name=values" json:"values,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	Species string             `protobuf:"bytes,3,opt,name=species" json:"species,omitempty"`
 }
 
 func (m *GetCreatureReply) Reset()                    { *m = GetCreatureReply{} }
@@ -75,6 +78,13 @@ func (m *GetCreatureReply) GetValues() map[string]float64 {
 	return nil
 }
 
+func (m *GetCreatureReply) GetSpecies() string {
+	if m != nil {
+		return m.Species
+	}
+	return ""
+}
+
 type SaveCreatureReply struct {
 	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
 }
@@ -94,6 +104,7 @@ func (m *SaveCreatureReply) GetId() uint64 {
 type SaveCreatureRequest struct {
 	Parents []uint64           `protobuf:"varint,1,rep,packed,name=parents" json:"parents,omitempty"`
 	Values  map[string]float64 `protobuf:"bytes,2,rep,name=values" json:"values,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	Species string             `protobuf:"bytes,3,opt,name=species" json:"species,omitempty"`
 }
 
 func (m *SaveCreatureRequest) Reset()                    { *m = SaveCreatureRequest{} }
@@ -115,11 +126,92 @@ func (m *SaveCreatureRequest) GetValues() map[string]float64 {
 	return nil
 }
 
+func (m *SaveCreatureRequest) GetSpecies() string {
+	if m != nil {
+		return m.Species
+	}
+	return ""
+}
+
+type EvolveRequest struct {
+	Id          uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Generations uint32 `protobuf:"varint,2,opt,name=generations" json:"generations,omitempty"`
+	Population  uint32 `protobuf:"varint,3,opt,name=population" json:"population,omitempty"`
+	Fitness     string `protobuf:"bytes,4,opt,name=fitness" json:"fitness,omitempty"`
+}
+
+func (m *EvolveRequest) Reset()                    { *m = EvolveRequest{} }
+func (m *EvolveRequest) String() string            { return proto.CompactTextString(m) }
+func (*EvolveRequest) ProtoMessage()               {}
+func (*EvolveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *EvolveRequest) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *EvolveRequest) GetGenerations() uint32 {
+	if m != nil {
+		return m.Generations
+	}
+	return 0
+}
+
+func (m *EvolveRequest) GetPopulation() uint32 {
+	if m != nil {
+		return m.Population
+	}
+	return 0
+}
+
+func (m *EvolveRequest) GetFitness() string {
+	if m != nil {
+		return m.Fitness
+	}
+	return ""
+}
+
+type EvolveProgress struct {
+	Generation  uint32  `protobuf:"varint,1,opt,name=generation" json:"generation,omitempty"`
+	BestFitness float64 `protobuf:"fixed64,2,opt,name=best_fitness,json=bestFitness" json:"best_fitness,omitempty"`
+	BestId      uint64  `protobuf:"varint,3,opt,name=best_id,json=bestId" json:"best_id,omitempty"`
+}
+
+func (m *EvolveProgress) Reset()                    { *m = EvolveProgress{} }
+func (m *EvolveProgress) String() string            { return proto.CompactTextString(m) }
+func (*EvolveProgress) ProtoMessage()               {}
+func (*EvolveProgress) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *EvolveProgress) GetGeneration() uint32 {
+	if m != nil {
+		return m.Generation
+	}
+	return 0
+}
+
+func (m *EvolveProgress) GetBestFitness() float64 {
+	if m != nil {
+		return m.BestFitness
+	}
+	return 0
+}
+
+func (m *EvolveProgress) GetBestId() uint64 {
+	if m != nil {
+		return m.BestId
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*GetCreatureRequest)(nil), "db.GetCreatureRequest")
 	proto.RegisterType((*GetCreatureReply)(nil), "db.GetCreatureReply")
 	proto.RegisterType((*SaveCreatureReply)(nil), "db.SaveCreatureReply")
 	proto.RegisterType((*SaveCreatureRequest)(nil), "db.SaveCreatureRequest")
+	proto.RegisterType((*EvolveRequest)(nil), "db.EvolveRequest")
+	proto.RegisterType((*EvolveProgress)(nil), "db.EvolveProgress")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -135,6 +227,7 @@ const _ = grpc.SupportPackageIsVersion4
 type DbClient interface {
 	GetCreature(ctx context.Context, in *GetCreatureRequest, opts ...grpc.CallOption) (*GetCreatureReply, error)
 	SaveCreature(ctx context.Context, in *SaveCreatureRequest, opts ...grpc.CallOption) (*SaveCreatureReply, error)
+	Evolve(ctx context.Context, in *EvolveRequest, opts ...grpc.CallOption) (Db_EvolveClient, error)
 }
 
 type dbClient struct {
@@ -163,11 +256,44 @@ func (c *dbClient) SaveCreature(ctx context.Context, in *SaveCreatureRequest, op
 	return out, nil
 }
 
+func (c *dbClient) Evolve(ctx context.Context, in *EvolveRequest, opts ...grpc.CallOption) (Db_EvolveClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Db_serviceDesc.Streams[0], c.cc, "/db.Db/Evolve", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dbEvolveClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Db_EvolveClient interface {
+	Recv() (*EvolveProgress, error)
+	grpc.ClientStream
+}
+
+type dbEvolveClient struct {
+	grpc.ClientStream
+}
+
+func (x *dbEvolveClient) Recv() (*EvolveProgress, error) {
+	m := new(EvolveProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for Db service
 
 type DbServer interface {
 	GetCreature(context.Context, *GetCreatureRequest) (*GetCreatureReply, error)
 	SaveCreature(context.Context, *SaveCreatureRequest) (*SaveCreatureReply, error)
+	Evolve(*EvolveRequest, Db_EvolveServer) error
 }
 
 func RegisterDbServer(s *grpc.Server, srv DbServer) {
@@ -210,6 +336,27 @@ func _Db_SaveCreature_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Db_Evolve_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EvolveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DbServer).Evolve(m, &dbEvolveServer{stream})
+}
+
+type Db_EvolveServer interface {
+	Send(*EvolveProgress) error
+	grpc.ServerStream
+}
+
+type dbEvolveServer struct {
+	grpc.ServerStream
+}
+
+func (x *dbEvolveServer) Send(m *EvolveProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Db_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "db.Db",
 	HandlerType: (*DbServer)(nil),
@@ -223,29 +370,44 @@ var _Db_serviceDesc = grpc.ServiceDesc{
 			Handler:    _Db_SaveCreature_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Evolve",
+			Handler:       _Db_Evolve_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "db.proto",
 }
 
 func init() { proto.RegisterFile("db.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 265 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x48, 0x49, 0xd2, 0x2b,
-	0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x4a, 0x49, 0x52, 0x52, 0xe1, 0x12, 0x72, 0x4f, 0x2d, 0x71,
-	0x2e, 0x4a, 0x4d, 0x2c, 0x29, 0x2d, 0x4a, 0x0d, 0x4a, 0x2d, 0x2c, 0x4d, 0x2d, 0x2e, 0x11, 0xe2,
-	0xe3, 0x62, 0xca, 0x4c, 0x91, 0x60, 0x54, 0x60, 0xd4, 0x60, 0x09, 0x62, 0xca, 0x4c, 0x51, 0x5a,
-	0xc8, 0xc8, 0x25, 0x80, 0xa2, 0xac, 0x20, 0xa7, 0x52, 0x48, 0x82, 0x8b, 0xbd, 0x20, 0xb1, 0x28,
-	0x35, 0xaf, 0xa4, 0x58, 0x82, 0x51, 0x81, 0x59, 0x83, 0x25, 0x08, 0xc6, 0x15, 0xb2, 0xe0, 0x62,
-	0x2b, 0x4b, 0xcc, 0x29, 0x4d, 0x2d, 0x96, 0x60, 0x52, 0x60, 0xd6, 0xe0, 0x36, 0x52, 0xd0, 0x4b,
-	0x49, 0xd2, 0x43, 0xd7, 0xaf, 0x17, 0x06, 0x56, 0xe2, 0x9a, 0x57, 0x52, 0x54, 0x19, 0x04, 0x55,
-	0x2f, 0x65, 0xc9, 0xc5, 0x8d, 0x24, 0x2c, 0x24, 0xc0, 0xc5, 0x9c, 0x9d, 0x5a, 0x09, 0x76, 0x08,
-	0x67, 0x10, 0x88, 0x29, 0x24, 0xc2, 0xc5, 0x0a, 0x56, 0x2a, 0xc1, 0xa4, 0xc0, 0xa8, 0xc1, 0x18,
-	0x04, 0xe1, 0x58, 0x31, 0x59, 0x30, 0x2a, 0x29, 0x73, 0x09, 0x06, 0x27, 0x96, 0xa5, 0xa2, 0xba,
-	0x11, 0xdd, 0x23, 0xcb, 0x19, 0xb9, 0x84, 0x51, 0x55, 0x41, 0x3c, 0x8c, 0xdb, 0x2f, 0xd6, 0x68,
-	0x7e, 0x51, 0x06, 0xf9, 0x05, 0x8b, 0x11, 0x54, 0xf6, 0x8e, 0x51, 0x2b, 0x23, 0x17, 0x93, 0x4b,
-	0x92, 0x90, 0x2d, 0x17, 0x37, 0x52, 0xc0, 0x09, 0x89, 0x61, 0x84, 0x24, 0xd8, 0x72, 0x29, 0x11,
-	0x6c, 0x21, 0xac, 0xc4, 0x20, 0xe4, 0xc0, 0xc5, 0x83, 0xec, 0x56, 0x21, 0x71, 0x1c, 0xae, 0x97,
-	0x12, 0xc5, 0x94, 0x00, 0x9b, 0x90, 0xc4, 0x06, 0x4e, 0x2b, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff,
-	0xff, 0x82, 0xb3, 0xf0, 0xb6, 0x37, 0x02, 0x00, 0x00,
+	// 401 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x53, 0x4d, 0x6f, 0x95, 0x40,
+	0x14, 0xed, 0x00, 0x52, 0x7b, 0x29, 0x4d, 0x7b, 0xad, 0x96, 0xb0, 0x30, 0x48, 0x5d, 0xb0, 0x22,
+	0xa6, 0xdd, 0x54, 0x8d, 0x89, 0x89, 0x3e, 0x8d, 0x3b, 0x33, 0x26, 0x6e, 0x0d, 0xc8, 0xf5, 0x85,
+	0x48, 0x00, 0x67, 0x06, 0x92, 0x17, 0xff, 0x99, 0xae, 0xfc, 0x67, 0x86, 0x99, 0x47, 0x1e, 0xbc,
+	0x8f, 0x6d, 0x77, 0x9c, 0xc3, 0x99, 0xc3, 0x39, 0x77, 0x2e, 0xf0, 0xb0, 0xc8, 0xd3, 0x56, 0x34,
+	0xaa, 0x41, 0xab, 0xc8, 0xe3, 0xe7, 0x80, 0x1f, 0x49, 0xbd, 0x13, 0x94, 0xa9, 0x4e, 0x10, 0xa7,
+	0x5f, 0x1d, 0x49, 0x85, 0x67, 0x60, 0x95, 0x45, 0xc0, 0x22, 0x96, 0x38, 0xdc, 0x2a, 0x8b, 0xf8,
+	0x2f, 0x83, 0xf3, 0x99, 0xac, 0xad, 0x56, 0x18, 0xc0, 0x71, 0x9b, 0x09, 0xaa, 0x95, 0x0c, 0x58,
+	0x64, 0x27, 0x0e, 0x1f, 0x21, 0xde, 0x81, 0xdb, 0x67, 0x55, 0x47, 0x32, 0xb0, 0x22, 0x3b, 0xf1,
+	0x6e, 0xa2, 0xb4, 0xc8, 0xd3, 0xed, 0xf3, 0xe9, 0x57, 0x2d, 0x59, 0xd4, 0x4a, 0xac, 0xf8, 0x5a,
+	0x3f, 0x78, 0xca, 0x96, 0xbe, 0x97, 0x24, 0x03, 0x3b, 0x62, 0xc9, 0x09, 0x1f, 0x61, 0xf8, 0x12,
+	0xbc, 0xc9, 0x01, 0x3c, 0x07, 0xfb, 0x27, 0xad, 0x74, 0xc4, 0x13, 0x3e, 0x3c, 0xe2, 0x25, 0x3c,
+	0xd0, 0x26, 0x81, 0x15, 0xb1, 0x84, 0x71, 0x03, 0x5e, 0x59, 0x77, 0x2c, 0xbe, 0x86, 0x8b, 0x2f,
+	0x59, 0x4f, 0xf3, 0xf4, 0xdb, 0x15, 0xff, 0x31, 0x78, 0x34, 0x57, 0x99, 0x51, 0x1c, 0x6e, 0xf9,
+	0x7a, 0xab, 0xe5, 0xf5, 0xd0, 0x72, 0x8f, 0xc5, 0xfd, 0x15, 0xfd, 0x0d, 0xfe, 0xa2, 0x6f, 0xaa,
+	0xfe, 0xd0, 0x3d, 0x62, 0x04, 0xde, 0x92, 0x6a, 0x12, 0x99, 0x2a, 0x9b, 0x5a, 0x6a, 0x03, 0x9f,
+	0x4f, 0x29, 0x7c, 0x0a, 0xd0, 0x36, 0x6d, 0x57, 0x69, 0xa8, 0xa3, 0xf9, 0x7c, 0xc2, 0x0c, 0xb9,
+	0x7f, 0x94, 0xaa, 0x26, 0x29, 0x03, 0xc7, 0xe4, 0x5e, 0xc3, 0xb8, 0x82, 0x33, 0xf3, 0xf1, 0xcf,
+	0xa2, 0x59, 0x0a, 0x92, 0xda, 0x6b, 0x63, 0xad, 0x53, 0xf8, 0x7c, 0xc2, 0xe0, 0x33, 0x38, 0xcd,
+	0x49, 0xaa, 0x6f, 0xa3, 0xa1, 0xe9, 0xe3, 0x0d, 0xdc, 0x07, 0x43, 0xe1, 0x15, 0x1c, 0x6b, 0x49,
+	0x59, 0xe8, 0x2c, 0x0e, 0x77, 0x07, 0xf8, 0xa9, 0xb8, 0xf9, 0xc3, 0xc0, 0x7a, 0x9f, 0xe3, 0x1b,
+	0xf0, 0x26, 0x7b, 0x85, 0x4f, 0x76, 0x16, 0x4d, 0xcf, 0x21, 0xbc, 0xdc, 0xb7, 0x80, 0xf1, 0x11,
+	0xbe, 0x85, 0xd3, 0xe9, 0x85, 0xe1, 0xd5, 0x81, 0x2b, 0x0c, 0x1f, 0xef, 0xbe, 0x30, 0x0e, 0xb7,
+	0xe0, 0x9a, 0xd6, 0x78, 0x31, 0x48, 0x66, 0xe3, 0x0f, 0x71, 0x43, 0x8d, 0x43, 0x89, 0x8f, 0x5e,
+	0xb0, 0xdc, 0xd5, 0xff, 0xdf, 0xed, 0xff, 0x01, 0x00, 0x82, 0xbc, 0x66, 0x81, 0x8b, 0x03, 0x00,
+	0x00,
 }
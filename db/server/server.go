@@ -21,23 +21,18 @@
 package main
 
 import (
-	"errors"
-	"fmt"
+	"flag"
 	"log"
 	"net"
+	"strings"
 
+	"github.com/jackdreilly/biomorph"
 	pb "github.com/jackdreilly/biomorph/db"
+	"github.com/jackdreilly/biomorph/db/service"
+	"github.com/jackdreilly/biomorph/db/store"
 
-	"bytes"
-	"encoding/json"
-	"time"
-
-	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
-
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
 const (
@@ -45,91 +40,38 @@ const (
 )
 
 var (
-	db *gorm.DB
-)
-
-func init() {
-	var err error
-	db, err = gorm.Open("sqlite3", "bio.db")
-	if err != nil {
-		panic("failed to connect database")
-	}
-	db.AutoMigrate(&CreatureModel{})
-}
-
-type JsonModel struct {
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt *time.Time
-	Json      []byte
-	ID        uint64 `gorm:"primary_key auto_increment"`
-}
-
-func (j *JsonModel) Decode(v interface{}) error {
-	return json.NewDecoder(bytes.NewReader(j.Json)).Decode(&v)
-}
-
-func (j *JsonModel) Encode(v interface{}) error {
-	var b bytes.Buffer
-	err := json.NewEncoder(&b).Encode(v)
-	if err != nil {
-		return err
-	}
-	j.Json = b.Bytes()
-	return nil
-}
+	backend = flag.String("store", "sqlite", "creature store backend, one of "+strings.Join(store.Backends, ", "))
+	db_path = flag.String("db", "bio.db", "database file for the sqlite and bolt stores")
 
-type CreatureModel struct {
-	JsonModel
-}
-
-type values map[string]float64
-
-type value_map struct {
-	VMap    values
-	Parents []uint64
-}
-
-type server struct{}
+	species_name = flag.String("species", "tree", "species of creatures evolved from scratch; stored creatures evolve as their saved species")
+	species_dir  = flag.String("species_dir", "", "directory of species definition files (.yaml, .yml, .json)")
+)
 
-func (s *server) GetCreature(ctx context.Context, in *pb.GetCreatureRequest) (*pb.GetCreatureReply, error) {
-	r := pb.GetCreatureReply{}
-	var m CreatureModel
-	db.First(&m, in.GetId())
-	if len(m.Json) == 0 {
-		return &r, errors.New(fmt.Sprintf("Could not find creature ID %d", in.GetId()))
+func main() {
+	flag.Parse()
+	if *species_dir != "" {
+		species, err := biomorph.LoadSpeciesDir(*species_dir)
+		if err != nil {
+			log.Fatalf("failed to load species:\n%v", err)
+		}
+		biomorph.SetConfiguredSpecies(species)
 	}
-	var vm value_map
-	e := m.Decode(&vm)
-	if e != nil {
-		return &r, e
+	if _, err := biomorph.GetSpecies(*species_name); err != nil {
+		log.Fatal(err)
 	}
-
-	r.Parents = vm.Parents
-	r.Values = vm.VMap
-	return &r, nil
-}
-
-func (s *server) SaveCreature(ctx context.Context, in *pb.SaveCreatureRequest) (*pb.SaveCreatureReply, error) {
-	r := pb.SaveCreatureReply{}
-	m := CreatureModel{}
-	e := m.Encode(value_map{in.GetValues(), in.GetParents()})
-	if e != nil {
-		return &r, e
+	cs, err := store.Open(*backend, *db_path)
+	if err != nil {
+		log.Fatalf("failed to open %s store: %v", *backend, err)
 	}
-	db.Create(&m)
-	r.Id = m.ID
-	return &r, nil
-}
-
-func main() {
-	defer db.Close()
+	defer cs.Close()
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 	s := grpc.NewServer()
-	pb.RegisterDbServer(s, &server{})
+	srv := service.NewServer(cs)
+	srv.Species = *species_name
+	pb.RegisterDbServer(s, srv)
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
 	if err := s.Serve(lis); err != nil {
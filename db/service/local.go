@@ -0,0 +1,88 @@
+package service
+
+import (
+	"io"
+
+	pb "github.com/jackdreilly/biomorph/db"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type localClient struct {
+	srv pb.DbServer
+}
+
+// NewLocalClient returns a DbClient that calls srv directly instead of going
+// through a network connection.
+func NewLocalClient(srv pb.DbServer) pb.DbClient {
+	return &localClient{srv}
+}
+
+func (c *localClient) GetCreature(ctx context.Context, in *pb.GetCreatureRequest, opts ...grpc.CallOption) (*pb.GetCreatureReply, error) {
+	return c.srv.GetCreature(ctx, in)
+}
+
+func (c *localClient) SaveCreature(ctx context.Context, in *pb.SaveCreatureRequest, opts ...grpc.CallOption) (*pb.SaveCreatureReply, error) {
+	return c.srv.SaveCreature(ctx, in)
+}
+
+func (c *localClient) Evolve(ctx context.Context, in *pb.EvolveRequest, opts ...grpc.CallOption) (pb.Db_EvolveClient, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &localEvolveStream{ctx: ctx, cancel: cancel, progress: make(chan *pb.EvolveProgress), done: make(chan struct{})}
+	go func() {
+		s.err = c.srv.Evolve(in, s)
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// localEvolveStream is both ends of an in-process Evolve stream: the server
+// sends into progress and the client receives until done is closed.
+type localEvolveStream struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	progress chan *pb.EvolveProgress
+	done     chan struct{}
+	err      error
+}
+
+func (s *localEvolveStream) Send(m *pb.EvolveProgress) error {
+	select {
+	case s.progress <- m:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *localEvolveStream) Recv() (*pb.EvolveProgress, error) {
+	select {
+	case m := <-s.progress:
+		return m, nil
+	case <-s.done:
+		s.cancel()
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+}
+
+func (s *localEvolveStream) Context() context.Context     { return s.ctx }
+func (s *localEvolveStream) Header() (metadata.MD, error) { return nil, nil }
+func (s *localEvolveStream) Trailer() metadata.MD         { return nil }
+func (s *localEvolveStream) CloseSend() error             { return nil }
+func (s *localEvolveStream) SetHeader(metadata.MD) error  { return nil }
+func (s *localEvolveStream) SendHeader(metadata.MD) error { return nil }
+func (s *localEvolveStream) SetTrailer(metadata.MD)       {}
+func (s *localEvolveStream) SendMsg(m interface{}) error  { return s.Send(m.(*pb.EvolveProgress)) }
+func (s *localEvolveStream) RecvMsg(m interface{}) error {
+	p, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	*m.(*pb.EvolveProgress) = *p
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package service implements the Db gRPC service so it can be served over
+// the network by db/server or called in-process through NewLocalClient.
+package service
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackdreilly/biomorph"
+	pb "github.com/jackdreilly/biomorph/db"
+	"github.com/jackdreilly/biomorph/db/store"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the Db service on top of a CreatureStore. Species names
+// the species of creatures evolved from scratch; stored creatures evolve as
+// the species they were saved with. Rand, if set, is the source
+// of mutations for Evolve, so tests can seed it; otherwise each run gets its
+// own. A set Rand is not safe for concurrent runs.
+type Server struct {
+	Store   store.CreatureStore
+	Species string
+	Rand    biomorph.Rand
+}
+
+func NewServer(cs store.CreatureStore) *Server {
+	return &Server{Store: cs, Species: "tree"}
+}
+
+// storeError gives store errors the matching gRPC status code.
+func storeError(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *Server) GetCreature(ctx context.Context, in *pb.GetCreatureRequest) (*pb.GetCreatureReply, error) {
+	r := pb.GetCreatureReply{}
+	c, e := s.Store.GetCreature(in.GetId())
+	if e != nil {
+		return &r, storeError(e)
+	}
+	r.Parents = c.Parents
+	r.Values = c.Values
+	r.Species = c.Species
+	return &r, nil
+}
+
+func (s *Server) SaveCreature(ctx context.Context, in *pb.SaveCreatureRequest) (*pb.SaveCreatureReply, error) {
+	r := pb.SaveCreatureReply{}
+	id, e := s.Store.SaveCreature(&store.Creature{Species: in.GetSpecies(), Values: in.GetValues(), Parents: in.GetParents()})
+	if e != nil {
+		return &r, storeError(e)
+	}
+	r.Id = id
+	return &r, nil
+}
+
+func (s *Server) Evolve(in *pb.EvolveRequest, stream pb.Db_EvolveServer) error {
+	fitness, err := biomorph.GetFitness(in.GetFitness())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	species_name := s.Species
+	var r *pb.GetCreatureReply
+	if in.GetId() != 0 {
+		if r, err = s.GetCreature(stream.Context(), &pb.GetCreatureRequest{Id: in.GetId()}); err != nil {
+			return err
+		}
+		species_name = r.GetSpecies()
+	}
+	species, err := biomorph.GetSpecies(species_name)
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	creature := biomorph.NewCreature(species)
+	var parents []uint64
+	if r != nil {
+		creature = species.LoadCreature(r.GetValues())
+		parents = append(r.GetParents(), in.GetId())
+	}
+	best, best_id := creature, in.GetId()
+	rng := s.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	_, err = biomorph.EvolveRand(stream.Context(), creature, int(in.GetGenerations()), int(in.GetPopulation()), fitness, rng, func(g biomorph.Generation) error {
+		if g.Best != best || best_id == 0 {
+			r, err := s.SaveCreature(stream.Context(), &pb.SaveCreatureRequest{Species: species.Name, Values: g.Best.ValuesMap(), Parents: parents})
+			if err != nil {
+				return err
+			}
+			best, best_id = g.Best, r.GetId()
+			parents = append(parents, best_id)
+		}
+		return stream.Send(&pb.EvolveProgress{Generation: uint32(g.Number), BestFitness: g.BestFitness, BestId: best_id})
+	})
+	return err
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/jackdreilly/biomorph"
+	pb "github.com/jackdreilly/biomorph/db"
+	"github.com/jackdreilly/biomorph/db/store"
+	"github.com/stretchr/testify/assert"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLocalClient(t *testing.T) {
+	server := NewServer(store.NewMemoryStore())
+	server.Rand = rand.New(rand.NewSource(1))
+	client := NewLocalClient(server)
+	ctx := context.Background()
+
+	saved, err := client.SaveCreature(ctx, &pb.SaveCreatureRequest{Values: map[string]float64{"num_gens": 2}})
+	assert.NoError(t, err)
+	got, err := client.GetCreature(ctx, &pb.GetCreatureRequest{Id: saved.GetId()})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, got.GetValues()["num_gens"])
+
+	stream, err := client.Evolve(ctx, &pb.EvolveRequest{Id: saved.GetId(), Generations: 3, Population: 8, Fitness: "ink"})
+	assert.NoError(t, err)
+	var progress []*pb.EvolveProgress
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		progress = append(progress, p)
+	}
+	assert.Len(t, progress, 3)
+	// With this seed the best creature changes, so every improvement was
+	// saved as a descendant of the last.
+	best_id := progress[2].GetBestId()
+	assert.NotEqual(t, saved.GetId(), best_id)
+	best, err := client.GetCreature(ctx, &pb.GetCreatureRequest{Id: best_id})
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, best.GetParents()) {
+		assert.Equal(t, saved.GetId(), best.GetParents()[0])
+	}
+	for _, p := range progress {
+		if p.GetBestId() != saved.GetId() {
+			assert.Contains(t, append(best.GetParents(), best_id), p.GetBestId())
+		}
+	}
+
+	stream, err = client.Evolve(ctx, &pb.EvolveRequest{Generations: 1, Population: 1, Fitness: "nope"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Error(t, err)
+}
+
+// evolve_best runs an evolution from id and returns the last best creature.
+func evolve_best(t *testing.T, client pb.DbClient, id uint64) (*pb.GetCreatureReply, error) {
+	ctx := context.Background()
+	stream, err := client.Evolve(ctx, &pb.EvolveRequest{Id: id, Generations: 2, Population: 4, Fitness: "spread"})
+	assert.NoError(t, err)
+	var last *pb.EvolveProgress
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = p
+	}
+	return client.GetCreature(ctx, &pb.GetCreatureRequest{Id: last.GetBestId()})
+}
+
+func TestEvolveSpecies(t *testing.T) {
+	server := NewServer(store.NewMemoryStore())
+	server.Rand = rand.New(rand.NewSource(1))
+	client := NewLocalClient(server)
+	ctx := context.Background()
+
+	// Creatures keep the species they were saved with, whatever the server's.
+	c := biomorph.NewCreature(biomorph.NewTree3dSpecies())
+	saved, err := client.SaveCreature(ctx, &pb.SaveCreatureRequest{Species: "tree3d", Values: c.ValuesMap()})
+	assert.NoError(t, err)
+	best, err := evolve_best(t, client, saved.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "tree3d", best.GetSpecies())
+	assert.Contains(t, best.GetValues(), "phyllotaxis")
+
+	best, err = evolve_best(t, client, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "tree", best.GetSpecies())
+
+	gone, err := client.SaveCreature(ctx, &pb.SaveCreatureRequest{Species: "dodo", Values: map[string]float64{"wings": 1}})
+	assert.NoError(t, err)
+	_, err = evolve_best(t, client, gone.GetId())
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	creatures_bucket = []byte("creatures")
+)
+
+// BoltStore keeps creatures as JSON values in an embedded bbolt file, keyed
+// by their big-endian ID.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(creatures_bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db}, nil
+}
+
+func boltKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+func (s *BoltStore) GetCreature(id uint64) (*Creature, error) {
+	var c Creature
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(creatures_bucket).Get(boltKey(id))
+		if v == nil {
+			return notFound(id)
+		}
+		return json.Unmarshal(v, &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.Species = speciesOrDefault(c.Species)
+	return &c, nil
+}
+
+func (s *BoltStore) SaveCreature(c *Creature) (uint64, error) {
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(creatures_bucket)
+		var err error
+		id, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(copyCreature(c))
+		if err != nil {
+			return err
+		}
+		return b.Put(boltKey(id), v)
+	})
+	return id, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
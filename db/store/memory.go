@@ -0,0 +1,41 @@
+package store
+
+import (
+	"sync"
+)
+
+// MemoryStore keeps creatures in a map. Everything is lost on Close.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	creatures map[uint64]*Creature
+	last_id   uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{creatures: map[uint64]*Creature{}}
+}
+
+func (s *MemoryStore) GetCreature(id uint64) (*Creature, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.creatures[id]
+	if !ok {
+		return nil, notFound(id)
+	}
+	return copyCreature(c), nil
+}
+
+func (s *MemoryStore) SaveCreature(c *Creature) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last_id++
+	s.creatures[s.last_id] = copyCreature(c)
+	return s.last_id, nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creatures = map[uint64]*Creature{}
+	return nil
+}
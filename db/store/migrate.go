@@ -0,0 +1,86 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	json_table        = "creature_models"
+	json_backup_table = "creature_models_json_backup"
+)
+
+// JsonModel is the storage format used before the typed tables: every
+// creature was a single JSON encoded value_map.
+type JsonModel struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+	Json      []byte
+	ID        uint64 `gorm:"primary_key auto_increment"`
+}
+
+func (j *JsonModel) Decode(v interface{}) error {
+	return json.NewDecoder(bytes.NewReader(j.Json)).Decode(&v)
+}
+
+func (j *JsonModel) Encode(v interface{}) error {
+	var b bytes.Buffer
+	err := json.NewEncoder(&b).Encode(v)
+	if err != nil {
+		return err
+	}
+	j.Json = b.Bytes()
+	return nil
+}
+
+type JsonCreatureModel struct {
+	JsonModel
+}
+
+func (JsonCreatureModel) TableName() string {
+	return json_table
+}
+
+// json_value_map is the JSON layout of a JsonCreatureModel.
+type json_value_map struct {
+	VMap    map[string]float64
+	Parents []uint64
+}
+
+// MigrateJsonModels copies every JSON blob creature into the typed tables,
+// keeping its ID and timestamps, then renames the old table out of the way
+// so the migration runs only once.
+func MigrateJsonModels(db *gorm.DB) error {
+	if !db.HasTable(json_table) {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		species_id, err := SpeciesID(tx, default_species)
+		if err != nil {
+			return err
+		}
+		var rows []JsonCreatureModel
+		if err := tx.Unscoped().Order("id").Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			var vm json_value_map
+			if err := row.Decode(&vm); err != nil {
+				return err
+			}
+			m := NewCreatureModel(species_id, &Creature{default_species, vm.VMap, vm.Parents})
+			m.ID = row.ID
+			m.CreatedAt = row.CreatedAt
+			m.UpdatedAt = row.UpdatedAt
+			m.DeletedAt = row.DeletedAt
+			if err := tx.Create(m).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec("ALTER TABLE " + json_table + " RENAME TO " + json_backup_table).Error
+	})
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+type SpeciesModel struct {
+	ID   uint64 `gorm:"primary_key auto_increment"`
+	Name string `gorm:"unique_index;not null"`
+}
+
+func (SpeciesModel) TableName() string {
+	return "species"
+}
+
+type CreatureModel struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time       `sql:"index"`
+	ID        uint64           `gorm:"primary_key auto_increment"`
+	SpeciesID uint64           `gorm:"index"`
+	Values    []GeneValueModel `gorm:"foreignkey:CreatureID"`
+	Lineage   []LineageModel   `gorm:"foreignkey:CreatureID"`
+}
+
+func (CreatureModel) TableName() string {
+	return "creatures"
+}
+
+// GeneValueModel is one gene value of a creature.
+type GeneValueModel struct {
+	CreatureID uint64  `gorm:"primary_key;auto_increment:false"`
+	Name       string  `gorm:"primary_key;index:idx_gene_values_name_value"`
+	Value      float64 `gorm:"index:idx_gene_values_name_value"`
+}
+
+func (GeneValueModel) TableName() string {
+	return "gene_values"
+}
+
+// LineageModel is an edge from a creature to one of its ancestors. Position
+// orders the ancestors from the root (0) down to the direct parent.
+type LineageModel struct {
+	CreatureID uint64 `gorm:"primary_key;auto_increment:false"`
+	Position   int    `gorm:"primary_key;auto_increment:false"`
+	AncestorID uint64 `gorm:"index"`
+}
+
+func (LineageModel) TableName() string {
+	return "lineage"
+}
+
+// AutoMigrate creates the typed tables and converts any JSON blob rows left
+// over from older databases.
+func AutoMigrate(db *gorm.DB) error {
+	err := db.AutoMigrate(&SpeciesModel{}, &CreatureModel{}, &GeneValueModel{}, &LineageModel{}).Error
+	if err != nil {
+		return err
+	}
+	return MigrateJsonModels(db)
+}
+
+func SpeciesID(db *gorm.DB, name string) (uint64, error) {
+	var s SpeciesModel
+	err := db.Where(SpeciesModel{Name: name}).FirstOrCreate(&s).Error
+	return s.ID, err
+}
+
+func NewCreatureModel(species_id uint64, c *Creature) *CreatureModel {
+	m := &CreatureModel{SpeciesID: species_id}
+	for name, value := range c.Values {
+		m.Values = append(m.Values, GeneValueModel{Name: name, Value: value})
+	}
+	for i, p := range c.Parents {
+		m.Lineage = append(m.Lineage, LineageModel{Position: i, AncestorID: p})
+	}
+	return m
+}
+
+func (m *CreatureModel) Creature(species string) *Creature {
+	c := &Creature{Species: species, Values: map[string]float64{}, Parents: make([]uint64, len(m.Lineage))}
+	for _, v := range m.Values {
+		c.Values[v.Name] = v.Value
+	}
+	for i, l := range m.Lineage {
+		c.Parents[i] = l.AncestorID
+	}
+	return c
+}
+
+func LoadCreatureModel(db *gorm.DB, id uint64) (*CreatureModel, error) {
+	var m CreatureModel
+	err := db.Preload("Values").Preload("Lineage", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).First(&m, id).Error
+	return &m, err
+}
+
+// SqliteStore keeps creatures in the typed sqlite tables, caching the
+// species table.
+type SqliteStore struct {
+	db *gorm.DB
+
+	mu           sync.Mutex
+	species_ids  map[string]uint64
+	species_name map[uint64]string
+}
+
+func NewSqliteStore(path string) (*SqliteStore, error) {
+	db, err := gorm.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := AutoMigrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// sqlite allows one writer at a time; sharing a single connection makes
+	// concurrent savers queue instead of failing with "database is locked".
+	db.DB().SetMaxOpenConns(1)
+	return &SqliteStore{db: db, species_ids: map[string]uint64{}, species_name: map[uint64]string{}}, nil
+}
+
+// speciesID is the ID of the named species, adding it if it's new.
+func (s *SqliteStore) speciesID(name string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.species_ids[name]; ok {
+		return id, nil
+	}
+	id, err := SpeciesID(s.db, name)
+	if err != nil {
+		return 0, err
+	}
+	s.species_ids[name], s.species_name[id] = id, name
+	return id, nil
+}
+
+func (s *SqliteStore) speciesName(id uint64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name, ok := s.species_name[id]; ok {
+		return name, nil
+	}
+	var m SpeciesModel
+	if err := s.db.First(&m, id).Error; err != nil {
+		return "", err
+	}
+	s.species_ids[m.Name], s.species_name[id] = id, m.Name
+	return m.Name, nil
+}
+
+func (s *SqliteStore) GetCreature(id uint64) (*Creature, error) {
+	m, err := LoadCreatureModel(s.db, id)
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, notFound(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	species, err := s.speciesName(m.SpeciesID)
+	if err != nil {
+		return nil, err
+	}
+	return m.Creature(species), nil
+}
+
+func (s *SqliteStore) SaveCreature(c *Creature) (uint64, error) {
+	species_id, err := s.speciesID(speciesOrDefault(c.Species))
+	if err != nil {
+		return 0, err
+	}
+	m := NewCreatureModel(species_id, c)
+	if err := s.db.Create(m).Error; err != nil {
+		return 0, err
+	}
+	return m.ID, nil
+}
+
+func (s *SqliteStore) Close() error {
+	return s.db.Close()
+}
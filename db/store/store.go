@@ -0,0 +1,67 @@
+// Package store persists creatures behind the CreatureStore interface so the
+// db server, the web app and tests can pick a backend at startup.
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound = errors.New("creature not found")
+)
+
+// default_species is the species of creatures saved without one, which
+// includes every creature saved before species were recorded.
+const default_species = "tree"
+
+// Creature is a stored creature: the name of its species, its gene values by
+// name and its ancestors ordered from the root down to the direct parent.
+// Stores save an empty Species as "tree".
+type Creature struct {
+	Species string
+	Values  map[string]float64
+	Parents []uint64
+}
+
+type CreatureStore interface {
+	GetCreature(id uint64) (*Creature, error)
+	SaveCreature(c *Creature) (uint64, error)
+	Close() error
+}
+
+// Backends are the names accepted by Open.
+var Backends = []string{"sqlite", "memory", "bolt"}
+
+// Open creates the named backend. path is the database file and is ignored
+// by the in-memory store.
+func Open(backend string, path string) (CreatureStore, error) {
+	switch backend {
+	case "sqlite":
+		return NewSqliteStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		return NewBoltStore(path)
+	}
+	return nil, fmt.Errorf("unknown store backend %q, want one of %v", backend, Backends)
+}
+
+func notFound(id uint64) error {
+	return fmt.Errorf("could not find creature ID %d: %w", id, ErrNotFound)
+}
+
+func speciesOrDefault(name string) string {
+	if name == "" {
+		return default_species
+	}
+	return name
+}
+
+func copyCreature(c *Creature) *Creature {
+	n := &Creature{Species: speciesOrDefault(c.Species), Values: make(map[string]float64, len(c.Values)), Parents: append([]uint64{}, c.Parents...)}
+	for k, v := range c.Values {
+		n.Values[k] = v
+	}
+	return n
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	for _, backend := range Backends {
+		t.Run(backend, func(t *testing.T) {
+			s, err := Open(backend, filepath.Join(t.TempDir(), "bio.db"))
+			assert.NoError(t, err)
+			defer s.Close()
+
+			root := &Creature{Values: map[string]float64{"num_gens": 3}, Parents: []uint64{}}
+			root_id, err := s.SaveCreature(root)
+			assert.NoError(t, err)
+			child_id, err := s.SaveCreature(&Creature{Values: map[string]float64{"num_gens": 4}, Parents: []uint64{root_id}})
+			assert.NoError(t, err)
+			assert.NotEqual(t, root_id, child_id)
+
+			c, err := s.GetCreature(child_id)
+			assert.NoError(t, err)
+			assert.Equal(t, 4.0, c.Values["num_gens"])
+			assert.Equal(t, []uint64{root_id}, c.Parents)
+			assert.Equal(t, "tree", c.Species)
+
+			fern_id, err := s.SaveCreature(&Creature{Species: "fern", Values: map[string]float64{"fronds": 5}, Parents: []uint64{}})
+			assert.NoError(t, err)
+			c, err = s.GetCreature(fern_id)
+			assert.NoError(t, err)
+			assert.Equal(t, "fern", c.Species)
+			c, err = s.GetCreature(root_id)
+			assert.NoError(t, err)
+			assert.Equal(t, "tree", c.Species)
+
+			_, err = s.GetCreature(child_id + 100)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+	}
+}
+
+func TestMigrateJsonModels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bio.db")
+	db, err := gorm.Open("sqlite3", path)
+	assert.NoError(t, err)
+	db.AutoMigrate(&JsonCreatureModel{})
+	old := map[uint64]json_value_map{
+		5: {map[string]float64{"num_gens": 2}, []uint64{}},
+		7: {map[string]float64{"num_gens": 3}, []uint64{5}},
+	}
+	for id, vm := range old {
+		m := JsonCreatureModel{}
+		m.ID = id
+		assert.NoError(t, m.Encode(vm))
+		assert.NoError(t, db.Create(&m).Error)
+	}
+	db.Close()
+
+	s, err := NewSqliteStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+	c, err := s.GetCreature(7)
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, c.Values["num_gens"])
+	assert.Equal(t, []uint64{5}, c.Parents)
+	assert.Equal(t, "tree", c.Species)
+	id, err := s.SaveCreature(&Creature{Values: map[string]float64{"num_gens": 4}, Parents: []uint64{5, 7}})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), id)
+	assert.False(t, s.db.HasTable(json_table))
+
+	var backup []JsonModel
+	assert.NoError(t, s.db.Table(json_backup_table).Order("id").Find(&backup).Error)
+	if assert.Len(t, backup, len(old)) {
+		for _, row := range backup {
+			var vm json_value_map
+			assert.NoError(t, row.Decode(&vm))
+			assert.Equal(t, old[row.ID], vm)
+		}
+	}
+
+	// Migrating again finds nothing to do.
+	assert.NoError(t, AutoMigrate(s.db))
+	var count int
+	assert.NoError(t, s.db.Model(&CreatureModel{}).Count(&count).Error)
+	assert.Equal(t, 3, count)
+}
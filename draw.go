@@ -1,39 +1,132 @@
 package biomorph
 
 import (
+	"context"
+	"fmt"
 	"image"
-	"image/color"
+	"io"
 	"math"
 	"math/rand"
-	"time"
-
-	"github.com/fogleman/gg"
+	"strings"
 )
 
-func DrawTreeCreature(tree *Creature) image.Image {
-	dc := gg.NewContext(ImageSize, ImageSize)
-	dc.SetColor(color.White)
-	dc.DrawRectangle(0, 0, ImageSize, ImageSize)
-	dc.Fill()
-	dc.SetColor(color.Black)
-	dc.SetLineWidth(2.0)
-	rand.Seed(0)
+// Segment is one branch of a tree, in ImageSize by ImageSize coordinates.
+type Segment struct {
+	X1, Y1, X2, Y2 float64
+}
+
+// TreeSegments lays out the branches of tree in drawing order.
+func TreeSegments(tree *Creature) []Segment {
+	return tree_segments(tree, NumGens(tree))
+}
+
+// tree_segments lays out the first gens generations of tree.
+func tree_segments(tree *Creature, gens int) []Segment {
+	var segments []Segment
+	// A fixed seed keeps the noise genes deterministic, so a creature always
+	// renders the same. It is local so concurrent renders don't share it.
+	rng := rand.New(rand.NewSource(0))
 	var drawTreeGen func(tree *Creature, gen int, radians float64, p point, branch_size float64, branch_angle float64)
 	drawTreeGen = func(tree *Creature, gen int, radians float64, p point, branch_size float64, branch_angle float64) {
-		radians += (rand.ExpFloat64() * AngleNoise(tree)) * 0.0
-		ba := branch_angle * (1 + LengthNoise(tree)*rand.ExpFloat64())
-		bs := branch_size * (1 + LengthNoise(tree)*rand.ExpFloat64()*0.0)
+		radians += (rng.ExpFloat64() * AngleNoise(tree)) * 0.0
+		ba := branch_angle * (1 + LengthNoise(tree)*rng.ExpFloat64())
+		bs := branch_size * (1 + LengthNoise(tree)*rng.ExpFloat64()*0.0)
 		if gen == 0 {
 			return
 		}
 		new_point := point{p.x - bs*math.Sin(radians), p.y - bs*math.Cos(radians)}
-		dc.DrawLine(p.x, p.y, new_point.x, new_point.y)
-		dc.Stroke()
+		segments = append(segments, Segment{p.x, p.y, new_point.x, new_point.y})
 		for i := 0; i < NumBranches(tree); i++ {
 			drawTreeGen(tree, gen-1, radians-ba/2.0+ba*float64(i)/float64(NumBranches(tree)-1), new_point, branch_size*BranchIncrease(tree), branch_angle*AngleIncrease(tree))
 		}
 	}
-	drawTreeGen(tree, NumGens(tree), 0, point{float64(dc.Width()) / 2, float64(dc.Height()) * 9 / 10}, BranchLength(tree), BranchAngle(tree))
-	rand.Seed(time.Now().UnixNano())
-	return dc.Image()
+	drawTreeGen(tree, gens, 0, point{ImageSize / 2, ImageSize * 9 / 10}, BranchLength(tree), BranchAngle(tree))
+	return segments
+}
+
+// DrawTreeCreature draws tree with its species' renderer within
+// DefaultRenderBudget. Despite the name any species is drawn; trees were
+// the only one when it was written. If the creature is too big it returns
+// as much as fits and an error.
+func DrawTreeCreature(tree *Creature) (image.Image, error) {
+	return DrawTreeCreatureContext(context.Background(), tree, DefaultRenderBudget)
+}
+
+// DrawTreeCreatureContext draws tree like RenderContext within budget,
+// stopping early if ctx is done. When it stops early it returns what it
+// drew so far and an error wrapping ErrRenderBudget or ctx's error.
+func DrawTreeCreatureContext(ctx context.Context, tree *Creature, budget RenderBudget) (image.Image, error) {
+	return RenderContext(ctx, tree, RenderOptions{Size: ImageSize, Budget: budget})
+}
+
+// DrawTreeCreatureSize draws tree on a size by size image, scaling the
+// ImageSize drawing rather than cropping or padding it.
+func DrawTreeCreatureSize(tree *Creature, size int) image.Image {
+	return DrawCreatureSize(tree, size)
+}
+
+// DrawCreature draws c with its species' renderer.
+func DrawCreature(c *Creature) image.Image {
+	return DrawCreatureSize(c, ImageSize)
+}
+
+// DrawCreatureSize draws c on a size by size image within
+// DefaultRenderBudget, as much as fits if c is too big.
+func DrawCreatureSize(c *Creature, size int) image.Image {
+	return Render(c, RenderOptions{Size: size, Budget: DefaultRenderBudget})
+}
+
+func draw_segments(ctx context.Context, segments []Segment, opts RenderOptions) (image.Image, error) {
+	strokes := segment_strokes(segments, opts.Join)
+	return supersample(opts.Size, opts.Supersample, func(size int) (image.Image, error) {
+		return draw_strokes(ctx, strokes, size, opts)
+	})
+}
+
+// WriteSvg writes c as a size by size SVG drawing.
+func WriteSvg(w io.Writer, c *Creature, size int) error {
+	return WriteSvgOptions(w, c, RenderOptions{Size: size})
+}
+
+// svg_join is join's stroke-linejoin. Unjoined segments never meet in a
+// path, so any value does.
+func svg_join(join LineJoin) string {
+	if join == JoinNone {
+		return "round"
+	}
+	return join.String()
+}
+
+// WriteSvgOptions writes c as an SVG drawing with opts' caps and joins. 3D
+// creatures are drawn flat, without shading.
+func WriteSvgOptions(w io.Writer, c *Creature, opts RenderOptions) error {
+	segments := CreatureSegments(c)
+	if Is3d(c) {
+		segments = Project(CreatureSegments3(c), opts.Camera)
+	}
+	size := opts.Size
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">
+<rect width="100%%" height="100%%" fill="white"/>
+<g stroke="black" stroke-width="2" stroke-linecap="%s" stroke-linejoin="%s" fill="none">
+`, size, size, ImageSize, ImageSize, opts.Cap, svg_join(opts.Join))
+	if err != nil {
+		return err
+	}
+	for _, s := range segment_strokes(segments, opts.Join) {
+		if len(s.points) == 2 {
+			a, b := s.points[0], s.points[1]
+			_, err = fmt.Fprintf(w, "<line x1=\"%.3f\" y1=\"%.3f\" x2=\"%.3f\" y2=\"%.3f\"/>\n", a.x, a.y, b.x, b.y)
+		} else {
+			points := make([]string, len(s.points))
+			for i, p := range s.points {
+				points[i] = fmt.Sprintf("%.3f,%.3f", p.x, p.y)
+			}
+			_, err = fmt.Fprintf(w, "<polyline points=\"%s\"/>\n", strings.Join(points, " "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "</g>\n</svg>\n")
+	return err
 }
@@ -0,0 +1,40 @@
+//go:build cloudlogging
+
+package events
+
+import (
+	"cloud.google.com/go/logging"
+	"golang.org/x/net/context"
+)
+
+const (
+	cloud_log_name = "biomorph"
+)
+
+func init() {
+	sinks["cloud"] = func(target string) (Sink, error) { return NewCloudSink(target) }
+}
+
+// CloudSink sends events to Google Cloud Logging as structured payloads.
+// It is only built with the cloudlogging build tag.
+type CloudSink struct {
+	client *logging.Client
+	logger *logging.Logger
+}
+
+func NewCloudSink(project_id string) (*CloudSink, error) {
+	c, err := logging.NewClient(context.Background(), project_id)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudSink{c, c.Logger(cloud_log_name)}, nil
+}
+
+func (s *CloudSink) Record(e Event) error {
+	s.logger.Log(logging.Entry{Timestamp: e.Time, Severity: logging.Info, Payload: e})
+	return nil
+}
+
+func (s *CloudSink) Close() error {
+	return s.client.Close()
+}
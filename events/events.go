@@ -0,0 +1,61 @@
+// Package events records what players do with creatures to a pluggable sink.
+package events
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Kind string
+
+const (
+	Selection Kind = "selection"
+	Mutation  Kind = "mutation"
+	Breed     Kind = "breed"
+)
+
+// Event is one structured record. Creature is the creature the event
+// produced or acted on and Parents are the creatures it came from.
+type Event struct {
+	Time     time.Time          `json:"time"`
+	Kind     Kind               `json:"kind"`
+	Creature uint64             `json:"creature"`
+	Parents  []uint64           `json:"parents,omitempty"`
+	Values   map[string]float64 `json:"values,omitempty"`
+}
+
+type Sink interface {
+	Record(e Event) error
+	Close() error
+}
+
+// sinks maps sink names to constructors. target is sink specific: a file
+// path for "file" or a project ID for "cloud".
+var sinks = map[string]func(target string) (Sink, error){
+	"stderr": func(string) (Sink, error) { return NewStderrSink(), nil },
+	"file":   func(target string) (Sink, error) { return NewJsonFileSink(target) },
+}
+
+// Sinks are the names accepted by Open in this build.
+func Sinks() []string {
+	var names []string
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Open(name string, target string) (Sink, error) {
+	open, ok := sinks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown event sink %q, want one of %v", name, Sinks())
+	}
+	return open(target)
+}
+
+// NewEvent stamps an event with the current time.
+func NewEvent(kind Kind, creature uint64, parents []uint64, values map[string]float64) Event {
+	return Event{time.Now(), kind, creature, parents, values}
+}
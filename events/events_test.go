@@ -0,0 +1,64 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEvent(t *testing.T) {
+	before := time.Now()
+	e := NewEvent(Breed, 9, []uint64{3, 4}, map[string]float64{"num_gens": 2})
+	assert.Equal(t, Breed, e.Kind)
+	assert.Equal(t, uint64(9), e.Creature)
+	assert.Equal(t, []uint64{3, 4}, e.Parents)
+	assert.Equal(t, map[string]float64{"num_gens": 2}, e.Values)
+	assert.False(t, e.Time.Before(before))
+	assert.False(t, e.Time.After(time.Now()))
+}
+
+func TestOpen(t *testing.T) {
+	s, err := Open("stderr", "")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	_, err = Open("carrier_pigeon", "")
+	assert.ErrorContains(t, err, `unknown event sink "carrier_pigeon"`)
+}
+
+func TestJsonFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	want := []Event{
+		NewEvent(Mutation, 2, []uint64{1}, map[string]float64{"num_gens": 3}),
+		NewEvent(Selection, 2, []uint64{1}, nil),
+	}
+	// Appends across opens.
+	for _, e := range want {
+		s, err := Open("file", path)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Record(e))
+		assert.NoError(t, s.Close())
+	}
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var got []Event
+	for lines := bufio.NewScanner(f); lines.Scan(); {
+		var e Event
+		assert.NoError(t, json.Unmarshal(lines.Bytes(), &e))
+		got = append(got, e)
+	}
+	if assert.Len(t, got, len(want)) {
+		for i := range want {
+			assert.True(t, want[i].Time.Equal(got[i].Time))
+			got[i].Time = want[i].Time
+		}
+		assert.Equal(t, want, got)
+	}
+}
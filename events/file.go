@@ -0,0 +1,34 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// JsonFileSink appends events to a file as JSON lines.
+type JsonFileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewJsonFileSink(path string) (*JsonFileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JsonFileSink{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *JsonFileSink) Record(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(e)
+}
+
+func (s *JsonFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,24 @@
+package events
+
+import (
+	"log"
+	"os"
+)
+
+// StderrSink writes one line per event to stderr.
+type StderrSink struct {
+	logger *log.Logger
+}
+
+func NewStderrSink() *StderrSink {
+	return &StderrSink{log.New(os.Stderr, "", log.LstdFlags)}
+}
+
+func (s *StderrSink) Record(e Event) error {
+	s.logger.Printf("%s creature=%d parents=%v values=%v", e.Kind, e.Creature, e.Parents, e.Values)
+	return nil
+}
+
+func (s *StderrSink) Close() error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package biomorph
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+)
+
+// Fitness scores a creature, higher is better.
+type Fitness func(c *Creature) float64
+
+// Generation is the progress of an evolution run after one generation.
+type Generation struct {
+	Number      int
+	BestFitness float64
+	Best        *Creature
+}
+
+// Fitnesses are the fitness functions available to headless runs by name.
+var Fitnesses = map[string]Fitness{
+	"ink":    InkFitness,
+	"spread": SpreadFitness,
+}
+
+// GetFitness looks up a fitness function by name.
+func GetFitness(name string) (Fitness, error) {
+	f, ok := Fitnesses[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fitness function %q", name)
+	}
+	return f, nil
+}
+
+// InkFitness is the fraction of the tree image that is drawn on.
+func InkFitness(tree *Creature) float64 {
+	img := DrawCreature(tree)
+	b := img.Bounds()
+	ink := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 128 {
+				ink++
+			}
+		}
+	}
+	return float64(ink) / float64(b.Dx()*b.Dy())
+}
+
+// SpreadFitness is the fraction of the image width covered by the tree.
+func SpreadFitness(tree *Creature) float64 {
+	img := DrawCreature(tree)
+	b := img.Bounds()
+	min_x, max_x := b.Max.X, b.Min.X
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 128 {
+				if x < min_x {
+					min_x = x
+				}
+				if x > max_x {
+					max_x = x
+				}
+			}
+		}
+	}
+	if max_x < min_x {
+		return 0
+	}
+	return float64(max_x-min_x+1) / float64(b.Dx())
+}
+
+// Evolve runs a headless evolution starting from creature. Each generation
+// breeds population mutants of the current best and keeps the fittest,
+// calling progress after every generation. It stops early if ctx is done or
+// progress returns an error.
+func Evolve(ctx context.Context, creature *Creature, generations int, population int, fitness Fitness, progress func(Generation) error) (*Creature, error) {
+	return EvolveRand(ctx, creature, generations, population, fitness, global_rand{}, progress)
+}
+
+// EvolveRand is Evolve mutating with r, so a seeded r makes runs repeatable.
+func EvolveRand(ctx context.Context, creature *Creature, generations int, population int, fitness Fitness, r Rand, progress func(Generation) error) (*Creature, error) {
+	best := creature
+	best_fitness := fitness(best)
+	for gen := 1; gen <= generations; gen++ {
+		for i := 0; i < population; i++ {
+			if err := ctx.Err(); err != nil {
+				return best, err
+			}
+			nc := MutateCreatureRand(best, r)
+			if f := fitness(nc); f > best_fitness {
+				best, best_fitness = nc, f
+			}
+		}
+		if progress != nil {
+			if err := progress(Generation{gen, best_fitness, best}); err != nil {
+				return best, err
+			}
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,48 @@
+package biomorph
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvolve(t *testing.T) {
+	fitness, err := GetFitness("ink")
+	assert.NoError(t, err)
+	start := NewCreature(NewTreeSpecies())
+	var gens []Generation
+	best, err := EvolveRand(context.Background(), start, 5, 4, fitness, rand.New(rand.NewSource(1)), func(g Generation) error {
+		gens = append(gens, g)
+		return nil
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, gens, 5) {
+		return
+	}
+	last := fitness(start)
+	for i, g := range gens {
+		assert.Equal(t, i+1, g.Number)
+		assert.GreaterOrEqual(t, g.BestFitness, last)
+		assert.Equal(t, fitness(g.Best), g.BestFitness)
+		last = g.BestFitness
+	}
+	assert.Same(t, gens[4].Best, best)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	best, err = Evolve(ctx, start, 5, 4, fitness, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Same(t, start, best)
+}
+
+func TestGetFitness(t *testing.T) {
+	for name := range Fitnesses {
+		f, err := GetFitness(name)
+		assert.NoError(t, err)
+		assert.NotNil(t, f)
+	}
+	_, err := GetFitness("beauty")
+	assert.ErrorContains(t, err, `unknown fitness function "beauty"`)
+}
@@ -0,0 +1,335 @@
+package biomorph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// GenomeVersion is the genome file format written by this package. Readers
+// reject any other version.
+const GenomeVersion = 1
+
+const genome_text_magic = "biomorph-genome"
+
+var (
+	ErrInvalidGenome = errors.New("invalid genome")
+)
+
+// GenomeGene is a gene definition and the creature's value for it.
+type GenomeGene struct {
+	Name  string  `json:"name"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Value float64 `json:"value"`
+}
+
+// Genome is a creature saved outside the db. Genes holds its genes and any
+// copies of its species' modules. Lineage optionally holds the ancestors'
+// gene values, root first: the species' genes in order, then for each module
+// the number of copies followed by their values. For species without
+// modules that's just the values in the order of the species' genes.
+//
+// The JSON form is the struct below. The text form is line based:
+//
+//	biomorph-genome 1
+//	species tree
+//	gene <name> <min> <max> <value>
+//	ancestor <value> <value> ...
+type Genome struct {
+	Version int          `json:"version"`
+	Species string       `json:"species"`
+	Genes   []GenomeGene `json:"genes"`
+	Lineage [][]float64  `json:"lineage,omitempty"`
+}
+
+func invalid_genome(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidGenome, fmt.Sprintf(format, args...))
+}
+
+// NewGenome saves c and, optionally, its ancestors from the root down.
+func NewGenome(c *Creature, lineage []*Creature) *Genome {
+	g := &Genome{Version: GenomeVersion, Species: c.CreatureSpecies.Name}
+	for _, v := range c.Values {
+		g.Genes = append(g.Genes, GenomeGene{v.Gene.Name, v.Gene.Range.Min, v.Gene.Range.Max, v.Value})
+	}
+	for _, a := range lineage {
+		g.Lineage = append(g.Lineage, genome_row(a))
+	}
+	return g
+}
+
+// genome_row flattens c for a lineage row.
+func genome_row(c *Creature) []float64 {
+	l := c.layout()
+	row := append([]float64{}, l.fixed...)
+	for _, copies := range l.modules {
+		row = append(row, float64(len(copies)))
+		for _, values := range copies {
+			row = append(row, values...)
+		}
+	}
+	return row
+}
+
+// row_creature reads a lineage row back, checking its values.
+func row_creature(species *Species, row []float64) (*Creature, error) {
+	want := len(species.Genes)
+	if len(row) < want {
+		return nil, invalid_genome("%d values, want %d", len(row), want)
+	}
+	l := &layout{row[:want], make([][][]float64, len(species.Modules))}
+	pos := want
+	for i, m := range species.Modules {
+		if pos >= len(row) {
+			return nil, invalid_genome("%d values, missing the number of %s copies", len(row), m.Name)
+		}
+		n := row[pos]
+		if n != math.Trunc(n) || n < float64(m.Min) || n > float64(m.Max) {
+			return nil, invalid_genome("%v copies of module %s, want a whole number in [%d, %d]", n, m.Name, m.Min, m.Max)
+		}
+		pos++
+		for k := 0; k < int(n); k++ {
+			if pos+len(m.Genes) > len(row) {
+				return nil, invalid_genome("%d values, too few for %v copies of module %s", len(row), n, m.Name)
+			}
+			l.modules[i] = append(l.modules[i], row[pos:pos+len(m.Genes)])
+			pos += len(m.Genes)
+		}
+	}
+	if pos != len(row) {
+		return nil, invalid_genome("%d values, want %d", len(row), pos)
+	}
+	c := l.creature(species)
+	for _, v := range c.Values {
+		if err := check_value(v.Gene, v.Value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Validate checks the genome against its species: every gene must be
+// defined with the species' range, exactly once, each module's copies must
+// be complete and numbered from 0, and every value, including the
+// ancestors', must lie in its range.
+func (g *Genome) Validate() error {
+	if g.Version != GenomeVersion {
+		return invalid_genome("unsupported version %d, want %d", g.Version, GenomeVersion)
+	}
+	species, err := GetSpecies(g.Species)
+	if err != nil {
+		return invalid_genome("%v", err)
+	}
+	seen := map[string]bool{}
+	for _, gg := range g.Genes {
+		gene := species.Gene(gg.Name)
+		if gene == nil {
+			return invalid_genome("unknown gene %q for species %s", gg.Name, g.Species)
+		}
+		if seen[gg.Name] {
+			return invalid_genome("duplicate gene %q", gg.Name)
+		}
+		seen[gg.Name] = true
+		if gg.Min != gene.Range.Min || gg.Max != gene.Range.Max {
+			return invalid_genome("gene %q has range [%v, %v], species %s has [%v, %v]", gg.Name, gg.Min, gg.Max, g.Species, gene.Range.Min, gene.Range.Max)
+		}
+		if err := check_value(gene, gg.Value); err != nil {
+			return err
+		}
+	}
+	for _, gene := range species.Genes {
+		if !seen[gene.Name] {
+			return invalid_genome("missing gene %q", gene.Name)
+		}
+	}
+	module_genes := len(g.Genes) - len(species.Genes)
+	for _, m := range species.Modules {
+		n := 0
+		for ; n < m.Max && seen[m.copies[n][0].Name]; n++ {
+			for _, gene := range m.copies[n] {
+				if !seen[gene.Name] {
+					return invalid_genome("missing gene %q", gene.Name)
+				}
+			}
+		}
+		if n < m.Min {
+			return invalid_genome("%d copies of module %s, want at least %d", n, m.Name, m.Min)
+		}
+		module_genes -= n * len(m.Genes)
+	}
+	if module_genes != 0 {
+		return invalid_genome("module copies must be numbered from 0 without gaps")
+	}
+	for i, values := range g.Lineage {
+		if _, err := row_creature(species, values); err != nil {
+			return fmt.Errorf("ancestor %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func check_value(gene *Gene, v float64) error {
+	if math.IsNaN(v) || v < gene.Range.Min || v > gene.Range.Max {
+		return invalid_genome("gene %q value %v is outside [%v, %v]", gene.Name, v, gene.Range.Min, gene.Range.Max)
+	}
+	if gene.Type == GeneInt && v != math.Trunc(v) {
+		return invalid_genome("int gene %q value %v is not a whole number", gene.Name, v)
+	}
+	return nil
+}
+
+// Creatures validates the genome and returns the creature and its lineage,
+// root first.
+func (g *Genome) Creatures() (*Creature, []*Creature, error) {
+	if err := g.Validate(); err != nil {
+		return nil, nil, err
+	}
+	species, _ := GetSpecies(g.Species)
+	values := make(map[string]float64, len(g.Genes))
+	for _, gg := range g.Genes {
+		values[gg.Name] = gg.Value
+	}
+	c := species.LoadCreature(values)
+	lineage := make([]*Creature, len(g.Lineage))
+	for i, row := range g.Lineage {
+		lineage[i], _ = row_creature(species, row)
+	}
+	return c, lineage, nil
+}
+
+// WriteJson writes the genome as indented JSON.
+func (g *Genome) WriteJson(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+func format_float(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteText writes the genome in the compact text form.
+func (g *Genome) WriteText(w io.Writer) error {
+	var buff bytes.Buffer
+	fmt.Fprintf(&buff, "%s %d\nspecies %s\n", genome_text_magic, g.Version, g.Species)
+	for _, gg := range g.Genes {
+		fmt.Fprintf(&buff, "gene %s %s %s %s\n", gg.Name, format_float(gg.Min), format_float(gg.Max), format_float(gg.Value))
+	}
+	for _, values := range g.Lineage {
+		buff.WriteString("ancestor")
+		for _, v := range values {
+			buff.WriteString(" " + format_float(v))
+		}
+		buff.WriteString("\n")
+	}
+	_, err := w.Write(buff.Bytes())
+	return err
+}
+
+// ReadGenome reads a genome in either form and validates it.
+func ReadGenome(r io.Reader) (*Genome, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var g *Genome
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		g, err = parse_genome_json(t)
+	} else {
+		g, err = parse_genome_text(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, g.Validate()
+}
+
+func parse_genome_json(b []byte) (*Genome, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var g Genome
+	if err := dec.Decode(&g); err != nil {
+		return nil, invalid_genome("%v", err)
+	}
+	if dec.More() {
+		return nil, invalid_genome("trailing data after JSON genome")
+	}
+	return &g, nil
+}
+
+func parse_floats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func parse_genome_text(b []byte) (*Genome, error) {
+	var g Genome
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	line_number := 0
+	for scanner.Scan() {
+		line_number++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		bad := func(err error) error {
+			return invalid_genome("line %d: %v", line_number, err)
+		}
+		if g.Version == 0 {
+			if len(fields) != 2 || fields[0] != genome_text_magic {
+				return nil, bad(fmt.Errorf("want %q header", genome_text_magic+" <version>"))
+			}
+			v, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, bad(err)
+			}
+			g.Version = v
+			continue
+		}
+		switch fields[0] {
+		case "species":
+			if len(fields) != 2 || g.Species != "" {
+				return nil, bad(errors.New("want one \"species <name>\" line"))
+			}
+			g.Species = fields[1]
+		case "gene":
+			if len(fields) != 5 {
+				return nil, bad(errors.New("want \"gene <name> <min> <max> <value>\""))
+			}
+			values, err := parse_floats(fields[2:])
+			if err != nil {
+				return nil, bad(err)
+			}
+			g.Genes = append(g.Genes, GenomeGene{fields[1], values[0], values[1], values[2]})
+		case "ancestor":
+			values, err := parse_floats(fields[1:])
+			if err != nil {
+				return nil, bad(err)
+			}
+			g.Lineage = append(g.Lineage, values)
+		default:
+			return nil, bad(fmt.Errorf("unknown directive %q", fields[0]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if g.Version == 0 {
+		return nil, invalid_genome("empty genome")
+	}
+	return &g, nil
+}
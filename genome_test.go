@@ -0,0 +1,66 @@
+package biomorph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func test_genome() *Genome {
+	root := NewCreature(NewTreeSpecies())
+	c := NewCreature(NewTreeSpecies())
+	c.GetGeneValue("num_gens").Value = 4
+	c.GetGeneValue("branch_angle").Value = 1.0 / 3
+	return NewGenome(c, []*Creature{root})
+}
+
+func TestGenomeRoundTrip(t *testing.T) {
+	g := test_genome()
+	for _, write := range []func(*Genome, *bytes.Buffer) error{
+		func(g *Genome, b *bytes.Buffer) error { return g.WriteJson(b) },
+		func(g *Genome, b *bytes.Buffer) error { return g.WriteText(b) },
+	} {
+		var buff bytes.Buffer
+		assert.NoError(t, write(g, &buff))
+		read, err := ReadGenome(&buff)
+		assert.NoError(t, err)
+		assert.Equal(t, g, read)
+		c, lineage, err := read.Creatures()
+		assert.NoError(t, err)
+		assert.Equal(t, 1.0/3, c.GetValue("branch_angle"))
+		assert.Len(t, lineage, 1)
+		assert.Equal(t, NewCreature(NewTreeSpecies()).ValuesMap(), lineage[0].ValuesMap())
+	}
+}
+
+func TestGenomeValidation(t *testing.T) {
+	for name, edit := range map[string]func(g *Genome){
+		"version":       func(g *Genome) { g.Version = 2 },
+		"species":       func(g *Genome) { g.Species = "fern" },
+		"unknown gene":  func(g *Genome) { g.Genes[0].Name = "leaf_color" },
+		"duplicate":     func(g *Genome) { g.Genes[1] = g.Genes[0] },
+		"missing gene":  func(g *Genome) { g.Genes = g.Genes[1:] },
+		"range":         func(g *Genome) { g.Genes[0].Max++ },
+		"out of range":  func(g *Genome) { g.Genes[0].Value = g.Genes[0].Max + 1 },
+		"ancestor size": func(g *Genome) { g.Lineage[0] = g.Lineage[0][1:] },
+		"ancestor":      func(g *Genome) { g.Lineage[0][0] = g.Genes[0].Min - 1 },
+	} {
+		g := test_genome()
+		edit(g)
+		assert.ErrorIs(t, g.Validate(), ErrInvalidGenome, name)
+		_, _, err := g.Creatures()
+		assert.Error(t, err, name)
+	}
+	for _, text := range []string{
+		"",
+		"{\"version\": 1, \"species\": \"tree\", \"colour\": 1}",
+		"biomorph-genome 1\nspecies tree\ngene num_gens 2 5\n",
+		"biomorph-genome 1\nspecies tree\nleaf 1\n",
+		"species tree\n",
+	} {
+		_, err := ReadGenome(strings.NewReader(text))
+		assert.ErrorIs(t, err, ErrInvalidGenome, text)
+	}
+}
@@ -0,0 +1,216 @@
+package biomorph
+
+import (
+	"context"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with -update after a deliberate rendering change to rewrite the
+// golden images, and look at them before committing.
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+const golden_dir = "testdata/golden"
+
+// Golden images may differ from a render by antialiasing noise: after
+// blurring both, no more than golden_fraction of the pixels may differ in
+// luminance by more than golden_threshold.
+const (
+	golden_threshold = 0.15
+	golden_fraction  = 0.002
+)
+
+// golden_case renders the creature in <name>.json, a genome, with opts and
+// compares it with <name>.png. Genomes are written by -update when missing,
+// from the species' initial creature or a random one from seed, and never
+// rewritten, so the canonical creatures stay put when breeding changes.
+type golden_case struct {
+	name    string
+	species string
+	seed    int64
+	opts    RenderOptions
+}
+
+var golden_cases = []golden_case{
+	{"tree", "tree", 0, RenderOptions{Size: ImageSize}},
+	{"tree_random", "tree", 7, RenderOptions{Size: ImageSize}},
+	{"tree_large", "tree", 7, RenderOptions{Size: 400}},
+	{"tree_quality", "tree", 7, RenderOptions{Size: ImageSize, Supersample: 2, Aliased: true, Cap: CapButt, Join: JoinBevel}},
+	{"tree_gray", "tree", 0, RenderOptions{Size: ImageSize, Gray: true, Cap: CapSquare, Join: JoinRound}},
+	{"tree3d", "tree3d", 0, RenderOptions{Size: ImageSize, Camera: Camera{Elevation: 0.3}}},
+	{"tree3d_perspective", "tree3d", 3, RenderOptions{Size: ImageSize, Camera: Camera{Azimuth: 1, Elevation: 0.3, Distance: 300}}},
+	{"fern", "fern", 1, RenderOptions{Size: ImageSize}},
+	{"bush", "bush", 1, RenderOptions{Size: ImageSize}},
+	{"weed", "weed", 1, RenderOptions{Size: ImageSize}},
+	{"centipede", "centipede", 1, RenderOptions{Size: ImageSize}},
+}
+
+func golden_creature(t *testing.T, gc golden_case) *Creature {
+	path := filepath.Join(golden_dir, gc.name+".json")
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && *update {
+		s, err := GetSpecies(gc.species)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		c := NewCreature(s)
+		if gc.seed != 0 {
+			c = RandomCreature(s, rand.New(rand.NewSource(gc.seed)))
+		}
+		f, err := os.Create(path)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer f.Close()
+		assert.NoError(t, NewGenome(c, nil).WriteJson(f))
+		return c
+	}
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer f.Close()
+	g, err := ReadGenome(f)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	c, _, err := g.Creatures()
+	assert.NoError(t, err)
+	return c
+}
+
+func TestGolden(t *testing.T) {
+	species, err := LoadSpeciesDir("species")
+	assert.NoError(t, err)
+	SetConfiguredSpecies(species)
+	defer SetConfiguredSpecies(nil)
+	if *update {
+		assert.NoError(t, os.MkdirAll(golden_dir, 0755))
+	}
+	for _, gc := range golden_cases {
+		t.Run(gc.name, func(t *testing.T) {
+			c := golden_creature(t, gc)
+			if c == nil {
+				return
+			}
+			got := Render(c, gc.opts)
+			path := filepath.Join(golden_dir, gc.name+".png")
+			if *update {
+				assert.NoError(t, write_png(path, got))
+				return
+			}
+			want, err := read_png(path)
+			if !assert.NoError(t, err, "run go test -run TestGolden -update to create it") {
+				return
+			}
+			diff, fraction := image_difference(got, want)
+			if fraction <= golden_fraction {
+				return
+			}
+			dir, _ := os.MkdirTemp("", "golden")
+			write_png(filepath.Join(dir, gc.name+".png"), got)
+			write_png(filepath.Join(dir, gc.name+".diff.png"), diff)
+			t.Errorf("%s differs from %s in %.2f%% of pixels; the render and a diff are in %s", gc.name, path, 100*fraction, dir)
+		})
+	}
+}
+
+func read_png(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func write_png(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// blurred_luminance is img's luminance from 0 to 1, each pixel averaged
+// with its neighbours.
+func blurred_luminance(img image.Image) [][]float64 {
+	r := img.Bounds()
+	w, h := r.Dx(), r.Dy()
+	out := make([][]float64, h)
+	for y := range out {
+		out[y] = make([]float64, w)
+		for x := range out[y] {
+			sum, n := 0.0, 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					px, py := x+dx, y+dy
+					if px < 0 || py < 0 || px >= w || py >= h {
+						continue
+					}
+					sum += float64(color.Gray16Model.Convert(img.At(r.Min.X+px, r.Min.Y+py)).(color.Gray16).Y) / 0xffff
+					n++
+				}
+			}
+			out[y][x] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// image_difference compares images as they look rather than pixel for
+// pixel, so sub-pixel shifts of antialiased edges barely count. It returns
+// a picture of the differences, darker where they're larger, and the
+// fraction of pixels differing by more than golden_threshold. Images of
+// different sizes differ everywhere.
+func image_difference(got image.Image, want image.Image) (image.Image, float64) {
+	if got.Bounds().Size() != want.Bounds().Size() {
+		return got, 1
+	}
+	a, b := blurred_luminance(got), blurred_luminance(want)
+	diff := image.NewGray(image.Rect(0, 0, len(a[0]), len(a)))
+	over := 0
+	for y := range a {
+		for x := range a[y] {
+			d := math.Abs(a[y][x] - b[y][x])
+			if d > golden_threshold {
+				over++
+			}
+			diff.SetGray(x, y, color.Gray{uint8(255 * (1 - d))})
+		}
+	}
+	return diff, float64(over) / float64(len(a)*len(a[0]))
+}
+
+func TestImageDifference(t *testing.T) {
+	draw := func(segments ...Segment) image.Image {
+		img, err := draw_segments(context.Background(), segments, RenderOptions{Size: ImageSize})
+		assert.NoError(t, err)
+		return img
+	}
+	trunk := Segment{75, 135, 75, 60}
+	branch := Segment{75, 60, 110, 30}
+	want := draw(trunk, branch)
+	_, fraction := image_difference(want, want)
+	assert.Zero(t, fraction)
+	// Moving everything by a third of a pixel only shifts antialiasing.
+	_, fraction = image_difference(draw(Segment{75.3, 135, 75.3, 60}, Segment{75.3, 60, 110.3, 30}), want)
+	assert.True(t, fraction <= golden_fraction, fraction)
+	_, fraction = image_difference(draw(trunk), want)
+	assert.True(t, fraction > golden_fraction, fraction)
+	small, _ := draw_segments(context.Background(), []Segment{trunk, branch}, RenderOptions{Size: 100})
+	_, fraction = image_difference(small, want)
+	assert.Equal(t, 1.0, fraction)
+}
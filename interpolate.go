@@ -0,0 +1,105 @@
+package biomorph
+
+import (
+	"fmt"
+)
+
+// Interpolation is how gene values move between keyframe creatures.
+type Interpolation int
+
+const (
+	Linear Interpolation = iota
+	// Spline is a Catmull-Rom spline through the keyframes, so values ease
+	// through each ancestor instead of changing direction abruptly.
+	Spline
+)
+
+func ParseInterpolation(name string) (Interpolation, error) {
+	switch name {
+	case "", "linear":
+		return Linear, nil
+	case "spline":
+		return Spline, nil
+	}
+	return Linear, fmt.Errorf("unknown interpolation %q, want linear or spline", name)
+}
+
+func clamp(v float64, r GeneRange) float64 {
+	if v > r.Max {
+		return r.Max
+	}
+	if v < r.Min {
+		return r.Min
+	}
+	return v
+}
+
+// InterpolateCreatures returns the creature t of the way from a to b, which
+// must be of the same species. It carries a's module copies until halfway
+// and b's from then on; a copy only one of them has keeps its values.
+func InterpolateCreatures(a *Creature, b *Creature, t float64) *Creature {
+	c := in_between(a, b, t)
+	av, bv := a.ValuesMap(), b.ValuesMap()
+	for _, v := range c.Values {
+		va := lookup(av, v.Gene.Name, bv[v.Gene.Name])
+		vb := lookup(bv, v.Gene.Name, va)
+		v.Value = v.Gene.Fit(va + (vb-va)*t)
+	}
+	return c
+}
+
+// in_between makes a creature shaped like a, or like b from halfway.
+func in_between(a *Creature, b *Creature, t float64) *Creature {
+	if t < 0.5 {
+		return NewCreatureCounts(a.CreatureSpecies, a.Counts())
+	}
+	return NewCreatureCounts(a.CreatureSpecies, b.Counts())
+}
+
+func lookup(values map[string]float64, name string, def float64) float64 {
+	if v, ok := values[name]; ok {
+		return v
+	}
+	return def
+}
+
+func catmull_rom(p0, p1, p2, p3, t float64) float64 {
+	return 0.5 * (2*p1 + (p2-p0)*t + (2*p0-5*p1+4*p2-p3)*t*t + (3*p1-p0-3*p2+p3)*t*t*t)
+}
+
+// Morph inserts steps in-between creatures between each consecutive pair of
+// keyframes. Keyframe k ends up at index k*(steps+1).
+func Morph(keyframes []*Creature, steps int, interp Interpolation) []*Creature {
+	if len(keyframes) < 2 || steps <= 0 {
+		return keyframes
+	}
+	frames := make([]*Creature, 0, (len(keyframes)-1)*(steps+1)+1)
+	for k := 0; k < len(keyframes)-1; k++ {
+		p1, p2 := keyframes[k], keyframes[k+1]
+		p0, p3 := p1, p2
+		if k > 0 {
+			p0 = keyframes[k-1]
+		}
+		if k+2 < len(keyframes) {
+			p3 = keyframes[k+2]
+		}
+		frames = append(frames, p1)
+		m0, m1, m2, m3 := p0.ValuesMap(), p1.ValuesMap(), p2.ValuesMap(), p3.ValuesMap()
+		for s := 1; s <= steps; s++ {
+			t := float64(s) / float64(steps+1)
+			if interp == Linear {
+				frames = append(frames, InterpolateCreatures(p1, p2, t))
+				continue
+			}
+			c := in_between(p1, p2, t)
+			for _, v := range c.Values {
+				name := v.Gene.Name
+				v1 := lookup(m1, name, m2[name])
+				v2 := lookup(m2, name, v1)
+				v.Value = v.Gene.Fit(catmull_rom(lookup(m0, name, v1), v1, v2, lookup(m3, name, v2), t))
+			}
+			frames = append(frames, c)
+		}
+	}
+	return append(frames, keyframes[len(keyframes)-1])
+}
@@ -0,0 +1,48 @@
+package biomorph
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolateCreatures(t *testing.T) {
+	a := NewCreature(NewTreeSpecies())
+	b := NewCreature(NewTreeSpecies())
+	b.GetGeneValue("num_gens").Value = 4
+	a.GetGeneValue("num_gens").Value = 2
+	assert.Equal(t, 3.0, InterpolateCreatures(a, b, 0.5).GetValue("num_gens"))
+	assert.Equal(t, a.ValuesMap(), InterpolateCreatures(a, b, 0).ValuesMap())
+	assert.Equal(t, b.ValuesMap(), InterpolateCreatures(a, b, 1).ValuesMap())
+}
+
+func TestMorph(t *testing.T) {
+	// In-between values of an integer gene stay whole.
+	genes := TreeGenes()
+	genes[1].Type = GeneInt
+	var keyframes []*Creature
+	for _, species := range []*Species{NewTreeSpecies(), NewSpecies("stepped", genes)} {
+		keyframes = nil
+		for _, gens := range []float64{2, 5, 2, 3} {
+			c := NewCreature(species)
+			c.GetGeneValue("num_gens").Value = gens
+			keyframes = append(keyframes, c)
+		}
+		for _, interp := range []Interpolation{Linear, Spline} {
+			frames := Morph(keyframes, 3, interp)
+			assert.Len(t, frames, 13)
+			for k, c := range keyframes {
+				assert.Equal(t, c, frames[k*4])
+			}
+			for _, c := range frames {
+				v := c.GetGeneValue("num_gens")
+				assert.True(t, v.Value >= v.Gene.Range.Min && v.Value <= v.Gene.Range.Max)
+				if v.Gene.Type == GeneInt {
+					assert.Equal(t, math.Round(v.Value), v.Value)
+				}
+			}
+		}
+	}
+	assert.Equal(t, keyframes[:1], Morph(keyframes[:1], 3, Spline))
+}
@@ -0,0 +1,539 @@
+package biomorph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrLSystemLimit = errors.New("l-system limit exceeded")
+
+// An LSystem draws a species by rewriting a string of symbols and reading
+// the result as turtle commands, starting at the bottom middle of an
+// ImageSize square, heading up:
+//
+//	F G  draw forward by the step
+//	f    move forward without drawing
+//	+ -  turn left or right by the angle
+//	|    turn around
+//	[ ]  save and restore the turtle's position, heading and step
+//	!    multiply the step by the scale
+//
+// Other symbols only take part in rewriting. Its numeric parameters are
+// LParams, so they can be genes and evolve. A rule with slots spells its
+// successor in genes too, so mutation and breeding rewrite the rule itself.
+type LSystem struct {
+	Axiom      string
+	Alphabet   string
+	Angle      LParam
+	Step       LParam
+	Scale      LParam
+	Iterations LParam
+	Seed       LParam
+	Ignore     string
+	MaxLength  int
+	Rules      []*LRule
+}
+
+// An LRule rewrites Pred, when it follows Left and is followed by Right,
+// into Successor. Among the rules that apply to a symbol, context-sensitive
+// ones win over context-free ones, and one is chosen with chance in
+// proportion to its Weight.
+type LRule struct {
+	Name      string
+	Left      string
+	Pred      byte
+	Right     string
+	Successor string
+	Weight    LParam
+	Slots     int
+}
+
+// DefaultLSystemLength caps the rewritten string when a definition doesn't.
+const DefaultLSystemLength = 100000
+
+// MaxLSystemIterations caps the iterations of every L-system, since a rule
+// that doesn't grow the string, like F -> F, never reaches MaxLength.
+const MaxLSystemIterations = 100
+
+// LParam is a number or the name of a gene.
+type LParam string
+
+// UnmarshalJSON accepts numbers as well as strings.
+func (p *LParam) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err == nil {
+		*p = LParam(strconv.FormatFloat(f, 'g', -1, 64))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("want a number or gene name, got %s", b)
+	}
+	*p = LParam(s)
+	return nil
+}
+
+func (p LParam) value(c *Creature, def float64) float64 {
+	if p == "" {
+		return def
+	}
+	if f, err := strconv.ParseFloat(string(p), 64); err == nil {
+		return f
+	}
+	return c.GetValue(string(p))
+}
+
+type LRuleConfig struct {
+	Name   string `json:"name,omitempty" yaml:"name"`
+	Rule   string `json:"rule" yaml:"rule"`
+	Weight LParam `json:"weight,omitempty" yaml:"weight"`
+	Slots  int    `json:"slots,omitempty" yaml:"slots"`
+}
+
+// LSystemConfig is the lsystem block of a species definition. Rules are
+// written "L < P > R -> S", where the contexts "L <" and "> R" are optional.
+// Alphabet, the symbols evolving successors are spelled with, defaults to
+// every symbol in the axiom and rules. Ignore, the symbols skipped when
+// matching contexts, defaults to "+-|!".
+type LSystemConfig struct {
+	Axiom      string        `json:"axiom" yaml:"axiom"`
+	Alphabet   string        `json:"alphabet,omitempty" yaml:"alphabet"`
+	Angle      LParam        `json:"angle" yaml:"angle"`
+	Step       LParam        `json:"step" yaml:"step"`
+	Scale      LParam        `json:"scale,omitempty" yaml:"scale"`
+	Iterations LParam        `json:"iterations" yaml:"iterations"`
+	Seed       LParam        `json:"seed,omitempty" yaml:"seed"`
+	Ignore     *string       `json:"ignore,omitempty" yaml:"ignore"`
+	MaxLength  int           `json:"max_length,omitempty" yaml:"max_length"`
+	Rules      []LRuleConfig `json:"rules" yaml:"rules"`
+}
+
+// ParseLRule reads a rule written "L < P > R -> S".
+func ParseLRule(s string) (*LRule, error) {
+	lhs, succ, ok := strings.Cut(s, "->")
+	if !ok {
+		return nil, fmt.Errorf("rule %q has no ->", s)
+	}
+	r := &LRule{Successor: strip_space(succ)}
+	if l, rest, ok := strings.Cut(lhs, "<"); ok {
+		r.Left, lhs = strip_space(l), rest
+	}
+	if rest, right, ok := strings.Cut(lhs, ">"); ok {
+		lhs, r.Right = rest, strip_space(right)
+	}
+	pred := strip_space(lhs)
+	if len(pred) != 1 {
+		return nil, fmt.Errorf("rule %q must rewrite one symbol, not %q", s, pred)
+	}
+	r.Pred = pred[0]
+	for _, part := range []string{r.Left, r.Right, r.Successor, pred} {
+		for i := 0; i < len(part); i++ {
+			if part[i] <= ' ' || part[i] > '~' {
+				return nil, fmt.Errorf("rule %q: symbols must be printable ASCII", s)
+			}
+		}
+	}
+	return r, nil
+}
+
+func strip_space(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// LSystem validates the block and builds the L-system, along with the genes
+// its slotted rules spell their successors in. Names are the genes the
+// species declares, which parameters may refer to.
+func (lc *LSystemConfig) LSystem(names []string) (*LSystem, []*Gene, error) {
+	declared := map[string]bool{}
+	for _, name := range names {
+		declared[name] = true
+	}
+	l := &LSystem{
+		Axiom:      strip_space(lc.Axiom),
+		Angle:      lc.Angle,
+		Step:       lc.Step,
+		Scale:      lc.Scale,
+		Iterations: lc.Iterations,
+		Seed:       lc.Seed,
+		Ignore:     "+-|!",
+		MaxLength:  lc.MaxLength,
+	}
+	if lc.Ignore != nil {
+		l.Ignore = *lc.Ignore
+	}
+	if l.MaxLength <= 0 {
+		l.MaxLength = DefaultLSystemLength
+	}
+	if l.Axiom == "" {
+		return nil, nil, fmt.Errorf("lsystem: no axiom")
+	}
+	params := []struct {
+		field    string
+		p        LParam
+		required bool
+	}{{"angle", l.Angle, true}, {"step", l.Step, true}, {"iterations", l.Iterations, true}, {"scale", l.Scale, false}, {"seed", l.Seed, false}}
+	for _, param := range params {
+		if param.p == "" && param.required {
+			return nil, nil, fmt.Errorf("lsystem: no %s", param.field)
+		}
+		if _, err := strconv.ParseFloat(string(param.p), 64); param.p != "" && err != nil && !declared[string(param.p)] {
+			return nil, nil, fmt.Errorf("lsystem: %s %q is neither a number nor a gene", param.field, param.p)
+		}
+	}
+	if n, err := strconv.ParseFloat(string(l.Iterations), 64); err == nil && !(n <= MaxLSystemIterations) {
+		return nil, nil, fmt.Errorf("lsystem: iterations %v is more than %d", n, MaxLSystemIterations)
+	}
+	if len(lc.Rules) == 0 {
+		return nil, nil, fmt.Errorf("lsystem: no rules")
+	}
+	symbols := l.Axiom
+	for i, rc := range lc.Rules {
+		r, err := ParseLRule(rc.Rule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("lsystem rule %d: %v", i+1, err)
+		}
+		r.Name, r.Weight, r.Slots = rc.Name, rc.Weight, rc.Slots
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if !species_name_pattern.MatchString(r.Name) {
+			return nil, nil, fmt.Errorf("lsystem rule %d: name %q must be lower case letters, digits, _ or -, starting with a letter", i+1, r.Name)
+		}
+		if _, err := strconv.ParseFloat(string(r.Weight), 64); r.Weight != "" && err != nil && !declared[string(r.Weight)] {
+			return nil, nil, fmt.Errorf("lsystem rule %d: weight %q is neither a number nor a gene", i+1, r.Weight)
+		}
+		if r.Slots < 0 || r.Slots > 64 {
+			return nil, nil, fmt.Errorf("lsystem rule %d: slots %d must be between 0 and 64", i+1, r.Slots)
+		}
+		if r.Slots > 0 && len(r.Successor) > r.Slots {
+			return nil, nil, fmt.Errorf("lsystem rule %d: successor %q is longer than its %d slots", i+1, r.Successor, r.Slots)
+		}
+		symbols += r.Left + string(r.Pred) + r.Right + r.Successor
+		l.Rules = append(l.Rules, r)
+	}
+	l.Alphabet = lc.Alphabet
+	if l.Alphabet == "" {
+		l.Alphabet = alphabet_of(symbols)
+	}
+	if len(alphabet_of(l.Alphabet)) != len(l.Alphabet) {
+		return nil, nil, fmt.Errorf("lsystem: alphabet %q repeats a symbol", l.Alphabet)
+	}
+	var genes []*Gene
+	for i, r := range l.Rules {
+		for k := 0; k < r.Slots; k++ {
+			name := r.slot(k)
+			if declared[name] {
+				return nil, nil, fmt.Errorf("lsystem rule %d: slot gene %q is already a gene", i+1, name)
+			}
+			declared[name] = true
+			def := 0.0
+			if k < len(r.Successor) {
+				j := strings.IndexByte(l.Alphabet, r.Successor[k])
+				if j < 0 {
+					return nil, nil, fmt.Errorf("lsystem rule %d: successor symbol %q is not in the alphabet %q", i+1, r.Successor[k], l.Alphabet)
+				}
+				def = float64(j + 1)
+			}
+			genes = append(genes, &Gene{GeneRange{0, float64(len(l.Alphabet))}, name, GeneInt, &def})
+		}
+	}
+	return l, genes, nil
+}
+
+// alphabet_of lists the distinct symbols of s, sorted.
+func alphabet_of(s string) string {
+	seen := map[byte]bool{}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if !seen[s[i]] {
+			seen[s[i]] = true
+			b = append(b, s[i])
+		}
+	}
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return string(b)
+}
+
+// slot names the gene holding symbol k of r's successor.
+func (r *LRule) slot(k int) string {
+	return fmt.Sprintf("%s_%d", r.Name, k)
+}
+
+// String writes the definition out, identifying it in render cache keys.
+func (l *LSystem) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%s|%s|%s|%s|%s|%s|%s|%d", l.Axiom, l.Alphabet, l.Angle, l.Step, l.Scale, l.Iterations, l.Seed, l.Ignore, l.MaxLength)
+	for _, r := range l.Rules {
+		fmt.Fprintf(&b, "|%s:%s<%c>%s->%s*%s/%d", r.Name, r.Left, r.Pred, r.Right, r.Successor, r.Weight, r.Slots)
+	}
+	return b.String()
+}
+
+// Successor is the string r rewrites to in c. A slotted rule reads it from
+// its genes, where 0 is no symbol and k the kth symbol of the alphabet, and
+// brackets are balanced so any mutant still draws.
+func (l *LSystem) Successor(c *Creature, r *LRule) string {
+	if r.Slots == 0 {
+		return r.Successor
+	}
+	var b []byte
+	open := 0
+	for k := 0; k < r.Slots; k++ {
+		j := int(c.GetValue(r.slot(k)))
+		if j < 1 || j > len(l.Alphabet) {
+			continue
+		}
+		switch s := l.Alphabet[j-1]; s {
+		case '[':
+			open++
+			b = append(b, s)
+		case ']':
+			if open > 0 {
+				open--
+				b = append(b, s)
+			}
+		default:
+			b = append(b, s)
+		}
+	}
+	for ; open > 0; open-- {
+		b = append(b, ']')
+	}
+	return string(b)
+}
+
+// RuleStrings lists the rules as c spells them, in the "L < P > R -> S" form.
+func (l *LSystem) RuleStrings(c *Creature) []string {
+	rules := make([]string, len(l.Rules))
+	for i, r := range l.Rules {
+		var b strings.Builder
+		if r.Left != "" {
+			b.WriteString(r.Left + " < ")
+		}
+		b.WriteByte(r.Pred)
+		if r.Right != "" {
+			b.WriteString(" > " + r.Right)
+		}
+		b.WriteString(" -> " + l.Successor(c, r))
+		rules[i] = b.String()
+	}
+	return rules
+}
+
+type lsystem_rule struct {
+	*LRule
+	successor string
+	weight    float64
+}
+
+// Expand rewrites the axiom for c's number of iterations. Stochastic rules
+// are chosen with a random source seeded by the seed parameter, so a
+// creature always expands the same way. If the string would grow past
+// MaxLength, it stops with ErrLSystemLimit and the last string that fit; a
+// gene asking for more than MaxLSystemIterations gets that many, also with
+// ErrLSystemLimit.
+func (l *LSystem) Expand(c *Creature) (string, error) {
+	return l.ExpandContext(context.Background(), c)
+}
+
+// ExpandContext is Expand stopping early, with ctx's error and the last
+// whole iteration, if ctx is done.
+func (l *LSystem) ExpandContext(ctx context.Context, c *Creature) (string, error) {
+	rules := map[byte][]lsystem_rule{}
+	for _, r := range l.Rules {
+		rules[r.Pred] = append(rules[r.Pred], lsystem_rule{r, l.Successor(c, r), math.Max(0, r.Weight.value(c, 1))})
+	}
+	r := rand.New(rand.NewSource(int64(l.Seed.value(c, 1))))
+	s := l.Axiom
+	var limit_err error
+	iterations := math.Floor(l.Iterations.value(c, 0))
+	if !(iterations <= MaxLSystemIterations) {
+		limit_err = fmt.Errorf("%w: %v iterations, more than %d", ErrLSystemLimit, iterations, MaxLSystemIterations)
+		iterations = MaxLSystemIterations
+	}
+	n := int(iterations)
+	for it := 0; it < n; it++ {
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			if i%ctx_steps == 0 {
+				if err := ctx.Err(); err != nil {
+					return s, err
+				}
+			}
+			if next := l.rewrite(s, i, rules[s[i]], r); next != nil {
+				b.WriteString(*next)
+			} else {
+				b.WriteByte(s[i])
+			}
+			if b.Len() > l.MaxLength {
+				return s, fmt.Errorf("%w: string longer than %d symbols after %d iterations", ErrLSystemLimit, l.MaxLength, it+1)
+			}
+		}
+		s = b.String()
+	}
+	return s, limit_err
+}
+
+// rewrite picks the successor of s[i], or nil if no rule applies.
+func (l *LSystem) rewrite(s string, i int, rules []lsystem_rule, r *rand.Rand) *string {
+	var context, free []lsystem_rule
+	for _, rule := range rules {
+		if rule.Left == "" && rule.Right == "" {
+			free = append(free, rule)
+		} else if l.left_matches(s, i, rule.Left) && l.right_matches(s, i, rule.Right) {
+			context = append(context, rule)
+		}
+	}
+	if len(context) == 0 {
+		context = free
+	}
+	if len(context) == 0 {
+		return nil
+	}
+	total := 0.0
+	for _, rule := range context {
+		total += rule.weight
+	}
+	if len(context) == 1 || total == 0 {
+		return &context[0].successor
+	}
+	pick := r.Float64() * total
+	for _, rule := range context {
+		if pick -= rule.weight; pick < 0 {
+			return &rule.successor
+		}
+	}
+	return &context[len(context)-1].successor
+}
+
+// left_matches reports whether the symbols before s[i] end with left,
+// skipping ignored symbols, whole branches and the starts of branches, so a
+// symbol's left context is its parent.
+func (l *LSystem) left_matches(s string, i int, left string) bool {
+	k := len(left) - 1
+	for j := i - 1; k >= 0 && j >= 0; j-- {
+		switch {
+		case s[j] == ']':
+			for depth := 1; depth > 0 && j > 0; {
+				j--
+				if s[j] == ']' {
+					depth++
+				} else if s[j] == '[' {
+					depth--
+				}
+			}
+		case s[j] == '[' || strings.IndexByte(l.Ignore, s[j]) >= 0:
+		case s[j] == left[k]:
+			k--
+		default:
+			return false
+		}
+	}
+	return k < 0
+}
+
+// right_matches reports whether the symbols after s[i] start with right,
+// skipping ignored symbols and side branches. The context can't leave the
+// branch s[i] is on.
+func (l *LSystem) right_matches(s string, i int, right string) bool {
+	k := 0
+	for j := i + 1; k < len(right) && j < len(s); j++ {
+		switch {
+		case s[j] == '[':
+			for depth := 1; depth > 0 && j < len(s)-1; {
+				j++
+				if s[j] == '[' {
+					depth++
+				} else if s[j] == ']' {
+					depth--
+				}
+			}
+		case s[j] == ']':
+			return false
+		case strings.IndexByte(l.Ignore, s[j]) >= 0:
+		case s[j] == right[k]:
+			k++
+		default:
+			return false
+		}
+	}
+	return k == len(right)
+}
+
+// Segments expands c and draws it. A drawing that runs off the image is
+// shrunk towards the turtle's start until it fits. On error it draws the
+// string that fit along with it.
+func (l *LSystem) Segments(c *Creature) ([]Segment, error) {
+	return l.SegmentsContext(context.Background(), c)
+}
+
+// SegmentsContext is Segments expanding with ExpandContext.
+func (l *LSystem) SegmentsContext(ctx context.Context, c *Creature) ([]Segment, error) {
+	s, err := l.ExpandContext(ctx, c)
+	angle := l.Angle.value(c, 0)
+	scale := l.Scale.value(c, 1)
+	type state struct{ x, y, heading, step float64 }
+	start := state{ImageSize / 2, ImageSize * 9 / 10, 0, l.Step.value(c, 1)}
+	t := start
+	var stack []state
+	var segments []Segment
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 'F', 'G', 'f':
+			nx, ny := t.x-t.step*math.Sin(t.heading), t.y-t.step*math.Cos(t.heading)
+			if s[i] != 'f' {
+				segments = append(segments, Segment{t.x, t.y, nx, ny})
+			}
+			t.x, t.y = nx, ny
+		case '+':
+			t.heading += angle
+		case '-':
+			t.heading -= angle
+		case '|':
+			t.heading += math.Pi
+		case '!':
+			t.step *= scale
+		case '[':
+			stack = append(stack, t)
+		case ']':
+			if len(stack) > 0 {
+				t, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			}
+		}
+	}
+	fit_segments(segments, start.x, start.y)
+	return segments, err
+}
+
+// fit_segments shrinks segments towards (x0, y0) until they fit in the
+// image, with a small margin.
+func fit_segments(segments []Segment, x0, y0 float64) {
+	const margin = 2
+	shrink := 1.0
+	fit := func(extent, room float64) {
+		if extent > room && room > 0 {
+			shrink = math.Min(shrink, room/extent)
+		}
+	}
+	for _, sg := range segments {
+		for _, p := range [][2]float64{{sg.X1, sg.Y1}, {sg.X2, sg.Y2}} {
+			fit(x0-p[0], x0-margin)
+			fit(p[0]-x0, ImageSize-margin-x0)
+			fit(y0-p[1], y0-margin)
+			fit(p[1]-y0, ImageSize-margin-y0)
+		}
+	}
+	if shrink == 1 {
+		return
+	}
+	for i, sg := range segments {
+		segments[i] = Segment{x0 + (sg.X1-x0)*shrink, y0 + (sg.Y1-y0)*shrink, x0 + (sg.X2-x0)*shrink, y0 + (sg.Y2-y0)*shrink}
+	}
+}
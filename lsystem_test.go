@@ -0,0 +1,205 @@
+package biomorph
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lsystem_species(t *testing.T, body string) *Species {
+	sc, err := ParseSpeciesConfig("test.yaml", []byte(body))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s, err := sc.Species()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return s
+}
+
+func TestParseLRule(t *testing.T) {
+	r, err := ParseLRule("AB < C > D[E] -> F [ +C ]")
+	assert.NoError(t, err)
+	assert.Equal(t, &LRule{Left: "AB", Pred: 'C', Right: "D[E]", Successor: "F[+C]"}, r)
+	r, err = ParseLRule("X->")
+	assert.NoError(t, err)
+	assert.Equal(t, &LRule{Pred: 'X'}, r)
+	for _, bad := range []string{"X = F", "XY -> F", " -> F"} {
+		_, err := ParseLRule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLSystemExpand(t *testing.T) {
+	s := lsystem_species(t, `name: algae
+lsystem:
+  axiom: A
+  angle: 0
+  step: 1
+  iterations: depth
+  rules:
+    - {rule: "A -> AB"}
+    - {rule: "B -> A"}
+genes:
+  - {name: depth, min: 0, max: 10, type: int, default: 5}
+`)
+	c := NewCreature(s)
+	got, err := s.LSystem.Expand(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "ABAABABAABAAB", got)
+	assert.Empty(t, CreatureSegments(c))
+}
+
+func TestLSystemContext(t *testing.T) {
+	// The side branch is skipped looking along the stem, and the turn is
+	// ignored.
+	s := lsystem_species(t, `name: signal
+lsystem:
+  axiom: "BA[+A]A-C"
+  angle: 0.5
+  step: 1
+  iterations: 1
+  rules:
+    - {rule: "B < A -> B"}
+    - {rule: "A > C -> D"}
+    - {rule: "B -> A"}
+`)
+	got, err := s.LSystem.Expand(NewCreature(s))
+	assert.NoError(t, err)
+	assert.Equal(t, "AB[+A]D-C", got)
+}
+
+func TestLSystemStochastic(t *testing.T) {
+	s := lsystem_species(t, `name: coin
+lsystem:
+  axiom: XXXXXXXXXXXXXXXXXXXX
+  angle: 0
+  step: 1
+  iterations: 1
+  seed: seed
+  rules:
+    - {rule: "X -> H", weight: heads}
+    - {rule: "X -> T", weight: 1}
+genes:
+  - {name: heads, min: 0, max: 1}
+  - {name: seed, min: 1, max: 100, type: int}
+`)
+	c := NewCreature(s)
+	got, err := s.LSystem.Expand(c)
+	assert.NoError(t, err)
+	assert.Contains(t, got, "H")
+	assert.Contains(t, got, "T")
+	again, _ := s.LSystem.Expand(c)
+	assert.Equal(t, got, again)
+	c.GetGeneValue("heads").Value = 0
+	got, _ = s.LSystem.Expand(c)
+	assert.Equal(t, strings.Repeat("T", 20), got)
+}
+
+func TestLSystemSlots(t *testing.T) {
+	s := lsystem_species(t, `name: slotted
+mutation: {rate: 1, scale: 1}
+lsystem:
+  axiom: X
+  alphabet: "FX+-[]"
+  angle: 0.4
+  step: 4
+  iterations: 3
+  rules:
+    - {name: grow, rule: "X -> F[+X]-X", slots: 10}
+`)
+	assert.NotNil(t, s.Gene("grow_9"))
+	c := NewCreature(s)
+	l := s.LSystem
+	assert.Equal(t, []string{"X -> F[+X]-X"}, l.RuleStrings(c))
+	assert.NotEmpty(t, CreatureSegments(c))
+
+	c.GetGeneValue("grow_0").Value = 6 // ]
+	c.GetGeneValue("grow_9").Value = 5 // [
+	assert.Equal(t, "[+X]-X[]", l.Successor(c, l.Rules[0]))
+
+	r := rand.New(rand.NewSource(1))
+	changed := false
+	for i := 0; i < 10; i++ {
+		m := MutateCreatureRand(c, r)
+		succ := l.Successor(m, l.Rules[0])
+		assert.Equal(t, strings.Count(succ, "["), strings.Count(succ, "]"), succ)
+		changed = changed || succ != l.Successor(c, l.Rules[0])
+		m.CreatureSpecies.LSystem.Segments(m)
+	}
+	assert.True(t, changed)
+}
+
+func TestLSystemLimit(t *testing.T) {
+	s := lsystem_species(t, `name: bloat
+lsystem:
+  axiom: F
+  angle: 0.3
+  step: 50
+  iterations: 20
+  max_length: 1000
+  rules:
+    - {rule: "F -> F[+F]F"}
+`)
+	segments, err := s.LSystem.Segments(NewCreature(s))
+	assert.ErrorIs(t, err, ErrLSystemLimit)
+	assert.Len(t, segments, 243)
+	for _, sg := range segments {
+		for _, v := range []float64{sg.X1, sg.Y1, sg.X2, sg.Y2} {
+			assert.True(t, v >= 0 && v <= ImageSize, v)
+		}
+	}
+}
+
+func TestLSystemIterationLimit(t *testing.T) {
+	s := lsystem_species(t, `name: idle
+lsystem:
+  axiom: F
+  angle: 0.3
+  step: 50
+  iterations: iterations
+  rules:
+    - {rule: "F -> F"}
+genes:
+  - {name: iterations, min: 0, max: 1e12}
+`)
+	c := NewCreature(s)
+	c.SetValuesFromMap(map[string]float64{"iterations": 1e12})
+	got, err := s.LSystem.Expand(c)
+	assert.ErrorIs(t, err, ErrLSystemLimit)
+	assert.Equal(t, "F", got)
+}
+
+func TestLSystemConfigErrors(t *testing.T) {
+	const base = `name: bad
+lsystem:
+  axiom: X
+  angle: angle
+  step: 2
+  iterations: 3
+  rules:
+    - {rule: "X -> F[+X]", slots: 6}
+genes:
+  - {name: angle, min: 0, max: 1}
+`
+	for want, body := range map[string]string{
+		"angle \"angle\" is neither":  strings.Replace(base, "name: angle", "name: twist", 1),
+		"longer than its 4 slots":     strings.Replace(base, "slots: 6", "slots: 4", 1),
+		"slot gene \"rule1_0\"":       base + "  - {name: rule1_0, min: 0, max: 1}\n",
+		"not in the alphabet \"F+X\"": strings.Replace(base, "axiom: X", "axiom: X\n  alphabet: F+X", 1),
+		"must rewrite one symbol":     strings.Replace(base, "X -> F", "XX -> F", 1),
+		"can't be used with renderer": "renderer: tree\n" + base,
+		"iterations 1e+12 is more":    strings.Replace(base, "iterations: 3", "iterations: 1e12", 1),
+	} {
+		sc, err := ParseSpeciesConfig("bad.yaml", []byte(body))
+		if assert.NoError(t, err, want) {
+			_, err = sc.Species()
+			if assert.Error(t, err, want) {
+				assert.Contains(t, err.Error(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package biomorph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var ErrMeshFormat = errors.New("unsupported mesh format")
+
+// MeshFormats are the formats Mesh.Write takes, named by file extension.
+var MeshFormats = []string{"stl", "obj", "ply"}
+
+// Mesh is a triangle mesh in the units of Segment3, ImageSize units being
+// millimetres when printed. Triangles index Vertices counter clockwise seen
+// from outside.
+type Mesh struct {
+	Vertices  []Vec3
+	Triangles [][3]int
+}
+
+// TubeMesh wraps each segment in a closed prism with sides faces, capped at
+// both ends. Segments of zero length or radius are left out. It is quick
+// but the prisms overlap where branches meet; SolidMesh merges them.
+func TubeMesh(segments []Segment3, sides int) *Mesh {
+	m := &Mesh{}
+	for _, s := range segments {
+		axis := s.B.Sub(s.A)
+		if axis.Len() == 0 || s.Radius <= 0 {
+			continue
+		}
+		d := axis.Unit()
+		// Any direction not along d gives the rings' plane.
+		other := Vec3{1, 0, 0}
+		if math.Abs(d.X) > 0.9 {
+			other = Vec3{0, 1, 0}
+		}
+		u := d.Cross(other).Unit()
+		v := d.Cross(u)
+		base := len(m.Vertices)
+		for _, end := range []Vec3{s.A, s.B} {
+			for i := 0; i < sides; i++ {
+				a := 2 * math.Pi * float64(i) / float64(sides)
+				m.Vertices = append(m.Vertices, end.Add(u.Scale(s.Radius*math.Cos(a))).Add(v.Scale(s.Radius*math.Sin(a))))
+			}
+		}
+		m.Vertices = append(m.Vertices, s.A, s.B)
+		ca, cb := base+2*sides, base+2*sides+1
+		for i := 0; i < sides; i++ {
+			j := (i + 1) % sides
+			a0, a1, b0, b1 := base+i, base+j, base+sides+i, base+sides+j
+			m.Triangles = append(m.Triangles, [3]int{a0, a1, b1}, [3]int{a0, b1, b0}, [3]int{cb, b0, b1}, [3]int{ca, a1, a0})
+		}
+	}
+	return m
+}
+
+// DefaultMeshCells is how many grid cells span a creature's longest side
+// when SolidMesh samples it.
+const DefaultMeshCells = 96
+
+// CreatureMesh is c's branches as one watertight mesh, sampled on a grid of
+// cells across its longest side. 2D creatures are branches of the drawn
+// line's width lying flat.
+func CreatureMesh(c *Creature, cells int) *Mesh {
+	return SolidMesh(CreatureSegments3(c), cells)
+}
+
+// solid_grid samples the distance to the surface of the union of capsules
+// around segments, negative inside, at the corners of cubic cells.
+type solid_grid struct {
+	origin     Vec3
+	cell       float64
+	nx, ny, nz int
+	values     []float64
+	// edges holds the mesh vertex made on the edge between two corners.
+	edges map[[2]int]int
+	mesh  *Mesh
+}
+
+func (g *solid_grid) index(i, j, k int) int {
+	return i + g.nx*(j+g.ny*k)
+}
+
+func (g *solid_grid) point(index int) Vec3 {
+	i, j, k := index%g.nx, index/g.nx%g.ny, index/(g.nx*g.ny)
+	return g.origin.Add(Vec3{float64(i), float64(j), float64(k)}.Scale(g.cell))
+}
+
+// capsule_distance is how far p is outside a capsule of radius r around a
+// to b.
+func capsule_distance(p, a, b Vec3, r float64) float64 {
+	ab := b.Sub(a)
+	t := 0.0
+	if l := ab.Dot(ab); l > 0 {
+		t = math.Max(0, math.Min(1, p.Sub(a).Dot(ab)/l))
+	}
+	return p.Sub(a.Add(ab.Scale(t))).Len() - r
+}
+
+// SolidMesh is the surface of the union of capsules around segments, so
+// branches merge where they meet and the mesh is closed and manifold: every
+// edge joins exactly two triangles, once in each direction. The distance
+// to the surface is sampled with cells cells across the longest side and
+// the surface found by marching tetrahedra. Branches thinner than a cell
+// are thickened to one so they survive sampling and print.
+func SolidMesh(segments []Segment3, cells int) *Mesh {
+	m := &Mesh{}
+	if len(segments) == 0 || cells < 1 {
+		return m
+	}
+	lo, hi := Vec3{math.Inf(1), math.Inf(1), math.Inf(1)}, Vec3{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, s := range segments {
+		for _, p := range []Vec3{s.A, s.B} {
+			lo = Vec3{math.Min(lo.X, p.X-s.Radius), math.Min(lo.Y, p.Y-s.Radius), math.Min(lo.Z, p.Z-s.Radius)}
+			hi = Vec3{math.Max(hi.X, p.X+s.Radius), math.Max(hi.Y, p.Y+s.Radius), math.Max(hi.Z, p.Z+s.Radius)}
+		}
+	}
+	size := hi.Sub(lo)
+	cell := math.Max(size.X, math.Max(size.Y, size.Z)) / float64(cells)
+	if cell == 0 {
+		return m
+	}
+	// Thickening grows the bounds, and a margin of outside corners keeps the
+	// surface off the edge of the grid.
+	margin := Vec3{1, 1, 1}.Scale(3 * cell)
+	lo, hi = lo.Sub(margin), hi.Add(margin)
+	g := &solid_grid{origin: lo, cell: cell, edges: map[[2]int]int{}, mesh: m}
+	g.nx, g.ny, g.nz = int((hi.X-lo.X)/cell)+2, int((hi.Y-lo.Y)/cell)+2, int((hi.Z-lo.Z)/cell)+2
+	g.values = make([]float64, g.nx*g.ny*g.nz)
+	for i := range g.values {
+		g.values[i] = math.Inf(1)
+	}
+	for _, s := range segments {
+		r := math.Max(s.Radius, cell)
+		reach := r + 2*cell
+		first := func(a, b, o float64) int { return int(math.Max(0, math.Floor((math.Min(a, b)-reach-o)/cell))) }
+		last := func(a, b, o float64, n int) int {
+			return int(math.Min(float64(n-1), math.Ceil((math.Max(a, b)+reach-o)/cell)))
+		}
+		for k := first(s.A.Z, s.B.Z, lo.Z); k <= last(s.A.Z, s.B.Z, lo.Z, g.nz); k++ {
+			for j := first(s.A.Y, s.B.Y, lo.Y); j <= last(s.A.Y, s.B.Y, lo.Y, g.ny); j++ {
+				for i := first(s.A.X, s.B.X, lo.X); i <= last(s.A.X, s.B.X, lo.X, g.nx); i++ {
+					index := g.index(i, j, k)
+					g.values[index] = math.Min(g.values[index], capsule_distance(g.point(index), s.A, s.B, r))
+				}
+			}
+		}
+	}
+	// A corner exactly on the surface would put several vertices in one
+	// place; count it as outside by a hair.
+	for i, v := range g.values {
+		if v == 0 {
+			g.values[i] = 1e-12
+		}
+	}
+	// Each cube splits into six tetrahedra around its main diagonal, the
+	// same way in every cube so neighbours agree on the faces they share.
+	tets := [6][4]int{{0, 1, 3, 7}, {0, 1, 5, 7}, {0, 2, 3, 7}, {0, 2, 6, 7}, {0, 4, 5, 7}, {0, 4, 6, 7}}
+	var corners [8]int
+	for k := 0; k+1 < g.nz; k++ {
+		for j := 0; j+1 < g.ny; j++ {
+			for i := 0; i+1 < g.nx; i++ {
+				inside := 0
+				for c := range corners {
+					corners[c] = g.index(i+c&1, j+c>>1&1, k+c>>2&1)
+					if g.values[corners[c]] < 0 {
+						inside++
+					}
+				}
+				if inside == 0 || inside == 8 {
+					continue
+				}
+				for _, t := range tets {
+					g.tetrahedron([4]int{corners[t[0]], corners[t[1]], corners[t[2]], corners[t[3]]})
+				}
+			}
+		}
+	}
+	return m
+}
+
+// vertex is the mesh vertex where the surface crosses the edge from corner
+// a inside to corner b outside.
+func (g *solid_grid) vertex(a, b int) int {
+	key := [2]int{a, b}
+	if a > b {
+		key = [2]int{b, a}
+	}
+	if v, ok := g.edges[key]; ok {
+		return v
+	}
+	va, vb := g.values[a], g.values[b]
+	pa, pb := g.point(a), g.point(b)
+	g.mesh.Vertices = append(g.mesh.Vertices, pa.Add(pb.Sub(pa).Scale(va/(va-vb))))
+	g.edges[key] = len(g.mesh.Vertices) - 1
+	return g.edges[key]
+}
+
+// triangle adds the triangle through the surface's crossings of edges,
+// each an inside and an outside corner, facing away from the inside
+// corners. Its facing is decided on the edges' midpoints, which unlike the
+// crossings never make a degenerate triangle.
+func (g *solid_grid) triangle(edges [3][2]int, in Vec3, out Vec3) {
+	var mid [3]Vec3
+	for i, e := range edges {
+		mid[i] = g.point(e[0]).Add(g.point(e[1])).Scale(0.5)
+	}
+	if mid[1].Sub(mid[0]).Cross(mid[2].Sub(mid[0])).Dot(out.Sub(in)) < 0 {
+		edges[1], edges[2] = edges[2], edges[1]
+	}
+	g.mesh.Triangles = append(g.mesh.Triangles, [3]int{g.vertex(edges[0][0], edges[0][1]), g.vertex(edges[1][0], edges[1][1]), g.vertex(edges[2][0], edges[2][1])})
+}
+
+// tetrahedron adds the part of the surface inside the tetrahedron with
+// corners t: a triangle cutting off one corner or a quad between two pairs.
+func (g *solid_grid) tetrahedron(t [4]int) {
+	var in, out []int
+	var in_sum, out_sum Vec3
+	for _, c := range t {
+		if g.values[c] < 0 {
+			in = append(in, c)
+			in_sum = in_sum.Add(g.point(c))
+		} else {
+			out = append(out, c)
+			out_sum = out_sum.Add(g.point(c))
+		}
+	}
+	if len(in) == 0 || len(out) == 0 {
+		return
+	}
+	in_mid, out_mid := in_sum.Scale(1/float64(len(in))), out_sum.Scale(1/float64(len(out)))
+	switch len(in) {
+	case 1:
+		g.triangle([3][2]int{{in[0], out[0]}, {in[0], out[1]}, {in[0], out[2]}}, in_mid, out_mid)
+	case 3:
+		g.triangle([3][2]int{{in[0], out[0]}, {in[1], out[0]}, {in[2], out[0]}}, in_mid, out_mid)
+	case 2:
+		// The crossings go round the quad in this order.
+		g.triangle([3][2]int{{in[0], out[0]}, {in[0], out[1]}, {in[1], out[1]}}, in_mid, out_mid)
+		g.triangle([3][2]int{{in[0], out[0]}, {in[1], out[1]}, {in[1], out[0]}}, in_mid, out_mid)
+	}
+}
+
+// normal is the unit normal of triangle t.
+func (m *Mesh) normal(t [3]int) Vec3 {
+	a, b, c := m.Vertices[t[0]], m.Vertices[t[1]], m.Vertices[t[2]]
+	return b.Sub(a).Cross(c.Sub(a)).Unit()
+}
+
+// Volume is the mesh's signed volume, positive for a closed mesh facing out.
+func (m *Mesh) Volume() float64 {
+	v := 0.0
+	for _, t := range m.Triangles {
+		v += m.Vertices[t[0]].Dot(m.Vertices[t[1]].Cross(m.Vertices[t[2]])) / 6
+	}
+	return v
+}
+
+// WriteObj writes the mesh as a Wavefront OBJ file, Y up.
+func (m *Mesh) WriteObj(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# biomorph")
+	for _, v := range m.Vertices {
+		fmt.Fprintf(bw, "v %s %s %s\n", format_float(v.X), format_float(v.Y), format_float(v.Z))
+	}
+	for _, t := range m.Triangles {
+		fmt.Fprintf(bw, "f %d %d %d\n", t[0]+1, t[1]+1, t[2]+1)
+	}
+	return bw.Flush()
+}
+
+// stl_up turns Y up into the Z up that slicers expect.
+func stl_up(v Vec3) Vec3 {
+	return Vec3{v.X, -v.Z, v.Y}
+}
+
+// WritePly writes the mesh as a binary PLY file, Y up.
+func (m *Mesh) WritePly(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat binary_little_endian 1.0\ncomment biomorph\n")
+	fmt.Fprintf(bw, "element vertex %d\nproperty float x\nproperty float y\nproperty float z\n", len(m.Vertices))
+	fmt.Fprintf(bw, "element face %d\nproperty list uchar int vertex_indices\nend_header\n", len(m.Triangles))
+	for _, v := range m.Vertices {
+		binary.Write(bw, binary.LittleEndian, []float32{float32(v.X), float32(v.Y), float32(v.Z)})
+	}
+	for _, t := range m.Triangles {
+		bw.WriteByte(3)
+		binary.Write(bw, binary.LittleEndian, []int32{int32(t[0]), int32(t[1]), int32(t[2])})
+	}
+	return bw.Flush()
+}
+
+// WriteStl writes the mesh as a binary STL file, Z up so it prints
+// standing.
+func (m *Mesh) WriteStl(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var header [80]byte
+	copy(header[:], "biomorph")
+	bw.Write(header[:])
+	binary.Write(bw, binary.LittleEndian, uint32(len(m.Triangles)))
+	for _, t := range m.Triangles {
+		n := stl_up(m.normal(t))
+		values := []float32{float32(n.X), float32(n.Y), float32(n.Z)}
+		for _, i := range t {
+			v := stl_up(m.Vertices[i])
+			values = append(values, float32(v.X), float32(v.Y), float32(v.Z))
+		}
+		binary.Write(bw, binary.LittleEndian, values)
+		binary.Write(bw, binary.LittleEndian, uint16(0))
+	}
+	return bw.Flush()
+}
+
+// Write writes the mesh in format, one of MeshFormats.
+func (m *Mesh) Write(w io.Writer, format string) error {
+	switch format {
+	case "stl":
+		return m.WriteStl(w)
+	case "obj":
+		return m.WriteObj(w)
+	case "ply":
+		return m.WritePly(w)
+	}
+	return fmt.Errorf("%w %q, want one of %v", ErrMeshFormat, format, MeshFormats)
+}
@@ -0,0 +1,88 @@
+package biomorph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assert_manifold checks m is closed and consistently facing: every edge is
+// used once in each direction, so exactly two triangles meet there, and no
+// triangle repeats a vertex. It returns the Euler characteristic.
+func assert_manifold(t *testing.T, m *Mesh) int {
+	t.Helper()
+	edges := map[[2]int]int{}
+	for _, tri := range m.Triangles {
+		assert.True(t, tri[0] != tri[1] && tri[1] != tri[2] && tri[2] != tri[0], tri)
+		for i := 0; i < 3; i++ {
+			edges[[2]int{tri[i], tri[(i+1)%3]}]++
+		}
+	}
+	bad := 0
+	for e, n := range edges {
+		if n != 1 || edges[[2]int{e[1], e[0]}] != 1 {
+			bad++
+		}
+	}
+	assert.Zero(t, bad, "edges not shared by exactly two triangles")
+	return len(m.Vertices) - len(edges)/2 + len(m.Triangles)
+}
+
+func TestTubeMesh(t *testing.T) {
+	m := TubeMesh(Tree3dSegments(NewCreature(NewTree3dSpecies())), 8)
+	assert.Len(t, m.Triangles, 40*4*8)
+	assert_manifold(t, m)
+	single := TubeMesh([]Segment3{{Vec3{}, Vec3{0, 10, 0}, 1}}, 64)
+	assert.InDelta(t, math.Pi*10, single.Volume(), 0.1)
+}
+
+func TestSolidMesh(t *testing.T) {
+	capsule := SolidMesh([]Segment3{{Vec3{}, Vec3{0, 10, 0}, 2}}, 48)
+	assert.Equal(t, 2, assert_manifold(t, capsule), "a capsule is a sphere")
+	assert.InEpsilon(t, math.Pi*4*10+math.Pi*4/3*8, capsule.Volume(), 0.03)
+
+	// Two crossing branches make one surface, without the overlap inside.
+	cross := SolidMesh([]Segment3{{Vec3{-10, 0, 0}, Vec3{10, 0, 0}, 2}, {Vec3{0, -10, 0}, Vec3{0, 10, 0}, 2}}, 48)
+	assert.Equal(t, 2, assert_manifold(t, cross))
+	assert.True(t, cross.Volume() < 2*(math.Pi*4*20+math.Pi*4/3*8)*0.97)
+
+	// Branches thinner than a cell are thickened rather than lost.
+	thin := SolidMesh([]Segment3{{Vec3{}, Vec3{0, 100, 0}, 0.01}}, 20)
+	assert.Equal(t, 2, assert_manifold(t, thin))
+	assert.True(t, thin.Volume() > 0)
+
+	assert.Empty(t, SolidMesh(nil, 48).Triangles)
+
+	for _, s := range []*Species{NewTree3dSpecies(), NewTreeSpecies()} {
+		for seed := int64(0); seed < 3; seed++ {
+			m := CreatureMesh(RandomCreature(s, rand.New(rand.NewSource(seed))), 40)
+			assert.NotEmpty(t, m.Triangles)
+			assert_manifold(t, m)
+			assert.True(t, m.Volume() > 0, "facing out")
+		}
+	}
+}
+
+func TestMeshWrite(t *testing.T) {
+	m := SolidMesh([]Segment3{{Vec3{}, Vec3{0, 10, 0}, 2}}, 16)
+	var stl bytes.Buffer
+	assert.NoError(t, m.Write(&stl, "stl"))
+	assert.Equal(t, 84+50*len(m.Triangles), stl.Len())
+	assert.Equal(t, uint32(len(m.Triangles)), binary.LittleEndian.Uint32(stl.Bytes()[80:]))
+	var obj bytes.Buffer
+	assert.NoError(t, m.Write(&obj, "obj"))
+	assert.Equal(t, len(m.Vertices), strings.Count(obj.String(), "\nv "))
+	assert.Equal(t, len(m.Triangles), strings.Count(obj.String(), "\nf "))
+	var ply bytes.Buffer
+	assert.NoError(t, m.Write(&ply, "ply"))
+	header, body, ok := strings.Cut(ply.String(), "end_header\n")
+	assert.True(t, ok)
+	assert.Contains(t, header, "format binary_little_endian 1.0\n")
+	assert.Len(t, body, 12*len(m.Vertices)+13*len(m.Triangles))
+	assert.ErrorIs(t, m.Write(&ply, "3mf"), ErrMeshFormat)
+}
@@ -0,0 +1,216 @@
+package biomorph
+
+import "fmt"
+
+// MaxModuleCopies caps how many copies of a module a creature can carry. A
+// code counts them in one byte, so it must stay below 256.
+const MaxModuleCopies = 64
+
+// A Module is a group of genes a creature carries a variable number of
+// copies of, such as the segments of a body. Copy k of gene g of module m
+// is named "m.k.g". Mutation duplicates a copy with chance Duplicate,
+// deletes one with chance Delete and inserts a new one, with its genes'
+// initial values, with chance Insert, keeping between Min and Max copies.
+type Module struct {
+	Name      string
+	Genes     []*Gene
+	Min       int
+	Max       int
+	Initial   int
+	Duplicate float64
+	Delete    float64
+	Insert    float64
+	copies    [][]*Gene
+	owns      map[*Gene]bool
+}
+
+// NewModule makes a module of genes whose creatures start with initial
+// copies. The genes are templates for each copy's. Copy counts above
+// MaxModuleCopies are lowered to it, so every creature can be encoded.
+func NewModule(name string, genes []*Gene, min int, max int, initial int) *Module {
+	if max > MaxModuleCopies {
+		max = MaxModuleCopies
+	}
+	if min > max {
+		min = max
+	}
+	if initial > max {
+		initial = max
+	}
+	m := &Module{Name: name, Genes: genes, Min: min, Max: max, Initial: initial, owns: map[*Gene]bool{}}
+	for k := 0; k < max; k++ {
+		copy_genes := make([]*Gene, len(genes))
+		for j, g := range genes {
+			copy_genes[j] = &Gene{g.Range, fmt.Sprintf("%s.%d.%s", name, k, g.Name), g.Type, g.Default}
+			m.owns[copy_genes[j]] = true
+		}
+		m.copies = append(m.copies, copy_genes)
+	}
+	return m
+}
+
+// Copy returns the genes of copy k, or nil if k is out of range.
+func (m *Module) Copy(k int) []*Gene {
+	if k < 0 || k >= len(m.copies) {
+		return nil
+	}
+	return m.copies[k]
+}
+
+// Module looks up a module by name, returning nil if the species lacks it.
+func (s *Species) Module(name string) *Module {
+	for _, m := range s.Modules {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Counts is how many copies of each of its species' modules c carries.
+func (c *Creature) Counts() []int {
+	counts := make([]int, len(c.CreatureSpecies.Modules))
+	pos := len(c.CreatureSpecies.Genes)
+	for i, m := range c.CreatureSpecies.Modules {
+		for pos < len(c.Values) && m.owns[c.Values[pos].Gene] {
+			pos += len(m.Genes)
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// Copies groups the values of c's copies of m, in order.
+func (c *Creature) Copies(m *Module) [][]*GeneValue {
+	var copies [][]*GeneValue
+	for pos := len(c.CreatureSpecies.Genes); pos < len(c.Values); pos++ {
+		if m.owns[c.Values[pos].Gene] {
+			copies = append(copies, c.Values[pos:pos+len(m.Genes)])
+			pos += len(m.Genes) - 1
+		}
+	}
+	return copies
+}
+
+// NewCreatureCounts makes a creature of species carrying counts[i] copies
+// of module i, with every gene at its initial value.
+func NewCreatureCounts(species *Species, counts []int) *Creature {
+	c := &Creature{species, make([]*GeneValue, 0, len(species.Genes))}
+	for _, gene := range species.Genes {
+		c.Values = append(c.Values, &GeneValue{gene, gene.Initial()})
+	}
+	for i, m := range species.Modules {
+		for k := 0; k < counts[i]; k++ {
+			for _, gene := range m.copies[k] {
+				c.Values = append(c.Values, &GeneValue{gene, gene.Initial()})
+			}
+		}
+	}
+	return c
+}
+
+// layout is a creature's values split into its fixed genes' and, for each
+// module, its copies'. Structural changes edit the layout and join it back
+// into a creature, renaming the copies by their new positions.
+type layout struct {
+	fixed   []float64
+	modules [][][]float64
+}
+
+func (c *Creature) layout() *layout {
+	l := &layout{make([]float64, len(c.CreatureSpecies.Genes)), make([][][]float64, len(c.CreatureSpecies.Modules))}
+	for i := range l.fixed {
+		l.fixed[i] = c.Values[i].Value
+	}
+	for i, m := range c.CreatureSpecies.Modules {
+		for _, copy_values := range c.Copies(m) {
+			values := make([]float64, len(copy_values))
+			for j, v := range copy_values {
+				values[j] = v.Value
+			}
+			l.modules[i] = append(l.modules[i], values)
+		}
+	}
+	return l
+}
+
+func (l *layout) creature(species *Species) *Creature {
+	counts := make([]int, len(species.Modules))
+	for i := range counts {
+		counts[i] = len(l.modules[i])
+	}
+	c := NewCreatureCounts(species, counts)
+	pos := 0
+	for _, v := range l.fixed {
+		c.Values[pos].Value = v
+		pos++
+	}
+	for _, copies := range l.modules {
+		for _, values := range copies {
+			for _, v := range values {
+				c.Values[pos].Value = v
+				pos++
+			}
+		}
+	}
+	return c
+}
+
+// pick chooses an index below n.
+func pick(r Rand, n int) int {
+	k := int(r.Float64() * float64(n))
+	if k >= n {
+		k = n - 1
+	}
+	return k
+}
+
+// mutate_structure applies m's structural mutations to copies.
+func (m *Module) mutate_structure(copies [][]float64, r Rand) [][]float64 {
+	if r.Float64() < m.Duplicate && len(copies) < m.Max && len(copies) > 0 {
+		k := pick(r, len(copies))
+		dup := append([]float64{}, copies[k]...)
+		copies = append(copies[:k+1], append([][]float64{dup}, copies[k+1:]...)...)
+	}
+	if r.Float64() < m.Delete && len(copies) > m.Min {
+		k := pick(r, len(copies))
+		copies = append(copies[:k], copies[k+1:]...)
+	}
+	if r.Float64() < m.Insert && len(copies) < m.Max {
+		fresh := make([]float64, len(m.Genes))
+		for j, g := range m.Genes {
+			fresh[j] = g.Initial()
+		}
+		k := pick(r, len(copies)+1)
+		copies = append(copies[:k], append([][]float64{fresh}, copies[k:]...)...)
+	}
+	return copies
+}
+
+// cross_copies breeds two parents' copies of a module. The child takes its
+// number of copies from either parent, and each gene of a copy both parents
+// have from either of them; copies only one parent has are inherited whole.
+func cross_copies(a [][]float64, b [][]float64, r Rand) [][]float64 {
+	n := len(a)
+	if r.Float64() < 0.5 {
+		n = len(b)
+	}
+	child := make([][]float64, n)
+	for k := range child {
+		switch {
+		case k >= len(a):
+			child[k] = append([]float64{}, b[k]...)
+		case k >= len(b):
+			child[k] = append([]float64{}, a[k]...)
+		default:
+			child[k] = make([]float64, len(a[k]))
+			for j := range a[k] {
+				child[k][j] = a[k][j]
+				if r.Float64() < 0.5 {
+					child[k][j] = b[k][j]
+				}
+			}
+		}
+	}
+	return child
+}
@@ -0,0 +1,167 @@
+package biomorph
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const worm_yaml = `name: worm
+renderer: segmented
+modules:
+  - name: segment
+    min: 1
+    max: 6
+    initial: 3
+    duplicate: 0.5
+    delete: 0.5
+    insert: 0.2
+    genes:
+      - {name: length, min: 2, max: 10}
+      - {name: bend, min: -0.5, max: 0.5}
+      - {name: limb_length, min: 0, max: 10}
+      - {name: limb_angle, min: 0.1, max: 2}
+`
+
+func worm_species(t *testing.T) *Species {
+	sc, err := ParseSpeciesConfig("worm.yaml", []byte(worm_yaml))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s, err := sc.Species()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return s
+}
+
+func TestModuleMutation(t *testing.T) {
+	s := worm_species(t)
+	c := NewCreature(s)
+	assert.Equal(t, []int{3}, c.Counts())
+	assert.Len(t, c.Values, 12)
+	assert.Equal(t, 6.0, c.GetValue("segment.2.length"))
+	assert.Len(t, CreatureSegments(c), 9)
+
+	r := rand.New(rand.NewSource(1))
+	seen := map[int]bool{}
+	for i := 0; i < 200; i++ {
+		c = MutateCreatureRand(c, r)
+		n := c.Counts()[0]
+		assert.True(t, n >= 1 && n <= 6, n)
+		assert.Len(t, c.Values, 4*n)
+		assert.Len(t, CreatureSegments(c), 3*n)
+		seen[n] = true
+	}
+	assert.Len(t, seen, 6)
+}
+
+func TestModuleBreed(t *testing.T) {
+	s := worm_species(t)
+	a := NewCreatureCounts(s, []int{1})
+	b := NewCreatureCounts(s, []int{5})
+	for _, v := range b.Values {
+		v.Value = v.Gene.Range.Max
+	}
+	r := rand.New(rand.NewSource(1))
+	counts := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		child, err := BreedCreaturesRand(a, b, r)
+		assert.NoError(t, err)
+		n := child.Counts()[0]
+		counts[n] = true
+		copies := child.Copies(s.Module("segment"))
+		assert.Len(t, copies, n)
+		for k := 1; k < n; k++ {
+			for _, v := range copies[k] {
+				assert.Equal(t, v.Gene.Range.Max, v.Value)
+			}
+		}
+	}
+	assert.Equal(t, map[int]bool{1: true, 5: true}, counts)
+
+	// Parents must share the species' modules as well as its name.
+	sc, err := ParseSpeciesConfig("worm.yaml", []byte(strings.Replace(worm_yaml, "max: 10}", "max: 12}", 1)))
+	assert.NoError(t, err)
+	longer, err := sc.Species()
+	assert.NoError(t, err)
+	for _, other := range []*Creature{NewCreature(longer), NewCreature(NewTreeSpecies())} {
+		_, err = BreedCreaturesRand(a, other, r)
+		assert.ErrorIs(t, err, ErrSpeciesMismatch)
+		_, err = BreedCreaturesRand(other, b, r)
+		assert.ErrorIs(t, err, ErrSpeciesMismatch)
+	}
+}
+
+func TestModuleStorage(t *testing.T) {
+	s := worm_species(t)
+	SetConfiguredSpecies([]*Species{s})
+	defer SetConfiguredSpecies(nil)
+	r := rand.New(rand.NewSource(2))
+	root := NewCreatureCounts(s, []int{1})
+	c := RandomCreature(s, r)
+	for c.Counts()[0] == 1 {
+		c = RandomCreature(s, r)
+	}
+
+	assert.Equal(t, c.ValuesMap(), s.LoadCreature(c.ValuesMap()).ValuesMap())
+
+	var buff bytes.Buffer
+	assert.NoError(t, NewGenome(c, []*Creature{root}).WriteText(&buff))
+	g, err := ReadGenome(&buff)
+	assert.NoError(t, err)
+	got, lineage, err := g.Creatures()
+	assert.NoError(t, err)
+	assert.Equal(t, c.ValuesMap(), got.ValuesMap())
+	assert.Equal(t, root.ValuesMap(), lineage[0].ValuesMap())
+
+	g.Lineage[0][len(s.Genes)] = 2
+	assert.ErrorIs(t, g.Validate(), ErrInvalidGenome)
+	g = NewGenome(c, nil)
+	g.Genes = g.Genes[4:]
+	assert.ErrorIs(t, g.Validate(), ErrInvalidGenome)
+
+	decoded, err := DecodeCode(EncodeCode(c))
+	assert.NoError(t, err)
+	assert.Equal(t, c.Counts(), decoded.Counts())
+
+	frames := Morph([]*Creature{root, c}, 3, Spline)
+	assert.Equal(t, []int{1}, frames[1].Counts())
+	assert.Equal(t, c.Counts(), frames[3].Counts())
+}
+
+func TestModuleCopiesLimit(t *testing.T) {
+	genes := []*Gene{{GeneRange{2, 10}, "length", GeneFloat, nil}, {GeneRange{-0.5, 0.5}, "bend", GeneFloat, nil}, {GeneRange{0, 10}, "limb_length", GeneFloat, nil}, {GeneRange{0.1, 2}, "limb_angle", GeneFloat, nil}}
+	m := NewModule("segment", genes, 300, 300, 300)
+	assert.Equal(t, []int{MaxModuleCopies, MaxModuleCopies, MaxModuleCopies}, []int{m.Min, m.Max, m.Initial})
+	s := &Species{Name: "millipede", Modules: []*Module{m}, Renderer: "segmented", Mutation: DefaultMutation}
+	c := NewCreature(s)
+	assert.Equal(t, []int{MaxModuleCopies}, c.Counts())
+	SetConfiguredSpecies([]*Species{s})
+	defer SetConfiguredSpecies(nil)
+	decoded, err := DecodeCode(EncodeCode(c))
+	assert.NoError(t, err)
+	assert.Equal(t, c.Counts(), decoded.Counts())
+}
+
+func TestModuleConfigErrors(t *testing.T) {
+	for want, body := range map[string]string{
+		"1 <= max <= 64":                        "name: worm\nrenderer: segmented\nmodules:\n  - {name: segment, min: 1, max: 300, genes: [{name: length, min: 0, max: 1}]}\n",
+		"copies [4, 2]":                         "name: worm\nrenderer: segmented\nmodules:\n  - {name: segment, min: 4, max: 2, genes: [{name: length, min: 0, max: 1}]}\n",
+		"initial copies 9":                      "name: worm\nrenderer: segmented\nmodules:\n  - {name: segment, min: 1, max: 2, initial: 9, genes: [{name: length, min: 0, max: 1}]}\n",
+		"module 1 (segment): gene 1 (length)":   "name: worm\nrenderer: segmented\nmodules:\n  - {name: segment, min: 1, max: 2, genes: [{name: length, min: 1, max: 0}]}\n",
+		"needs gene \"bend\" in module segment": "name: worm\nrenderer: segmented\nmodules:\n  - {name: segment, min: 1, max: 2, genes: [{name: length, min: 0, max: 1}]}\n",
+		"renderer tree doesn't draw module":     fern_yaml + "modules:\n  - {name: segment, min: 1, max: 2, genes: [{name: length, min: 0, max: 1}]}\n",
+	} {
+		sc, err := ParseSpeciesConfig("worm.yaml", []byte(body))
+		if assert.NoError(t, err, want) {
+			_, err = sc.Species()
+			if assert.Error(t, err, want) {
+				assert.Contains(t, err.Error(), want)
+			}
+		}
+	}
+}
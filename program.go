@@ -0,0 +1,551 @@
+package biomorph
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Program is a species' drawing written in a small turtle language, so a
+// new morph type needs a definition file rather than Go. The turtle starts
+// at the bottom middle of an ImageSize square, heading up:
+//
+//	# Comments run to the end of the line.
+//	def main() {
+//	  tree(num_gens, branch_length, branch_angle)
+//	}
+//	def tree(n, len, angle) {
+//	  if n < 1 { return }
+//	  forward(len)
+//	  let branches = floor(num_branches)
+//	  repeat(branches) {
+//	    [ turn(-angle/2 + angle*i/(branches-1)) tree(n-1, len=len*branch_increase, angle=angle*angle_increase) ]
+//	  }
+//	}
+//
+// forward(d) draws a line d long, move(d) moves without drawing and turn(a)
+// turns a radians to the left. Square brackets restore the turtle's position
+// and heading after their statements. repeat(n) runs its block floor(n)
+// times with i counting from 0. Procedures take arguments by position or by
+// name and let binds a local. Expressions may use the creature's genes by
+// name, the procedure's parameters and locals, depth (how deeply calls are
+// nested), pi, the operators + - * / % ^ < <= > >= == != && || ! and the
+// functions in program_functions.
+type Program struct {
+	Source string
+	procs  map[string]*procedure
+}
+
+type procedure struct {
+	name   string
+	params []string
+	body   []stmt
+}
+
+type token_kind int
+
+const (
+	tok_eof token_kind = iota
+	tok_ident
+	tok_number
+	tok_punct
+)
+
+type token struct {
+	kind      token_kind
+	text      string
+	line, col int
+}
+
+func (t token) String() string {
+	if t.kind == tok_eof {
+		return "end of program"
+	}
+	return strconv.Quote(t.text)
+}
+
+type program_error struct {
+	line, col int
+	msg       string
+}
+
+func (e *program_error) Error() string {
+	return fmt.Sprintf("line %d:%d: %s", e.line, e.col, e.msg)
+}
+
+var two_char_puncts = []string{"<=", ">=", "==", "!=", "&&", "||"}
+
+func lex_program(src string) ([]token, error) {
+	var tokens []token
+	line, col := 1, 1
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start_line, start_col := line, col
+		advance := func(n int) {
+			for ; n > 0; n-- {
+				if runes[i] == '\n' {
+					line, col = line+1, 1
+				} else {
+					col++
+				}
+				i++
+			}
+		}
+		switch {
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				advance(1)
+			}
+		case unicode.IsSpace(r) || r == ';':
+			advance(1)
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tok_ident, string(runes[i:j]), start_line, start_col})
+			advance(j - i)
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			if j < len(runes) && (runes[j] == 'e' || runes[j] == 'E') {
+				j++
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				for j < len(runes) && unicode.IsDigit(runes[j]) {
+					j++
+				}
+			}
+			text := string(runes[i:j])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, &program_error{line, col, fmt.Sprintf("bad number %q", text)}
+			}
+			tokens = append(tokens, token{tok_number, text, start_line, start_col})
+			advance(j - i)
+		default:
+			text := string(r)
+			if i+1 < len(runes) {
+				for _, p := range two_char_puncts {
+					if string(runes[i:i+2]) == p {
+						text = p
+					}
+				}
+			}
+			if len(text) == 1 && !strings.ContainsRune("(){}[],=+-*/%^<>!", r) {
+				return nil, &program_error{line, col, fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, token{tok_punct, text, start_line, start_col})
+			advance(len([]rune(text)))
+		}
+	}
+	return append(tokens, token{tok_eof, "", line, col}), nil
+}
+
+var program_keywords = map[string]bool{
+	"def": true, "repeat": true, "if": true, "else": true, "let": true, "return": true,
+	"forward": true, "move": true, "turn": true, "depth": true, "pi": true, "i": true,
+}
+
+// program_functions are the functions expressions may call, by arity.
+var program_functions = map[string]struct {
+	arity int
+	f     func(args []float64) float64
+}{
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"tan":   {1, func(a []float64) float64 { return math.Tan(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"round": {1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+	"clamp": {3, func(a []float64) float64 { return math.Max(a[1], math.Min(a[2], a[0])) }},
+}
+
+type program_parser struct {
+	tokens []token
+	pos    int
+	genes  map[string]bool
+	procs  map[string]*procedure
+	calls  []*call_stmt
+	// scope holds the names visible in the procedure being parsed.
+	scope map[string]bool
+}
+
+func (p *program_parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *program_parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tok_eof {
+		p.pos++
+	}
+	return t
+}
+
+func (p *program_parser) errorf(t token, format string, args ...interface{}) error {
+	return &program_error{t.line, t.col, fmt.Sprintf(format, args...)}
+}
+
+func (p *program_parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tok_punct || t.kind == tok_ident) && t.text == text
+}
+
+func (p *program_parser) expect(text string) error {
+	if t := p.next(); t.text != text || t.kind == tok_number {
+		return p.errorf(t, "expected %q, found %v", text, t)
+	}
+	return nil
+}
+
+func (p *program_parser) ident() (token, error) {
+	t := p.next()
+	if t.kind != tok_ident {
+		return t, p.errorf(t, "expected a name, found %v", t)
+	}
+	return t, nil
+}
+
+// CompileProgram parses src, checking every name it uses is a parameter,
+// local, built-in or one of genes. It must define a procedure main with no
+// parameters.
+func CompileProgram(src string, genes []string) (*Program, error) {
+	tokens, err := lex_program(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &program_parser{tokens: tokens, genes: map[string]bool{}, procs: map[string]*procedure{}}
+	for _, g := range genes {
+		p.genes[g] = true
+	}
+	for p.peek().kind != tok_eof {
+		if err := p.parse_def(); err != nil {
+			return nil, err
+		}
+	}
+	main, ok := p.procs["main"]
+	if !ok {
+		return nil, &program_error{1, 1, "no main procedure"}
+	}
+	if len(main.params) != 0 {
+		return nil, &program_error{1, 1, "main must not take parameters"}
+	}
+	for _, c := range p.calls {
+		if err := c.resolve(p); err != nil {
+			return nil, err
+		}
+	}
+	return &Program{src, p.procs}, nil
+}
+
+func (p *program_parser) parse_def() error {
+	if t := p.peek(); t.text != "def" || t.kind != tok_ident {
+		return p.errorf(t, "expected \"def\", found %v", t)
+	}
+	p.next()
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if p.reserved(name.text) {
+		return p.errorf(name, "%q is reserved", name.text)
+	}
+	if _, ok := p.procs[name.text]; ok {
+		return p.errorf(name, "procedure %s is defined twice", name.text)
+	}
+	proc := &procedure{name: name.text}
+	p.procs[name.text] = proc
+	p.scope = map[string]bool{}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.is(")") {
+		if len(proc.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		param, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if err := p.declare(param); err != nil {
+			return err
+		}
+		proc.params = append(proc.params, param.text)
+	}
+	p.next()
+	proc.body, err = p.parse_block("{", "}", false)
+	return err
+}
+
+// reserved names can't be procedures, parameters or locals.
+func (p *program_parser) reserved(name string) bool {
+	_, fn := program_functions[name]
+	return fn || program_keywords[name] || p.genes[name]
+}
+
+func (p *program_parser) declare(name token) error {
+	if p.reserved(name.text) {
+		return p.errorf(name, "%q is reserved", name.text)
+	}
+	if p.scope[name.text] {
+		return p.errorf(name, "%q is already defined", name.text)
+	}
+	p.scope[name.text] = true
+	return nil
+}
+
+func (p *program_parser) parse_block(open string, close string, in_repeat bool) ([]stmt, error) {
+	if err := p.expect(open); err != nil {
+		return nil, err
+	}
+	var body []stmt
+	for !p.is(close) {
+		if p.peek().kind == tok_eof {
+			return nil, p.errorf(p.peek(), "expected %q, found %v", close, p.peek())
+		}
+		s, err := p.parse_stmt(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+	p.next()
+	return body, nil
+}
+
+func (p *program_parser) parse_stmt(in_repeat bool) (stmt, error) {
+	if p.is("[") {
+		body, err := p.parse_block("[", "]", in_repeat)
+		return &branch_stmt{body}, err
+	}
+	t, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	switch t.text {
+	case "forward", "move", "turn":
+		x, err := p.parse_args1(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		return &turtle_stmt{t.text, x, t}, nil
+	case "repeat":
+		x, err := p.parse_args1(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parse_block("{", "}", true)
+		return &repeat_stmt{x, body, t}, err
+	case "if":
+		return p.parse_if(in_repeat)
+	case "let":
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		x, err := p.parse_expr(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.declare(name); err != nil {
+			return nil, err
+		}
+		return &let_stmt{name.text, x}, nil
+	case "return":
+		return return_stmt{}, nil
+	case "else":
+		return nil, p.errorf(t, "else without if")
+	}
+	if p.reserved(t.text) || p.scope[t.text] {
+		return nil, p.errorf(t, "%q is not a command", t.text)
+	}
+	c := &call_stmt{name: t}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.is(")") {
+		if len(c.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg := call_arg{}
+		if p.peek().kind == tok_ident && p.tokens[p.pos+1].text == "=" {
+			arg.name = p.next()
+			p.next()
+		}
+		if arg.x, err = p.parse_expr(in_repeat); err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+	}
+	p.next()
+	p.calls = append(p.calls, c)
+	return c, nil
+}
+
+func (p *program_parser) parse_if(in_repeat bool) (stmt, error) {
+	cond, err := p.parse_expr(in_repeat)
+	if err != nil {
+		return nil, err
+	}
+	s := &if_stmt{cond: cond}
+	if s.then, err = p.parse_block("{", "}", in_repeat); err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tok_ident && p.peek().text == "else" {
+		p.next()
+		if p.peek().kind == tok_ident && p.peek().text == "if" {
+			p.next()
+			elif, err := p.parse_if(in_repeat)
+			if err != nil {
+				return nil, err
+			}
+			s.otherwise = []stmt{elif}
+		} else if s.otherwise, err = p.parse_block("{", "}", in_repeat); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *program_parser) parse_args1(in_repeat bool) (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	x, err := p.parse_expr(in_repeat)
+	if err != nil {
+		return nil, err
+	}
+	return x, p.expect(")")
+}
+
+var binary_precedence = map[string]int{
+	"||": 1, "&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+func (p *program_parser) parse_expr(in_repeat bool) (expr, error) {
+	return p.parse_binary(1, in_repeat)
+}
+
+func (p *program_parser) parse_binary(min_prec int, in_repeat bool) (expr, error) {
+	x, err := p.parse_unary(in_repeat)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := binary_precedence[t.text]
+		if t.kind != tok_punct || !ok || prec < min_prec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parse_binary(prec+1, in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		x = &binary_expr{t.text, x, y}
+	}
+}
+
+func (p *program_parser) parse_unary(in_repeat bool) (expr, error) {
+	if t := p.peek(); t.kind == tok_punct && (t.text == "-" || t.text == "!" || t.text == "+") {
+		p.next()
+		x, err := p.parse_unary(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		return &unary_expr{t.text, x}, nil
+	}
+	return p.parse_power(in_repeat)
+}
+
+func (p *program_parser) parse_power(in_repeat bool) (expr, error) {
+	x, err := p.parse_primary(in_repeat)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tok_punct && t.text == "^" {
+		p.next()
+		// Right associative, and binds tighter than a unary minus on its left.
+		y, err := p.parse_unary(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		return &binary_expr{"^", x, y}, nil
+	}
+	return x, nil
+}
+
+func (p *program_parser) parse_primary(in_repeat bool) (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tok_number:
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return number_expr(v), nil
+	case t.kind == tok_punct && t.text == "(":
+		x, err := p.parse_expr(in_repeat)
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case t.kind != tok_ident:
+		return nil, p.errorf(t, "expected an expression, found %v", t)
+	}
+	if fn, ok := program_functions[t.text]; ok {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		c := &func_expr{f: fn.f}
+		for !p.is(")") {
+			if len(c.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			x, err := p.parse_expr(in_repeat)
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, x)
+		}
+		p.next()
+		if len(c.args) != fn.arity {
+			return nil, p.errorf(t, "%s takes %d arguments, not %d", t.text, fn.arity, len(c.args))
+		}
+		return c, nil
+	}
+	switch {
+	case t.text == "pi":
+		return number_expr(math.Pi), nil
+	case t.text == "depth":
+		return depth_expr{}, nil
+	case t.text == "i" && in_repeat:
+		return var_expr("i"), nil
+	case p.scope[t.text]:
+		return var_expr(t.text), nil
+	case p.genes[t.text]:
+		return gene_expr(t.text), nil
+	case t.text == "i":
+		return nil, p.errorf(t, "i is only defined inside repeat")
+	}
+	return nil, p.errorf(t, "unknown name %q", t.text)
+}
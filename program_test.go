@@ -0,0 +1,166 @@
+package biomorph
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tree_program draws like TreeSegments when the noise genes are zero.
+const tree_program = `
+def main() {
+  tree(num_gens, branch_length, branch_angle)
+}
+def tree(n, len, angle) {
+  if n < 1 { return }
+  forward(len)
+  let branches = floor(num_branches)
+  repeat(branches) {
+    [ turn(-angle/2 + angle*i/(branches-1)) tree(n-1, len=len*branch_increase, angle=angle*angle_increase) ]
+  }
+}
+`
+
+func gene_names(s *Species) []string {
+	var names []string
+	for _, g := range s.Genes {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+func TestProgramMatchesTree(t *testing.T) {
+	c := NewCreature(NewTreeSpecies())
+	c.GetGeneValue("num_gens").Value = 4
+	c.GetGeneValue("num_branches").Value = 3
+	p, err := CompileProgram(tree_program, gene_names(c.CreatureSpecies))
+	assert.NoError(t, err)
+	got, err := p.Run(c, DefaultProgramLimits)
+	assert.NoError(t, err)
+	want := TreeSegments(c)
+	assert.Len(t, got, len(want))
+	for i := range want {
+		assert.InDelta(t, want[i].X2, got[i].X2, 1e-9)
+		assert.InDelta(t, want[i].Y2, got[i].Y2, 1e-9)
+	}
+}
+
+func run_length(t *testing.T, x string) float64 {
+	p, err := CompileProgram("def main() { forward("+x+") }", gene_names(NewTreeSpecies()))
+	if !assert.NoError(t, err, x) {
+		return math.NaN()
+	}
+	segments, err := p.Run(NewCreature(NewTreeSpecies()), DefaultProgramLimits)
+	assert.NoError(t, err, x)
+	return segments[0].Y1 - segments[0].Y2
+}
+
+func TestProgramExpressions(t *testing.T) {
+	for x, want := range map[string]float64{
+		"1 + 2 * 3":           7,
+		"(1 + 2) * 3":         9,
+		"-2^2":                -4,
+		"2^3^2":               512,
+		"2^-1":                0.5,
+		"7 % 4":               3,
+		"1 < 2 && 2 < 1 || 1": 1,
+		"!0 + (3 >= 3)":       2,
+		"max(1, min(5, 3))":   3,
+		"clamp(9, 0, 4)":      4,
+		"num_gens + depth":    3.5,
+		"round(cos(pi))":      -1,
+		"1e1 + .5":            10.5,
+	} {
+		assert.InDelta(t, want, run_length(t, x), 1e-9, x)
+	}
+}
+
+func TestProgramErrors(t *testing.T) {
+	genes := gene_names(NewTreeSpecies())
+	for src, want := range map[string]string{
+		"def main() { forward(lenght) }":           "line 1:22: unknown name \"lenght\"",
+		"def main() { forward(i) }":                "i is only defined inside repeat",
+		"def main() { grow() }":                    "unknown procedure \"grow\"",
+		"def main() { f(1) }\ndef f(a, b) {}":      "f takes 2 arguments, not 1",
+		"def main() { f(c=1) }\ndef f(a) {}":       "f has no parameter \"c\"",
+		"def main() { f(a=1, 2) }\ndef f(a, b) {}": "positional argument after a named one",
+		"def tree() {}":                            "no main procedure",
+		"def main(x) {}":                           "main must not take parameters",
+		"def main() {}\ndef num_gens() {}":         "line 2:5: \"num_gens\" is reserved",
+		"def main() { let sin = 1 }":               "\"sin\" is reserved",
+		"def main() { let a = 1; let a = 2 }":      "\"a\" is already defined",
+		"def main() { forward(1) $ }":              "unexpected character '$'",
+		"def main() { forward(1)":                  "expected \"}\", found end of program",
+		"def main() { forward(max(1)) }":           "max takes 2 arguments, not 1",
+		"def main() { else {} }":                   "else without if",
+		"def main() { num_gens(1) }":               "\"num_gens\" is not a command",
+		"def main() {}\ndef main() {}":             "procedure main is defined twice",
+	} {
+		_, err := CompileProgram(src, genes)
+		if assert.Error(t, err, src) {
+			assert.Contains(t, err.Error(), want, src)
+		}
+	}
+}
+
+func TestProgramLimits(t *testing.T) {
+	c := NewCreature(NewTreeSpecies())
+	limits := ProgramLimits{MaxDepth: 10, MaxSegments: 50, MaxSteps: 1000}
+	for src, want := range map[string]string{
+		"def main() { f() }\ndef f() { forward(1) f() }":          "calls nested deeper than 10",
+		"def main() { repeat(100) { forward(1) } }":               "drew more than 50 segments",
+		"def main() { repeat(1e12) { turn(1) } }":                 "ran more than 1000 statements",
+		"def main() { repeat(1e15) {} }":                          "ran more than 1000 statements",
+		"def main() { repeat(1e12) { repeat(0) {} } }":            "ran more than 1000 statements",
+		"def main() { f(0) }\ndef f(n) { [ f(n+1) ] [ f(n+1) ] }": "calls nested deeper than 10",
+	} {
+		p, err := CompileProgram(src, gene_names(c.CreatureSpecies))
+		assert.NoError(t, err, src)
+		segments, err := p.Run(c, limits)
+		assert.ErrorIs(t, err, ErrProgramLimit, src)
+		assert.Contains(t, err.Error(), want, src)
+		assert.LessOrEqual(t, len(segments), limits.MaxSegments)
+	}
+	p, err := CompileProgram("def main() { forward(1 / 0) }", nil)
+	assert.NoError(t, err)
+	_, err = p.Run(c, limits)
+	assert.ErrorContains(t, err, "line 1:14: forward(+Inf)")
+	for src, want := range map[string]string{
+		"def main() { repeat(1 / 0) {} }":   "line 1:14: repeat(+Inf)",
+		"def main() { repeat(0 / 0) {} }":   "line 1:14: repeat(NaN)",
+		"def main() { repeat(-1 / 0) { } }": "line 1:14: repeat(-Inf)",
+	} {
+		p, err = CompileProgram(src, nil)
+		assert.NoError(t, err, src)
+		_, err = p.Run(c, limits)
+		assert.ErrorContains(t, err, want, src)
+	}
+}
+
+func TestProgramSpecies(t *testing.T) {
+	sc, err := ParseSpeciesConfig("spiral.yaml", []byte(`name: spiral
+program: |
+  def main() {
+    repeat(turns * 12) { forward(step * (1 + i / 10)) turn(pi / 6) }
+  }
+genes:
+  - {name: turns, min: 1, max: 5, type: int}
+  - {name: step, min: 1, max: 4}
+`))
+	assert.NoError(t, err)
+	s, err := sc.Species()
+	assert.NoError(t, err)
+	assert.Equal(t, "program", s.Renderer)
+	c := NewCreature(s)
+	assert.Len(t, CreatureSegments(c), 36)
+	assert.NotNil(t, DrawCreature(c))
+
+	sc.Program = strings.Replace(sc.Program, "step", "stride", 1)
+	_, err = sc.Species()
+	assert.ErrorContains(t, err, "species spiral: program line 2:")
+	sc.Renderer = "tree"
+	_, err = sc.Species()
+	assert.ErrorContains(t, err, "can't be used with renderer tree")
+}
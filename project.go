@@ -0,0 +1,174 @@
+package biomorph
+
+import (
+	"context"
+	"image"
+	"math"
+	"sort"
+)
+
+// Camera views 3D creatures. It circles the vertical axis through the root
+// by Azimuth and looks down on the creature from Elevation, both in
+// radians. Distance 0 projects orthographically; otherwise the camera is in
+// perspective, that far from the root in ImageSize units. The zero Camera
+// looks at the creature side on, as 2D creatures are drawn.
+type Camera struct {
+	Azimuth   float64
+	Elevation float64
+	Distance  float64
+}
+
+// view turns p into camera space: x to the right, y up and z towards the
+// camera.
+func (cam Camera) view(p Vec3) Vec3 {
+	ca, sa := math.Cos(cam.Azimuth), math.Sin(cam.Azimuth)
+	x, z := p.X*ca-p.Z*sa, p.X*sa+p.Z*ca
+	ce, se := math.Cos(cam.Elevation), math.Sin(cam.Elevation)
+	return Vec3{x, p.Y*ce - z*se, p.Y*se + z*ce}
+}
+
+// perspective is how much nearer things at depth z look, 1 for an
+// orthographic camera. It is 0 at or behind the camera.
+func (cam Camera) perspective(z float64) float64 {
+	if cam.Distance <= 0 {
+		return 1
+	}
+	if z >= cam.Distance {
+		return 0
+	}
+	return cam.Distance / (cam.Distance - z)
+}
+
+// fit is the shrink that keeps segments in the image from any azimuth, so
+// a turntable doesn't change size as it turns.
+func (cam Camera) fit(segments []Segment3) float64 {
+	const margin = 2
+	reach, height := 0.0, 0.0
+	for _, s := range segments {
+		for _, p := range []Vec3{s.A, s.B} {
+			reach = math.Max(reach, math.Hypot(p.X, p.Z)+s.Radius)
+			height = math.Max(height, p.Y+s.Radius)
+		}
+	}
+	se, ce := math.Abs(math.Sin(cam.Elevation)), math.Abs(math.Cos(cam.Elevation))
+	up, down := height*ce+reach*se, reach*se
+	if cam.Distance > 0 {
+		// The nearest point can be reach closer than the root.
+		f := cam.perspective(math.Min(reach, cam.Distance*0.9))
+		reach, up, down = reach*f, up*f, down*f
+	}
+	shrink := 1.0
+	for _, fit := range [][2]float64{{reach, ImageSize/2 - margin}, {up, ImageSize*9/10 - margin}, {down, ImageSize/10 - margin}} {
+		if fit[0] > fit[1] {
+			shrink = math.Min(shrink, fit[1]/fit[0])
+		}
+	}
+	return shrink
+}
+
+// projected is a segment on the image with its depth, nearer being larger,
+// and stroke width.
+type projected struct {
+	Segment
+	depth float64
+	width float64
+}
+
+func (cam Camera) project(segments []Segment3) []projected {
+	shrink := cam.fit(segments)
+	x0, y0 := float64(ImageSize)/2, float64(ImageSize)*9/10
+	var out []projected
+	for _, s := range segments {
+		a, b := cam.view(s.A), cam.view(s.B)
+		fa, fb := cam.perspective(a.Z), cam.perspective(b.Z)
+		if fa == 0 || fb == 0 {
+			continue
+		}
+		out = append(out, projected{
+			Segment{x0 + a.X*fa*shrink, y0 - a.Y*fa*shrink, x0 + b.X*fb*shrink, y0 - b.Y*fb*shrink},
+			(a.Z + b.Z) / 2,
+			s.Radius * (fa + fb) * shrink,
+		})
+	}
+	segments2 := make([]Segment, len(out))
+	for i, p := range out {
+		segments2[i] = p.Segment
+	}
+	fit_segments(segments2, x0, y0)
+	for i := range out {
+		out[i].Segment = segments2[i]
+	}
+	return out
+}
+
+// Project draws 3D segments flat as cam sees them, shrunk to fit the
+// image.
+func Project(segments []Segment3, cam Camera) []Segment {
+	p := cam.project(segments)
+	out := make([]Segment, len(p))
+	for i, s := range p {
+		out[i] = s.Segment
+	}
+	return out
+}
+
+// CreatureSegments3 lays out c in 3D. Creatures of 2D species lie in the
+// z = 0 plane as branches of radius 1.
+func CreatureSegments3(c *Creature) []Segment3 {
+	segments, _ := creature_segments3(context.Background(), c)
+	return segments
+}
+
+// creature_segments3 is CreatureSegments3 laying out flat creatures with
+// creature_segments.
+func creature_segments3(ctx context.Context, c *Creature) ([]Segment3, error) {
+	if r, ok := Renderers[c.CreatureSpecies.Renderer]; ok && r.Segments3 != nil {
+		return r.Segments3(c), nil
+	}
+	segments, err := creature_segments(ctx, c)
+	out := make([]Segment3, len(segments))
+	for i, s := range segments {
+		out[i] = Segment3{Vec3{s.X1 - ImageSize/2, ImageSize*9/10 - s.Y1, 0}, Vec3{s.X2 - ImageSize/2, ImageSize*9/10 - s.Y2, 0}, 1}
+	}
+	return out, err
+}
+
+// Is3d reports whether c's species is drawn from 3D geometry.
+func Is3d(c *Creature) bool {
+	r, ok := Renderers[c.CreatureSpecies.Renderer]
+	return ok && r.Segments3 != nil
+}
+
+// draw_segments3 draws segments as opts' camera sees them, far ones first
+// and lighter, so depth reads in a flat image.
+func draw_segments3(ctx context.Context, segments []Segment3, opts RenderOptions) (image.Image, error) {
+	p := opts.Camera.project(segments)
+	sort.SliceStable(p, func(i, j int) bool { return p[i].depth < p[j].depth })
+	near, far := math.Inf(-1), math.Inf(1)
+	for _, s := range p {
+		near, far = math.Max(near, s.depth), math.Min(far, s.depth)
+	}
+	strokes := make([]stroke, len(p))
+	for i, s := range p {
+		shade := 0.0
+		if near > far {
+			shade = 0.7 * (near - s.depth) / (near - far)
+		}
+		strokes[i] = stroke{[]point{{s.X1, s.Y1}, {s.X2, s.Y2}}, math.Max(1, s.width), shade}
+	}
+	return supersample(opts.Size, opts.Supersample, func(size int) (image.Image, error) {
+		return draw_strokes(ctx, strokes, size, opts)
+	})
+}
+
+// Turntable renders n views of c with opts, circling it once starting from
+// opts.Camera.
+func Turntable(c *Creature, opts RenderOptions, n int) []image.Image {
+	frames := make([]image.Image, n)
+	for i := range frames {
+		view := opts
+		view.Camera.Azimuth += 2 * math.Pi * float64(i) / float64(n)
+		frames[i] = Render(c, view)
+	}
+	return frames
+}
@@ -0,0 +1,444 @@
+package biomorph
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// MaxSupersample bounds RenderOptions.Supersample, which multiplies the
+// memory of a render by its square.
+const MaxSupersample = 4
+
+// LineCap is how strokes end.
+type LineCap int
+
+const (
+	CapRound LineCap = iota
+	CapButt
+	// CapSquare runs a stroke on past its ends by half its width.
+	CapSquare
+)
+
+var cap_names = []string{"round", "butt", "square"}
+
+func (c LineCap) String() string {
+	if c < 0 || int(c) >= len(cap_names) {
+		return fmt.Sprintf("LineCap(%d)", int(c))
+	}
+	return cap_names[c]
+}
+
+func ParseLineCap(name string) (LineCap, error) {
+	for i, n := range cap_names {
+		if name == n {
+			return LineCap(i), nil
+		}
+	}
+	if name == "" {
+		return CapRound, nil
+	}
+	return CapRound, fmt.Errorf("unknown line cap %q, want round, butt or square", name)
+}
+
+// LineJoin is how a stroke turns where a branch carries on from the one
+// before it.
+type LineJoin int
+
+const (
+	// JoinNone strokes every segment on its own, so corners are whatever the
+	// caps make of them.
+	JoinNone LineJoin = iota
+	JoinRound
+	JoinBevel
+)
+
+var join_names = []string{"none", "round", "bevel"}
+
+func (j LineJoin) String() string {
+	if j < 0 || int(j) >= len(join_names) {
+		return fmt.Sprintf("LineJoin(%d)", int(j))
+	}
+	return join_names[j]
+}
+
+func ParseLineJoin(name string) (LineJoin, error) {
+	for i, n := range join_names {
+		if name == n {
+			return LineJoin(i), nil
+		}
+	}
+	if name == "" {
+		return JoinNone, nil
+	}
+	return JoinNone, fmt.Errorf("unknown line join %q, want none, round or bevel", name)
+}
+
+// stroke is a polyline drawn in one go, in ImageSize units, gray from 0 for
+// black to 1 for white.
+type stroke struct {
+	points []point
+	width  float64
+	gray   float64
+}
+
+// segment_strokes strokes 2D segments in black. With a join, segments that
+// start where the one before ended are chained into one stroke.
+func segment_strokes(segments []Segment, join LineJoin) []stroke {
+	var strokes []stroke
+	for _, s := range segments {
+		a, b := point{s.X1, s.Y1}, point{s.X2, s.Y2}
+		if n := len(strokes); join != JoinNone && n > 0 && strokes[n-1].points[len(strokes[n-1].points)-1] == a {
+			strokes[n-1].points = append(strokes[n-1].points, b)
+			continue
+		}
+		strokes = append(strokes, stroke{[]point{a, b}, 2, 0})
+	}
+	return strokes
+}
+
+// check_every is how many strokes are drawn between looking at the
+// context.
+const check_every = 256
+
+// stopped is the error for stopping after drawing done of strokes, or nil
+// if ctx isn't done.
+func stopped(ctx context.Context, done int, strokes []stroke) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("drew %d of %d strokes: %w", done, len(strokes), err)
+	}
+	return nil
+}
+
+// draw_strokes draws strokes in order on a white size by size image, with
+// gg unless opts asks for the Gray16 rasterizer. If ctx is done first it
+// returns the strokes drawn so far and an error.
+func draw_strokes(ctx context.Context, strokes []stroke, size int, opts RenderOptions) (image.Image, error) {
+	scale := float64(size) / ImageSize
+	if opts.Gray || opts.Aliased {
+		img := image.NewGray16(image.Rect(0, 0, size, size))
+		for i := range img.Pix {
+			img.Pix[i] = 0xff
+		}
+		for i, s := range strokes {
+			if i%check_every == 0 {
+				if err := stopped(ctx, i, strokes); err != nil {
+					return img, err
+				}
+			}
+			rasterize(img, s, scale, opts.Cap, opts.Join, !opts.Aliased)
+		}
+		return img, nil
+	}
+	dc := gg.NewContext(size, size)
+	dc.SetColor(color.White)
+	dc.DrawRectangle(0, 0, float64(size), float64(size))
+	dc.Fill()
+	dc.Scale(scale, scale)
+	dc.SetLineCap(gg.LineCap(opts.Cap))
+	if opts.Join == JoinBevel {
+		dc.SetLineJoinBevel()
+	}
+	for i, s := range strokes {
+		if i%check_every == 0 {
+			if err := stopped(ctx, i, strokes); err != nil {
+				return dc.Image(), err
+			}
+		}
+		dc.SetRGB(s.gray, s.gray, s.gray)
+		dc.SetLineWidth(s.width * scale)
+		dc.MoveTo(s.points[0].x, s.points[0].y)
+		for _, p := range s.points[1:] {
+			dc.LineTo(p.x, p.y)
+		}
+		dc.Stroke()
+	}
+	return dc.Image(), nil
+}
+
+// supersample renders at n times size with draw and box filters it down,
+// so every pixel is the average of n by n samples. draw's error is passed
+// on with the image.
+func supersample(size int, n int, draw func(size int) (image.Image, error)) (image.Image, error) {
+	if n <= 1 {
+		return draw(size)
+	}
+	n = int(math.Min(float64(n), MaxSupersample))
+	big, err := draw(size * n)
+	area := uint32(n * n)
+	if g, ok := big.(*image.Gray16); ok {
+		small := image.NewGray16(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				var sum uint32
+				for j := 0; j < n; j++ {
+					for i := 0; i < n; i++ {
+						sum += uint32(g.Gray16At(x*n+i, y*n+j).Y)
+					}
+				}
+				small.SetGray16(x, y, color.Gray16{uint16(sum / area)})
+			}
+		}
+		return small, err
+	}
+	small := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var r, g, b, a uint32
+			for j := 0; j < n; j++ {
+				for i := 0; i < n; i++ {
+					pr, pg, pb, pa := big.At(x*n+i, y*n+j).RGBA()
+					r, g, b, a = r+pr, g+pg, b+pb, a+pa
+				}
+			}
+			small.Set(x, y, color.RGBA64{uint16(r / area), uint16(g / area), uint16(b / area), uint16(a / area)})
+		}
+	}
+	return small, err
+}
+
+// shape is part of a stroke on the pixel grid: distance is how far a point
+// is outside it, negative inside.
+type shape interface {
+	distance(x, y float64) float64
+	bounds() image.Rectangle
+}
+
+// capsule is the points within radius of the segment from a to b.
+type capsule struct {
+	a, b   point
+	radius float64
+}
+
+func (c capsule) distance(x, y float64) float64 {
+	dx, dy := c.b.x-c.a.x, c.b.y-c.a.y
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((x-c.a.x)*dx+(y-c.a.y)*dy)/l))
+	}
+	return math.Hypot(x-c.a.x-t*dx, y-c.a.y-t*dy) - c.radius
+}
+
+func (c capsule) bounds() image.Rectangle {
+	return bounds_of([]point{c.a, c.b}, c.radius)
+}
+
+// polygon is a convex polygon, its corners counter clockwise on the
+// image, where y points down.
+type polygon []point
+
+// distance is the furthest p is outside any edge. That is exact inside
+// and along edges, and a little short beyond corners, which only rounds
+// them by a fraction of a pixel.
+func (p polygon) distance(x, y float64) float64 {
+	d := math.Inf(-1)
+	for i, a := range p {
+		b := p[(i+1)%len(p)]
+		ex, ey := b.x-a.x, b.y-a.y
+		l := math.Hypot(ex, ey)
+		if l == 0 {
+			continue
+		}
+		d = math.Max(d, ((y-a.y)*ex-(x-a.x)*ey)/l)
+	}
+	return d
+}
+
+func (p polygon) bounds() image.Rectangle {
+	return bounds_of(p, 0)
+}
+
+func bounds_of(points []point, pad float64) image.Rectangle {
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		x0, y0 = math.Min(x0, p.x), math.Min(y0, p.y)
+		x1, y1 = math.Max(x1, p.x), math.Max(y1, p.y)
+	}
+	// A pixel more either way covers the antialiased fringe.
+	pad++
+	return image.Rect(int(math.Floor(x0-pad)), int(math.Floor(y0-pad)), int(math.Ceil(x1+pad))+1, int(math.Ceil(y1+pad))+1)
+}
+
+// stroke_shapes breaks s into shapes in pixels: a box or capsule per
+// segment, depending on the cap, and a disk or triangle filling the outside
+// of each corner for round and bevel joins.
+func stroke_shapes(s stroke, scale float64, line_cap LineCap, join LineJoin) []shape {
+	half := math.Max(s.width*scale, 1) / 2
+	points := make([]point, len(s.points))
+	for i, p := range s.points {
+		points[i] = point{p.x * scale, p.y * scale}
+	}
+	var shapes []shape
+	for i := 0; i+1 < len(points); i++ {
+		a, b := points[i], points[i+1]
+		if line_cap == CapRound {
+			shapes = append(shapes, capsule{a, b, half})
+			continue
+		}
+		dx, dy := b.x-a.x, b.y-a.y
+		l := math.Hypot(dx, dy)
+		if l == 0 {
+			if line_cap == CapButt {
+				continue
+			}
+			dx, dy, l = 1, 0, 1
+		}
+		ux, uy := dx/l*half, dy/l*half
+		if line_cap == CapSquare {
+			a, b = point{a.x - ux, a.y - uy}, point{b.x + ux, b.y + uy}
+		}
+		// uy, -ux is to the left of the direction of travel, seen on the
+		// image.
+		shapes = append(shapes, polygon{{a.x + uy, a.y - ux}, {a.x - uy, a.y + ux}, {b.x - uy, b.y + ux}, {b.x + uy, b.y - ux}})
+	}
+	if line_cap == CapRound || join == JoinNone {
+		return shapes
+	}
+	for i := 1; i+1 < len(points); i++ {
+		a, p, b := points[i-1], points[i], points[i+1]
+		if join == JoinRound {
+			shapes = append(shapes, capsule{p, p, half})
+			continue
+		}
+		d1x, d1y := p.x-a.x, p.y-a.y
+		d2x, d2y := b.x-p.x, b.y-p.y
+		l1, l2 := math.Hypot(d1x, d1y), math.Hypot(d2x, d2y)
+		turn := d1x*d2y - d1y*d2x
+		if l1 == 0 || l2 == 0 || turn == 0 {
+			continue
+		}
+		// The bevel is on the outside of the turn, between the ends of the
+		// two segments' edges.
+		side := -half
+		if turn < 0 {
+			side = half
+		}
+		e1 := point{p.x - d1y/l1*side, p.y + d1x/l1*side}
+		e2 := point{p.x - d2y/l2*side, p.y + d2x/l2*side}
+		if turn > 0 {
+			shapes = append(shapes, polygon{p, e2, e1})
+		} else {
+			shapes = append(shapes, polygon{p, e1, e2})
+		}
+	}
+	return shapes
+}
+
+// rasterize draws s on img, in ImageSize units scaled by scale. A pixel is
+// covered by how far its centre is inside the stroke, so antialiased edges
+// fade over one pixel; aliased ones are on or off at the edge. Pixels only
+// ever get darker, so the shapes making up a stroke join without seams and
+// nearer, darker 3D branches stay on top of lighter ones behind.
+func rasterize(img *image.Gray16, s stroke, scale float64, line_cap LineCap, join LineJoin, antialias bool) {
+	ink := s.gray * 0xffff
+	for _, sh := range stroke_shapes(s, scale, line_cap, join) {
+		r := sh.bounds().Intersect(img.Bounds())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				d := sh.distance(float64(x)+0.5, float64(y)+0.5)
+				coverage := 0.0
+				if antialias {
+					coverage = math.Max(0, math.Min(1, 0.5-d))
+				} else if d <= 0 {
+					coverage = 1
+				}
+				if coverage == 0 {
+					continue
+				}
+				v := uint16(math.Round(0xffff*(1-coverage) + ink*coverage))
+				if v < img.Gray16At(x, y).Y {
+					img.SetGray16(x, y, color.Gray16{v})
+				}
+			}
+		}
+	}
+}
+
+// clip_segment cuts s down to the part inside r, with Liang-Barsky
+// clipping. It returns false if no part of s is inside r or s isn't finite.
+func clip_segment(s Segment, r image.Rectangle) (Segment, bool) {
+	dx, dy := s.X2-s.X1, s.Y2-s.Y1
+	if math.IsNaN(dx) || math.IsInf(dx, 0) || math.IsNaN(dy) || math.IsInf(dy, 0) {
+		return s, false
+	}
+	t0, t1 := 0.0, 1.0
+	for _, e := range [][2]float64{
+		{-dx, s.X1 - float64(r.Min.X)},
+		{dx, float64(r.Max.X) - s.X1},
+		{-dy, s.Y1 - float64(r.Min.Y)},
+		{dy, float64(r.Max.Y) - s.Y1},
+	} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return s, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 && t > t0 {
+			t0 = t
+		} else if p > 0 && t < t1 {
+			t1 = t
+		}
+		if t0 > t1 {
+			return s, false
+		}
+	}
+	// Ends inside r are kept as they are, so rounding can't move them.
+	c := s
+	if t0 > 0 {
+		c.X1, c.Y1 = s.X1+t0*dx, s.Y1+t0*dy
+	}
+	if t1 < 1 {
+		c.X2, c.Y2 = s.X1+t1*dx, s.Y1+t1*dy
+	}
+	return c, true
+}
+
+// DrawLine draws s one pixel wide in black with Bresenham's algorithm, from
+// its start pixel to its end pixel, in any direction including straight
+// up and down. Only the part of s inside img is stepped through, and a
+// segment that isn't finite draws nothing.
+func DrawLine(img *image.Gray16, s Segment) {
+	s, ok := clip_segment(s, img.Bounds())
+	if !ok {
+		return
+	}
+	x0, y0 := int(math.Floor(s.X1)), int(math.Floor(s.Y1))
+	x1, y1 := int(math.Floor(s.X2)), int(math.Floor(s.Y2))
+	dx, dy := x1-x0, -(y1 - y0)
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy > 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.SetGray16(x0, y0, color.Black)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package biomorph
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func blank_gray(w, h int) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+// ascii draws black pixels as # and white ones as ., anything between as
+// a digit from 1, lightest, to 9.
+func ascii(img *image.Gray16) string {
+	var b strings.Builder
+	r := img.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			switch v := img.Gray16At(x, y).Y; v {
+			case 0:
+				b.WriteByte('#')
+			case 0xffff:
+				b.WriteByte('.')
+			default:
+				b.WriteByte(byte('1' + (8*(0xffff-int(v))+0xffff/2)/0xffff))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func golden(rows ...string) string {
+	return strings.Join(rows, "\n") + "\n"
+}
+
+func TestDrawLine(t *testing.T) {
+	blank := golden(".....", ".....", ".....", ".....", ".....")
+	for _, test := range []struct {
+		s    Segment
+		want string
+	}{
+		{Segment{2, 0, 2, 4}, golden(
+			"..#..",
+			"..#..",
+			"..#..",
+			"..#..",
+			"..#..")},
+		{Segment{4.5, 1, 0, 1}, golden(
+			".....",
+			"#####",
+			".....",
+			".....",
+			".....")},
+		{Segment{0, 4, 4, 0}, golden(
+			"....#",
+			"...#.",
+			"..#..",
+			".#...",
+			"#....")},
+		{Segment{1, 0, 3, 4}, golden(
+			".#...",
+			"..#..",
+			"..#..",
+			"...#.",
+			"...#.")},
+		{Segment{3, 3, 3, 3}, golden(
+			".....",
+			".....",
+			".....",
+			"...#.",
+			".....")},
+		// Only the part inside the image is stepped through.
+		{Segment{-1e12, 2, 1e12, 2}, golden(
+			".....",
+			".....",
+			"#####",
+			".....",
+			".....")},
+		{Segment{-3, 0, 0, -3}, blank},
+		{Segment{math.NaN(), 0, 2, 2}, blank},
+		{Segment{0, 0, math.Inf(1), 0}, blank},
+	} {
+		img := blank_gray(5, 5)
+		DrawLine(img, test.s)
+		assert.Equal(t, test.want, ascii(img), "%+v", test.s)
+	}
+}
+
+func TestRasterizeCaps(t *testing.T) {
+	s := stroke{[]point{{1, 3}, {7, 3}}, 2, 0}
+	for c, want := range map[LineCap]string{
+		CapButt: golden(
+			".........",
+			".........",
+			".######..",
+			".######..",
+			".........",
+			"........."),
+		CapRound: golden(
+			".........",
+			".........",
+			"########.",
+			"########.",
+			".........",
+			"........."),
+		CapSquare: golden(
+			".........",
+			".........",
+			"########.",
+			"########.",
+			".........",
+			"........."),
+	} {
+		img := blank_gray(9, 6)
+		rasterize(img, s, 1, c, JoinNone, false)
+		assert.Equal(t, want, ascii(img), c.String())
+	}
+	// Square caps reach the corners that round ones cut, at any angle.
+	s = stroke{[]point{{6, 6}, {10, 10}}, 6, 0}
+	for c, want := range map[LineCap]uint16{CapButt: 0xffff, CapRound: 0xffff, CapSquare: 0} {
+		img := blank_gray(16, 16)
+		rasterize(img, s, 1, c, JoinNone, false)
+		assert.Equal(t, want, img.Gray16At(2, 5).Y, c.String())
+	}
+}
+
+func TestRasterizeJoins(t *testing.T) {
+	s := stroke{[]point{{2, 2}, {8, 2}, {8, 8}}, 2, 0}
+	corner := func(join LineJoin, antialias bool) uint16 {
+		img := blank_gray(11, 11)
+		rasterize(img, s, 1, CapButt, join, antialias)
+		// The pixel at the outside of the corner.
+		return img.Gray16At(8, 1).Y
+	}
+	assert.Equal(t, uint16(0xffff), corner(JoinNone, false), "butt caps leave a notch")
+	assert.Equal(t, uint16(0), corner(JoinRound, false))
+	// The bevel cuts the corner pixel in half.
+	assert.InDelta(t, 0x8000, int(corner(JoinBevel, true)), 0x100)
+	assert.Equal(t, uint16(0xffff), corner(JoinNone, true))
+}
+
+func TestRasterizeAntialias(t *testing.T) {
+	// A width 1 line on a pixel boundary half covers the pixels both sides.
+	img := blank_gray(8, 6)
+	rasterize(img, stroke{[]point{{1, 3}, {6, 3}}, 1, 0}, 1, CapButt, JoinNone, true)
+	assert.Equal(t, golden(
+		"........",
+		"........",
+		".55555..",
+		".55555..",
+		"........",
+		"........"), ascii(img))
+
+	// Ink adds up to the area of the stroke, whatever its slope.
+	a, b := point{3.3, 4.7}, point{21.6, 13.1}
+	for _, c := range []LineCap{CapButt, CapRound, CapSquare} {
+		img := blank_gray(32, 24)
+		rasterize(img, stroke{[]point{a, b}, 3, 0}, 1, c, JoinNone, true)
+		ink := 0.0
+		for y := 0; y < 24; y++ {
+			for x := 0; x < 32; x++ {
+				ink += 1 - float64(img.Gray16At(x, y).Y)/0xffff
+			}
+		}
+		length := math.Hypot(b.x-a.x, b.y-a.y)
+		want := map[LineCap]float64{CapButt: length * 3, CapRound: length*3 + math.Pi*1.5*1.5, CapSquare: (length + 3) * 3}[c]
+		assert.InEpsilon(t, want, ink, 0.02, c.String())
+	}
+}
+
+func TestRenderQuality(t *testing.T) {
+	c := NewCreature(NewTreeSpecies())
+	// The pure-Go rasterizer's view of the default tree.
+	img := Render(c, RenderOptions{Size: 30, Aliased: true}).(*image.Gray16)
+	assert.Equal(t, golden(
+		"..............................",
+		"..............................",
+		"..............................",
+		"..............##..............",
+		"..........#...##...#..........",
+		"..........#...##...#..........",
+		".....#....##..##..##....#.....",
+		".....##...##..##..##...##.....",
+		"......#...#.#.##.#.#...#......",
+		".......#..#..####..#..#.......",
+		"..#....################....#..",
+		"..####.################.####..",
+		"....#.######..##..######.#....",
+		"....##.####...##...####.##....",
+		".....###...#..##..#...###.....",
+		"########...##.##.##...########",
+		"..######....#.##.#....######..",
+		".....#####...####...#####.....",
+		"...####...###.##.###...####...",
+		"###...#......####......#...###",
+		".....#........##........#.....",
+		"....##........##........##....",
+		"....#.........##.........#....",
+		"...#..........##..........#...",
+		"...#..........##..........#...",
+		"..............##..............",
+		"..............##..............",
+		"..............................",
+		"..............................",
+		".............................."), ascii(img))
+
+	// Supersampling an aliased render antialiases it.
+	smooth, ok := Render(c, RenderOptions{Size: 30, Aliased: true, Supersample: 4}).(*image.Gray16)
+	assert.True(t, ok)
+	assert.Equal(t, image.Rect(0, 0, 30, 30), smooth.Bounds())
+	grays := 0
+	for _, p := range []*image.Gray16{img, smooth} {
+		for i := 0; i < len(p.Pix); i += 2 {
+			if v := uint16(p.Pix[i])<<8 | uint16(p.Pix[i+1]); v != 0 && v != 0xffff {
+				grays++
+			}
+		}
+	}
+	assert.True(t, grays > 50)
+
+	gray := Render(c, RenderOptions{Gray: true})
+	_, ok = gray.(*image.Gray16)
+	assert.True(t, ok)
+	plain := Render(c, DefaultRenderOptions())
+	assert.Equal(t, plain, Render(c, RenderOptions{Size: ImageSize, Supersample: 1}))
+	assert.NotEqual(t, plain, Render(c, RenderOptions{Size: ImageSize, Cap: CapButt}))
+	assert.NotEqual(t, plain, Render(c, RenderOptions{Size: ImageSize, Supersample: 2}))
+	assert.Equal(t, image.Rect(0, 0, 40, 40), Render(NewCreature(NewTree3dSpecies()), RenderOptions{Size: 40, Supersample: 3, Aliased: true}).Bounds())
+
+	var svg bytes.Buffer
+	assert.NoError(t, WriteSvgOptions(&svg, c, RenderOptions{Size: 100, Cap: CapSquare, Join: JoinBevel}))
+	assert.Contains(t, svg.String(), `stroke-linecap="square" stroke-linejoin="bevel"`)
+	assert.Contains(t, svg.String(), "<polyline points=")
+}
+
+func TestParseLineStyle(t *testing.T) {
+	for name, want := range map[string]LineCap{"": CapRound, "round": CapRound, "butt": CapButt, "square": CapSquare} {
+		c, err := ParseLineCap(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, c)
+	}
+	_, err := ParseLineCap("pointy")
+	assert.Error(t, err)
+	for name, want := range map[string]LineJoin{"": JoinNone, "none": JoinNone, "round": JoinRound, "bevel": JoinBevel} {
+		j, err := ParseLineJoin(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, j)
+	}
+	_, err = ParseLineJoin("miter")
+	assert.Error(t, err)
+	assert.Equal(t, "LineCap(7)", LineCap(7).String())
+	assert.Equal(t, "LineJoin(-1)", LineJoin(-1).String())
+}
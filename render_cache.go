@@ -0,0 +1,206 @@
+package biomorph
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RenderOptions are the settings a creature is drawn with. Camera only
+// applies to 3D creatures and Join only to 2D ones. The zero value of each
+// quality setting draws as gg does by default.
+type RenderOptions struct {
+	Size   int
+	Camera Camera
+	// Supersample draws at this many times Size, up to MaxSupersample, and
+	// averages each square of pixels down to one for smoother edges.
+	Supersample int
+	// Gray draws on an image.Gray16 with the package's own rasterizer
+	// instead of gg.
+	Gray bool
+	// Aliased draws hard-edged pixels, with the Gray rasterizer.
+	Aliased bool
+	Cap     LineCap
+	Join    LineJoin
+	Budget  RenderBudget
+}
+
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{Size: ImageSize, Budget: DefaultRenderBudget}
+}
+
+// Render draws tree with opts. 3D creatures are shaded by depth. If
+// opts.Budget runs out it returns what it drew; RenderContext also says so.
+func Render(tree *Creature, opts RenderOptions) image.Image {
+	img, _ := RenderContext(context.Background(), tree, opts)
+	return img
+}
+
+// RenderKey identifies a rendering by the species' renderer and genes, the
+// creature's gene values and the render options, so identical creatures
+// share an entry. The budget is left out: only complete images are cached,
+// and those don't depend on it.
+func RenderKey(c *Creature, opts RenderOptions) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|", c.CreatureSpecies.Renderer)
+	if p := c.CreatureSpecies.Program; p != nil {
+		fmt.Fprintf(h, "%s|", p.Source)
+	}
+	if l := c.CreatureSpecies.LSystem; l != nil {
+		fmt.Fprintf(h, "%s|", l)
+	}
+	for _, v := range c.Values {
+		fmt.Fprintf(h, "%s[%v,%v]=%v|", v.Gene.Name, v.Gene.Range.Min, v.Gene.Range.Max, v.Value)
+	}
+	opts.Budget = RenderBudget{}
+	fmt.Fprintf(h, "%+v", opts)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+type RenderCacheStats struct {
+	Hits      uint64  `json:"hits"`
+	DiskHits  uint64  `json:"disk_hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	Entries   int     `json:"entries"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+type render_entry struct {
+	key string
+	img image.Image
+}
+
+// RenderCache is a bounded LRU of rendered creatures, optionally backed by a
+// directory of PNGs that survives restarts. Cached images are shared between
+// callers and must not be modified.
+type RenderCache struct {
+	mu       sync.Mutex
+	capacity int
+	dir      string
+	entries  map[string]*list.Element
+	order    *list.List
+	stats    RenderCacheStats
+}
+
+// NewRenderCache keeps up to capacity images in memory. If dir is not empty
+// renders are also written there and read back on a memory miss.
+func NewRenderCache(capacity int, dir string) (*RenderCache, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &RenderCache{capacity: capacity, dir: dir, entries: map[string]*list.Element{}, order: list.New()}, nil
+}
+
+// Render returns the cached image of c, drawing it on a miss.
+func (r *RenderCache) Render(c *Creature, opts RenderOptions) image.Image {
+	img, _ := r.RenderContext(context.Background(), c, opts)
+	return img
+}
+
+// RenderContext is Render with RenderContext's early stopping. Partial
+// images are returned with their error but not cached.
+func (r *RenderCache) RenderContext(ctx context.Context, c *Creature, opts RenderOptions) (image.Image, error) {
+	key := RenderKey(c, opts)
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok {
+		r.order.MoveToFront(e)
+		r.stats.Hits++
+		r.mu.Unlock()
+		return e.Value.(*render_entry).img, nil
+	}
+	r.mu.Unlock()
+
+	img, from_disk := r.load(key)
+	if img == nil {
+		var err error
+		if img, err = RenderContext(ctx, c, opts); err != nil {
+			r.mu.Lock()
+			r.stats.Misses++
+			r.mu.Unlock()
+			return img, err
+		}
+		r.store(key, img)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if from_disk {
+		r.stats.DiskHits++
+	} else {
+		r.stats.Misses++
+	}
+	r.add(key, img)
+	return img, nil
+}
+
+func (r *RenderCache) add(key string, img image.Image) {
+	if e, ok := r.entries[key]; ok {
+		r.order.MoveToFront(e)
+		return
+	}
+	r.entries[key] = r.order.PushFront(&render_entry{key, img})
+	for r.order.Len() > r.capacity {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*render_entry).key)
+		r.stats.Evictions++
+	}
+}
+
+func (r *RenderCache) path(key string) string {
+	return filepath.Join(r.dir, key+".png")
+}
+
+func (r *RenderCache) load(key string) (image.Image, bool) {
+	if r.dir == "" {
+		return nil, false
+	}
+	f, err := os.Open(r.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, false
+	}
+	return img, true
+}
+
+// store writes img to the disk tier. Failures only cost a future re-render.
+func (r *RenderCache) store(key string, img image.Image) {
+	if r.dir == "" {
+		return
+	}
+	tmp, err := os.CreateTemp(r.dir, key+".*.tmp")
+	if err != nil {
+		return
+	}
+	err = png.Encode(tmp, img)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	os.Rename(tmp.Name(), r.path(key))
+}
+
+func (r *RenderCache) Stats() RenderCacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.Entries = r.order.Len()
+	if total := s.Hits + s.DiskHits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits+s.DiskHits) / float64(total)
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package biomorph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderCache(t *testing.T) {
+	cache, err := NewRenderCache(2, "")
+	assert.NoError(t, err)
+	a := NewCreature(NewTreeSpecies())
+	b := NewCreature(NewTreeSpecies())
+	b.GetGeneValue("num_gens").Value = 3
+	c := NewCreature(NewTreeSpecies())
+	c.GetGeneValue("num_gens").Value = 4
+	opts := DefaultRenderOptions()
+
+	img := cache.Render(a, opts)
+	assert.Equal(t, img, cache.Render(NewCreature(NewTreeSpecies()), opts))
+	cache.Render(b, opts)
+	cache.Render(c, opts)
+	cache.Render(a, opts)
+
+	s := cache.Stats()
+	assert.Equal(t, uint64(1), s.Hits)
+	assert.Equal(t, uint64(4), s.Misses)
+	assert.Equal(t, uint64(2), s.Evictions)
+	assert.Equal(t, 2, s.Entries)
+	assert.Equal(t, 0.2, s.HitRate)
+
+	big := cache.Render(a, RenderOptions{Size: 2 * ImageSize})
+	assert.Equal(t, 2*ImageSize, big.Bounds().Dx())
+}
+
+func TestRenderCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	a := NewCreature(NewTreeSpecies())
+	cache, err := NewRenderCache(1, dir)
+	assert.NoError(t, err)
+	cache.Render(a, DefaultRenderOptions())
+
+	cache, err = NewRenderCache(1, dir)
+	assert.NoError(t, err)
+	img := cache.Render(a, DefaultRenderOptions())
+	assert.Equal(t, ImageSize, img.Bounds().Dx())
+	assert.Equal(t, uint64(1), cache.Stats().DiskHits)
+	assert.Equal(t, uint64(0), cache.Stats().Misses)
+}
@@ -0,0 +1,51 @@
+package biomorph
+
+import (
+	"context"
+	"math"
+)
+
+// SegmentedSegments draws a body of c's copies of the segment module, from
+// the bottom middle of the image upwards. Each segment turns the body by its
+// bend, extends it by its length and grows a mirrored pair of limbs at its
+// end, limb_angle either side of the body. A body too big for the image is
+// shrunk to fit.
+func SegmentedSegments(c *Creature) []Segment {
+	segments, _ := segmented_segments(context.Background(), c)
+	return segments
+}
+
+// segmented_segments lays out c, stopping early with ctx's error if ctx is
+// done.
+func segmented_segments(ctx context.Context, c *Creature) ([]Segment, error) {
+	m := c.CreatureSpecies.Module("segment")
+	if m == nil {
+		return nil, nil
+	}
+	index := map[string]int{}
+	for j, g := range m.Genes {
+		index[g.Name] = j
+	}
+	x0, y0 := float64(ImageSize)/2, float64(ImageSize)*9/10
+	x, y, heading := x0, y0, 0.0
+	var segments []Segment
+	var err error
+	for _, values := range c.Copies(m) {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		get := func(name string) float64 {
+			return values[index[name]].Value
+		}
+		heading += get("bend")
+		nx, ny := x-get("length")*math.Sin(heading), y-get("length")*math.Cos(heading)
+		segments = append(segments, Segment{x, y, nx, ny})
+		x, y = nx, ny
+		for _, side := range []float64{-1, 1} {
+			a := heading + side*get("limb_angle")
+			segments = append(segments, Segment{x, y, x - get("limb_length")*math.Sin(a), y - get("limb_length")*math.Cos(a)})
+		}
+	}
+	fit_segments(segments, x0, y0)
+	return segments, err
+}
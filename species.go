@@ -0,0 +1,498 @@
+package biomorph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Renderer lays out the drawing of a creature. Genes are the gene names it
+// reads, which a species bound to it must define, and Modules the modules
+// it reads with the genes each must define. A species can't have modules
+// its renderer doesn't read. Renderers of 3D creatures set Segments3, and
+// Segments is their default view.
+type Renderer struct {
+	Genes     []string
+	Modules   map[string][]string
+	Segments  func(c *Creature) []Segment
+	Segments3 func(c *Creature) []Segment3
+}
+
+// Renderers are the drawings a species can be bound to by name.
+var Renderers = map[string]Renderer{
+	"tree": {
+		Genes:    []string{"branch_length", "num_gens", "branch_angle", "branch_increase", "angle_increase", "num_branches", "angle_noise", "length_noise"},
+		Segments: TreeSegments,
+	},
+	"tree3d": {
+		Genes:     []string{"branch_length", "num_gens", "num_branches", "branch_increase", "pitch", "pitch_increase", "yaw", "roll", "phyllotaxis", "thickness"},
+		Segments:  func(c *Creature) []Segment { return Project(Tree3dSegments(c), Camera{}) },
+		Segments3: Tree3dSegments,
+	},
+	"segmented": {
+		Modules:  map[string][]string{"segment": {"length", "bend", "limb_length", "limb_angle"}},
+		Segments: SegmentedSegments,
+	},
+}
+
+// program_renderer and lsystem_renderer are the Renderer values of species
+// drawn by a Program or an LSystem.
+const (
+	program_renderer = "program"
+	lsystem_renderer = "lsystem"
+)
+
+// CreatureSegments lays out c with its species' renderer. A program or
+// L-system that hits its limits is drawn as far as it got.
+func CreatureSegments(c *Creature) []Segment {
+	segments, _ := creature_segments(context.Background(), c)
+	return segments
+}
+
+// creature_segments is CreatureSegments stopping early, with ctx's error, if
+// ctx is done while a program, L-system or module body is laid out.
+func creature_segments(ctx context.Context, c *Creature) ([]Segment, error) {
+	if p := c.CreatureSpecies.Program; p != nil {
+		segments, err := p.RunContext(ctx, c, DefaultProgramLimits)
+		return segments, ctx_err(ctx, err)
+	}
+	if l := c.CreatureSpecies.LSystem; l != nil {
+		segments, err := l.SegmentsContext(ctx, c)
+		return segments, ctx_err(ctx, err)
+	}
+	if c.CreatureSpecies.Renderer == "segmented" {
+		return segmented_segments(ctx, c)
+	}
+	r, ok := Renderers[c.CreatureSpecies.Renderer]
+	if !ok {
+		r = Renderers["tree"]
+	}
+	return r.Segments(c), nil
+}
+
+// ctx_err keeps err only if it is ctx's: a program or L-system that hits
+// its own limits is drawn as far as it got.
+func ctx_err(ctx context.Context, err error) error {
+	if err != nil && errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return nil
+}
+
+var (
+	species_mu         sync.RWMutex
+	builtin_species    = map[string]func() *Species{"tree": NewTreeSpecies, "tree3d": NewTree3dSpecies}
+	configured_species = map[string]*Species{}
+)
+
+// GetSpecies looks up a species by name. Configured species take precedence
+// over the built-in ones of the same name.
+func GetSpecies(name string) (*Species, error) {
+	species_mu.RLock()
+	s, ok := configured_species[name]
+	species_mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+	if f, ok := builtin_species[name]; ok {
+		return f(), nil
+	}
+	return nil, fmt.Errorf("unknown species %q", name)
+}
+
+// SpeciesNames lists the built-in and configured species, sorted.
+func SpeciesNames() []string {
+	species_mu.RLock()
+	defer species_mu.RUnlock()
+	var names []string
+	for name := range builtin_species {
+		if _, ok := configured_species[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range configured_species {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetConfiguredSpecies replaces all configured species. Creatures keep the
+// species they were made with, so reloading doesn't disturb them.
+func SetConfiguredSpecies(species []*Species) {
+	m := make(map[string]*Species, len(species))
+	for _, s := range species {
+		m[s.Name] = s
+	}
+	species_mu.Lock()
+	configured_species = m
+	species_mu.Unlock()
+}
+
+// LoadCreature makes a creature of s from stored gene values. Values of
+// genes s doesn't define are dropped and the rest are fitted to the current
+// ranges, so creatures saved under an older definition still load. A
+// module's copies are counted up from copy 0 while any of their genes has a
+// value, and missing genes start at their initial values.
+func (s *Species) LoadCreature(values map[string]float64) *Creature {
+	counts := make([]int, len(s.Modules))
+	for i, m := range s.Modules {
+		for counts[i] < m.Max && has_any(values, m.copies[counts[i]]) {
+			counts[i]++
+		}
+		if counts[i] < m.Min {
+			counts[i] = m.Min
+		}
+	}
+	c := NewCreatureCounts(s, counts)
+	for _, v := range c.Values {
+		if value, ok := values[v.Gene.Name]; ok {
+			v.Value = v.Gene.Fit(value)
+		}
+	}
+	return c
+}
+
+func has_any(values map[string]float64, genes []*Gene) bool {
+	for _, g := range genes {
+		if _, ok := values[g.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type GeneConfig struct {
+	Name    string   `json:"name" yaml:"name"`
+	Min     float64  `json:"min" yaml:"min"`
+	Max     float64  `json:"max" yaml:"max"`
+	Type    GeneType `json:"type,omitempty" yaml:"type"`
+	Default *float64 `json:"default,omitempty" yaml:"default"`
+}
+
+// build_genes validates gene definitions. where names gene i in errors.
+func build_genes(configs []GeneConfig, where func(i int, name string) string) ([]*Gene, error) {
+	var genes []*Gene
+	for i, gc := range configs {
+		bad := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s: %s", where(i, gc.Name), fmt.Sprintf(format, args...))
+		}
+		if !species_name_pattern.MatchString(gc.Name) {
+			return nil, bad("name must be lower case letters, digits, _ or -, starting with a letter")
+		}
+		if has_gene(genes, gc.Name) {
+			return nil, bad("defined twice")
+		}
+		if math.IsNaN(gc.Min) || math.IsInf(gc.Min, 0) || math.IsNaN(gc.Max) || math.IsInf(gc.Max, 0) || gc.Min >= gc.Max {
+			return nil, bad("range [%v, %v] must be finite with min below max", gc.Min, gc.Max)
+		}
+		if gc.Default != nil && !(*gc.Default >= gc.Min && *gc.Default <= gc.Max) {
+			return nil, bad("default %v is outside [%v, %v]", *gc.Default, gc.Min, gc.Max)
+		}
+		g := &Gene{GeneRange{gc.Min, gc.Max}, gc.Name, gc.Type, gc.Default}
+		switch gc.Type {
+		case "":
+			g.Type = GeneFloat
+		case GeneFloat:
+		case GeneInt:
+			if gc.Min != math.Trunc(gc.Min) || gc.Max != math.Trunc(gc.Max) {
+				return nil, bad("int gene range [%v, %v] must be whole numbers", gc.Min, gc.Max)
+			}
+		default:
+			return nil, bad("unknown type %q, want float or int", gc.Type)
+		}
+		genes = append(genes, g)
+	}
+	return genes, nil
+}
+
+func has_gene(genes []*Gene, name string) bool {
+	for _, g := range genes {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleConfig defines a Module. Initial defaults to Min, and the
+// structural mutation chances to 0.
+type ModuleConfig struct {
+	Name      string       `json:"name" yaml:"name"`
+	Min       int          `json:"min" yaml:"min"`
+	Max       int          `json:"max" yaml:"max"`
+	Initial   *int         `json:"initial,omitempty" yaml:"initial"`
+	Duplicate float64      `json:"duplicate,omitempty" yaml:"duplicate"`
+	Delete    float64      `json:"delete,omitempty" yaml:"delete"`
+	Insert    float64      `json:"insert,omitempty" yaml:"insert"`
+	Genes     []GeneConfig `json:"genes" yaml:"genes"`
+}
+
+// Module validates the definition and builds the module. where names gene
+// j of the module in errors.
+func (mc *ModuleConfig) Module(where func(j int, name string) string) (*Module, error) {
+	if !species_name_pattern.MatchString(mc.Name) {
+		return nil, fmt.Errorf("name must be lower case letters, digits, _ or -, starting with a letter")
+	}
+	if mc.Min < 0 || mc.Max < 1 || mc.Min > mc.Max || mc.Max > MaxModuleCopies {
+		return nil, fmt.Errorf("copies [%d, %d] must have 0 <= min <= max, 1 <= max <= %d", mc.Min, mc.Max, MaxModuleCopies)
+	}
+	initial := mc.Min
+	if mc.Initial != nil {
+		initial = *mc.Initial
+	}
+	if initial < mc.Min || initial > mc.Max {
+		return nil, fmt.Errorf("initial copies %d is outside [%d, %d]", initial, mc.Min, mc.Max)
+	}
+	for _, rate := range []float64{mc.Duplicate, mc.Delete, mc.Insert} {
+		if !(rate >= 0 && rate <= 1) {
+			return nil, fmt.Errorf("structural mutation chance %v must be between 0 and 1", rate)
+		}
+	}
+	if len(mc.Genes) == 0 {
+		return nil, fmt.Errorf("no genes")
+	}
+	genes, err := build_genes(mc.Genes, where)
+	if err != nil {
+		return nil, err
+	}
+	m := NewModule(mc.Name, genes, mc.Min, mc.Max, initial)
+	m.Duplicate, m.Delete, m.Insert = mc.Duplicate, mc.Delete, mc.Insert
+	return m, nil
+}
+
+// SpeciesConfig is a species definition file. Renderer defaults to "tree",
+// "program" when Program, the source of a drawing Program, is given or
+// "lsystem" when LSystem is. Mutation defaults to DefaultMutation.
+type SpeciesConfig struct {
+	Name     string         `json:"name" yaml:"name"`
+	Renderer string         `json:"renderer,omitempty" yaml:"renderer"`
+	Program  string         `json:"program,omitempty" yaml:"program"`
+	LSystem  *LSystemConfig `json:"lsystem,omitempty" yaml:"lsystem"`
+	Mutation *Mutation      `json:"mutation,omitempty" yaml:"mutation"`
+	Genes    []GeneConfig   `json:"genes" yaml:"genes"`
+	Modules  []ModuleConfig `json:"modules,omitempty" yaml:"modules"`
+}
+
+var species_name_pattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// Species validates the definition and builds the species.
+func (sc *SpeciesConfig) Species() (*Species, error) {
+	if !species_name_pattern.MatchString(sc.Name) {
+		return nil, fmt.Errorf("species name %q must be lower case letters, digits, _ or -, starting with a letter", sc.Name)
+	}
+	s := &Species{Name: sc.Name, Renderer: sc.Renderer, Mutation: DefaultMutation}
+	if sc.Program != "" && sc.LSystem != nil {
+		return nil, fmt.Errorf("species %s: a program and an lsystem can't be used together", sc.Name)
+	}
+	if s.Renderer == "" {
+		s.Renderer = "tree"
+		if sc.Program != "" {
+			s.Renderer = program_renderer
+		} else if sc.LSystem != nil {
+			s.Renderer = lsystem_renderer
+		}
+	}
+	renderer, ok := Renderers[s.Renderer]
+	switch {
+	case s.Renderer == program_renderer:
+		if sc.Program == "" {
+			return nil, fmt.Errorf("species %s: renderer program needs a program", sc.Name)
+		}
+	case s.Renderer == lsystem_renderer:
+		if sc.LSystem == nil {
+			return nil, fmt.Errorf("species %s: renderer lsystem needs an lsystem", sc.Name)
+		}
+	case sc.Program != "":
+		return nil, fmt.Errorf("species %s: a program can't be used with renderer %s", sc.Name, s.Renderer)
+	case sc.LSystem != nil:
+		return nil, fmt.Errorf("species %s: an lsystem can't be used with renderer %s", sc.Name, s.Renderer)
+	case !ok:
+		return nil, fmt.Errorf("species %s: unknown renderer %q", sc.Name, s.Renderer)
+	}
+	if sc.Mutation != nil {
+		s.Mutation = *sc.Mutation
+		if !(s.Mutation.Rate >= 0 && s.Mutation.Rate <= 1) {
+			return nil, fmt.Errorf("species %s: mutation rate %v must be between 0 and 1", sc.Name, s.Mutation.Rate)
+		}
+		if !(s.Mutation.Scale > 0 && s.Mutation.Scale <= 1) {
+			return nil, fmt.Errorf("species %s: mutation scale %v must be above 0 and at most 1", sc.Name, s.Mutation.Scale)
+		}
+	}
+	if len(sc.Genes) == 0 && len(sc.Modules) == 0 && sc.LSystem == nil {
+		return nil, fmt.Errorf("species %s: no genes", sc.Name)
+	}
+	genes, err := build_genes(sc.Genes, func(i int, name string) string {
+		return fmt.Sprintf("species %s: gene %d (%s)", sc.Name, i+1, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Genes = genes
+	for i, mc := range sc.Modules {
+		m, err := mc.Module(func(j int, name string) string {
+			return fmt.Sprintf("gene %d (%s)", j+1, name)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("species %s: module %d (%s): %v", sc.Name, i+1, mc.Name, err)
+		}
+		if s.Module(m.Name) != nil {
+			return nil, fmt.Errorf("species %s: module %d (%s): defined twice", sc.Name, i+1, mc.Name)
+		}
+		if _, ok := renderer.Modules[m.Name]; !ok {
+			return nil, fmt.Errorf("species %s: renderer %s doesn't draw module %q", sc.Name, s.Renderer, m.Name)
+		}
+		s.Modules = append(s.Modules, m)
+	}
+	for _, name := range renderer.Genes {
+		if s.Gene(name) == nil {
+			return nil, fmt.Errorf("species %s: renderer %s needs gene %q", sc.Name, s.Renderer, name)
+		}
+	}
+	for name, genes := range renderer.Modules {
+		m := s.Module(name)
+		if m == nil {
+			return nil, fmt.Errorf("species %s: renderer %s needs module %q", sc.Name, s.Renderer, name)
+		}
+		for _, gene := range genes {
+			if !has_gene(m.Genes, gene) {
+				return nil, fmt.Errorf("species %s: renderer %s needs gene %q in module %s", sc.Name, s.Renderer, gene, name)
+			}
+		}
+	}
+	if sc.Program != "" {
+		names := make([]string, len(s.Genes))
+		for i, g := range s.Genes {
+			names[i] = g.Name
+		}
+		program, err := CompileProgram(sc.Program, names)
+		if err != nil {
+			return nil, fmt.Errorf("species %s: program %v", sc.Name, err)
+		}
+		s.Program = program
+	}
+	if sc.LSystem != nil {
+		names := make([]string, len(s.Genes))
+		for i, g := range s.Genes {
+			names[i] = g.Name
+		}
+		l, genes, err := sc.LSystem.LSystem(names)
+		if err != nil {
+			return nil, fmt.Errorf("species %s: %v", sc.Name, err)
+		}
+		s.LSystem = l
+		s.Genes = append(s.Genes, genes...)
+	}
+	return s, nil
+}
+
+// json_line turns a byte offset into a line number for error messages.
+func json_line(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
+
+// ParseSpeciesConfig reads a definition, as JSON for .json files and YAML
+// otherwise. Unknown fields are errors, so typos don't pass silently.
+func ParseSpeciesConfig(name string, b []byte) (*SpeciesConfig, error) {
+	var sc SpeciesConfig
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&sc); err != nil {
+			var syntax *json.SyntaxError
+			var typ *json.UnmarshalTypeError
+			if errors.As(err, &syntax) {
+				return nil, fmt.Errorf("%s:%d: %v", name, json_line(b, syntax.Offset), err)
+			}
+			if errors.As(err, &typ) {
+				return nil, fmt.Errorf("%s:%d: %v", name, json_line(b, typ.Offset), err)
+			}
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return &sc, nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &sc, nil
+}
+
+// LoadSpeciesFile reads and validates a species definition file.
+func LoadSpeciesFile(path string) (*Species, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := ParseSpeciesConfig(path, b)
+	if err != nil {
+		return nil, err
+	}
+	s, err := sc.Species()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// SpeciesFiles lists the definition files in dir: *.yaml, *.yml and *.json.
+func SpeciesFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !e.IsDir() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// LoadSpeciesDir loads every definition file in dir, one species per file.
+// All errors are reported together.
+func LoadSpeciesDir(dir string) ([]*Species, error) {
+	files, err := SpeciesFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var species []*Species
+	var errs []error
+	seen := map[string]string{}
+	for _, path := range files {
+		s, err := LoadSpeciesFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if other, ok := seen[s.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: species %s is already defined in %s", path, s.Name, other))
+			continue
+		}
+		seen[s.Name] = path
+		species = append(species, s)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return species, nil
+}
@@ -0,0 +1,104 @@
+package biomorph
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fern_yaml = `name: fern
+mutation:
+  rate: 0.5
+  scale: 0.1
+genes:
+  - {name: branch_length, min: 10, max: 30}
+  - {name: num_gens, min: 2, max: 6, type: int}
+  - {name: branch_angle, min: 0.2, max: 1}
+  - {name: branch_increase, min: 0.5, max: 0.9}
+  - {name: angle_increase, min: 0.8, max: 1.2}
+  - {name: num_branches, min: 2, max: 4, type: int}
+  - {name: angle_noise, min: -0.1, max: 0.1}
+  - {name: length_noise, min: -0.1, max: 0.1}
+`
+
+func write_species(t *testing.T, dir string, name string, body string) {
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0644))
+}
+
+func TestLoadSpeciesDir(t *testing.T) {
+	dir := t.TempDir()
+	write_species(t, dir, "fern.yaml", fern_yaml)
+	write_species(t, dir, "notes.txt", "not a species")
+	species, err := LoadSpeciesDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, species, 1)
+	fern := species[0]
+	assert.Equal(t, "fern", fern.Name)
+	assert.Equal(t, "tree", fern.Renderer)
+	assert.Equal(t, Mutation{0.5, 0.1}, fern.Mutation)
+	assert.Equal(t, GeneInt, fern.Gene("num_gens").Type)
+
+	SetConfiguredSpecies(species)
+	defer SetConfiguredSpecies(nil)
+	assert.Equal(t, []string{"fern", "tree", "tree3d"}, SpeciesNames())
+	got, err := GetSpecies("fern")
+	assert.NoError(t, err)
+	c := NewCreature(got)
+	assert.Equal(t, 4.0, c.GetValue("num_gens"))
+	for i := 0; i < 20; i++ {
+		c = MutateCreature(c)
+		v := c.GetValue("num_branches")
+		assert.Equal(t, float64(int(v)), v)
+	}
+	assert.NotNil(t, DrawCreature(c))
+	decoded, err := DecodeCode(EncodeCode(c))
+	assert.NoError(t, err)
+	assert.Equal(t, "fern", decoded.CreatureSpecies.Name)
+}
+
+func TestSpeciesConfigErrors(t *testing.T) {
+	for want, body := range map[string]string{
+		"fern.yaml: yaml: line 2":                   "name: fern\ngenes: [",
+		"field colour not found":                    "name: fern\ncolour: green\n",
+		"species name \"Fern\"":                     strings.Replace(fern_yaml, "fern", "Fern", 1),
+		"unknown renderer \"bush\"":                 "renderer: bush\n" + fern_yaml,
+		"mutation rate 2":                           strings.Replace(fern_yaml, "rate: 0.5", "rate: 2", 1),
+		"gene 2 (num_gens): range [6, 2]":           strings.Replace(fern_yaml, "min: 2, max: 6", "min: 6, max: 2", 1),
+		"gene 2 (num_gens): int gene range":         strings.Replace(fern_yaml, "min: 2, max: 6", "min: 2.5, max: 6", 1),
+		"unknown type \"bool\"":                     strings.Replace(fern_yaml, "type: int}\n  - {name: branch_angle", "type: bool}\n  - {name: branch_angle", 1),
+		"gene 3 (num_gens): defined twice":          strings.Replace(fern_yaml, "name: branch_angle", "name: num_gens", 1),
+		"renderer tree needs gene \"length_noise\"": strings.Replace(fern_yaml, "  - {name: length_noise, min: -0.1, max: 0.1}\n", "", 1),
+	} {
+		dir := t.TempDir()
+		write_species(t, dir, "fern.yaml", body)
+		_, err := LoadSpeciesDir(dir)
+		if assert.Error(t, err, want) {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+
+	dir := t.TempDir()
+	write_species(t, dir, "a.json", "{\n  \"name\": \"fern\",\n  \"genes\": 3\n}")
+	_, err := LoadSpeciesDir(dir)
+	assert.ErrorContains(t, err, "a.json:3:")
+
+	dir = t.TempDir()
+	write_species(t, dir, "a.yaml", fern_yaml)
+	write_species(t, dir, "b.yml", fern_yaml)
+	_, err = LoadSpeciesDir(dir)
+	assert.ErrorContains(t, err, "already defined in")
+}
+
+func TestExampleSpecies(t *testing.T) {
+	species, err := LoadSpeciesDir("species")
+	assert.NoError(t, err)
+	assert.Len(t, species, 4)
+	for _, s := range species {
+		c := RandomCreature(s, rand.New(rand.NewSource(1)))
+		assert.NotEmpty(t, CreatureSegments(c), s.Name)
+	}
+}
@@ -0,0 +1,118 @@
+package biomorph
+
+import "math"
+
+// Vec3 is a point or direction in the space 3D creatures grow in: Y is up,
+// the root is at the origin and lengths are in ImageSize units.
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+func (a Vec3) Add(b Vec3) Vec3 {
+	return Vec3{a.X + b.X, a.Y + b.Y, a.Z + b.Z}
+}
+
+func (a Vec3) Sub(b Vec3) Vec3 {
+	return Vec3{a.X - b.X, a.Y - b.Y, a.Z - b.Z}
+}
+
+func (a Vec3) Scale(s float64) Vec3 {
+	return Vec3{a.X * s, a.Y * s, a.Z * s}
+}
+
+func (a Vec3) Dot(b Vec3) float64 {
+	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
+}
+
+func (a Vec3) Cross(b Vec3) Vec3 {
+	return Vec3{a.Y*b.Z - a.Z*b.Y, a.Z*b.X - a.X*b.Z, a.X*b.Y - a.Y*b.X}
+}
+
+func (a Vec3) Len() float64 {
+	return math.Sqrt(a.Dot(a))
+}
+
+// Unit scales a to length 1. The zero vector stays zero.
+func (a Vec3) Unit() Vec3 {
+	l := a.Len()
+	if l == 0 {
+		return a
+	}
+	return a.Scale(1 / l)
+}
+
+// rotate turns v by angle radians about the unit vector axis, counter
+// clockwise looking down the axis.
+func rotate(v Vec3, axis Vec3, angle float64) Vec3 {
+	c, s := math.Cos(angle), math.Sin(angle)
+	return v.Scale(c).Add(axis.Cross(v).Scale(s)).Add(axis.Scale(axis.Dot(v) * (1 - c)))
+}
+
+// Segment3 is a branch in 3D: a cylinder of Radius from A to B.
+type Segment3 struct {
+	A, B   Vec3
+	Radius float64
+}
+
+func default_value(v float64) *float64 {
+	return &v
+}
+
+// golden_angle spreads siblings evenly around their parent, as leaves are
+// around a stem.
+const golden_angle = 2.399963229728653
+
+func Tree3dGenes() (genes []*Gene) {
+	genes = append(genes, &Gene{GeneRange{15, 50}, "branch_length", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{2, 6}, "num_gens", GeneInt, default_value(4)})
+	genes = append(genes, &Gene{GeneRange{2, 5}, "num_branches", GeneInt, default_value(3)})
+	genes = append(genes, &Gene{GeneRange{0.5, 0.9}, "branch_increase", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{0.1, 1.4}, "pitch", GeneFloat, default_value(0.6)})
+	genes = append(genes, &Gene{GeneRange{0.7, 1.3}, "pitch_increase", GeneFloat, default_value(1)})
+	genes = append(genes, &Gene{GeneRange{-0.5, 0.5}, "yaw", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{-math.Pi, math.Pi}, "roll", GeneFloat, nil})
+	genes = append(genes, &Gene{GeneRange{0, 2 * math.Pi}, "phyllotaxis", GeneFloat, default_value(golden_angle)})
+	genes = append(genes, &Gene{GeneRange{0.5, 4}, "thickness", GeneFloat, default_value(2)})
+	return
+}
+
+// NewTree3dSpecies makes a tree that branches in three dimensions.
+func NewTree3dSpecies() *Species {
+	return &Species{Name: "tree3d", Genes: Tree3dGenes(), Renderer: "tree3d", Mutation: DefaultMutation}
+}
+
+// Tree3dSegments lays out a 3D tree. Each branch ends in num_branches
+// children, which turn about it by roll and then by phyllotaxis from one
+// sibling to the next, tilt away from it by pitch and swing sideways by
+// yaw. Lengths, radii and pitch change by their increase genes each
+// generation. The trunk starts at the origin with thickness as its radius.
+func Tree3dSegments(c *Creature) []Segment3 {
+	return tree3d_segments(c, int(c.GetValue("num_gens")))
+}
+
+// tree3d_segments lays out the first gens generations of c.
+func tree3d_segments(c *Creature, gens int) []Segment3 {
+	n := int(c.GetValue("num_branches"))
+	increase := c.GetValue("branch_increase")
+	pitch_increase := c.GetValue("pitch_increase")
+	yaw, roll, phyllotaxis := c.GetValue("yaw"), c.GetValue("roll"), c.GetValue("phyllotaxis")
+	var segments []Segment3
+	// heading, left and up are the branch's frame, as in a turtle.
+	var grow func(gen int, p Vec3, heading Vec3, left Vec3, length float64, radius float64, pitch float64)
+	grow = func(gen int, p Vec3, heading Vec3, left Vec3, length float64, radius float64, pitch float64) {
+		if gen == 0 {
+			return
+		}
+		end := p.Add(heading.Scale(length))
+		segments = append(segments, Segment3{p, end, radius})
+		for i := 0; i < n; i++ {
+			h, l := heading, rotate(left, heading, roll+phyllotaxis*float64(i))
+			h = rotate(h, l, pitch)
+			up := h.Cross(l)
+			h, l = rotate(h, up, yaw), rotate(l, up, yaw)
+			grow(gen-1, end, h, l, length*increase, radius*increase, pitch*pitch_increase)
+		}
+	}
+	grow(gens, Vec3{}, Vec3{0, 1, 0}, Vec3{-1, 0, 0}, c.GetValue("branch_length"), c.GetValue("thickness"), c.GetValue("pitch"))
+	return segments
+}
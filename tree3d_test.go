@@ -0,0 +1,56 @@
+package biomorph
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree3dSegments(t *testing.T) {
+	c := NewCreature(NewTree3dSpecies())
+	segments := Tree3dSegments(c)
+	// 1 + 3 + 9 + 27 branches over 4 generations.
+	assert.Len(t, segments, 40)
+	assert.Equal(t, Vec3{}, segments[0].A)
+	depth := 0.0
+	for _, s := range segments {
+		assert.True(t, s.Radius > 0)
+		depth = math.Max(depth, math.Abs(s.B.Z))
+	}
+	assert.True(t, depth > 1, "a 3D tree leaves the z = 0 plane")
+	assert.True(t, Is3d(c))
+	assert.False(t, Is3d(NewCreature(NewTreeSpecies())))
+}
+
+func TestCameraProject(t *testing.T) {
+	segments := []Segment3{{Vec3{0, 0, 0}, Vec3{0, 10, 0}, 1}, {Vec3{0, 10, 0}, Vec3{0, 10, 20}, 1}}
+	side := Project(segments, Camera{})
+	assert.InDelta(t, ImageSize/2, side[0].X1, 1e-9)
+	assert.InDelta(t, ImageSize*9/10-10, side[0].Y2, 1e-9)
+	assert.InDelta(t, side[1].X1, side[1].X2, 1e-9, "seen side on, a branch towards the camera is a point")
+	turned := Project(segments, Camera{Azimuth: math.Pi / 2})
+	assert.InDelta(t, 20, math.Abs(turned[1].X2-turned[1].X1), 1e-9)
+	posts := []Segment3{{Vec3{0, 0, 20}, Vec3{0, 10, 20}, 1}, {Vec3{0, 0, -20}, Vec3{0, 10, -20}, 1}}
+	flat := Project(posts, Camera{})
+	assert.InDelta(t, flat[0].Y1-flat[0].Y2, flat[1].Y1-flat[1].Y2, 1e-9)
+	deep := Project(posts, Camera{Distance: 100})
+	assert.True(t, deep[0].Y1-deep[0].Y2 > deep[1].Y1-deep[1].Y2, "the nearer post looks taller")
+}
+
+func TestTurntable(t *testing.T) {
+	c := RandomCreature(NewTree3dSpecies(), rand.New(rand.NewSource(1)))
+	frames := Turntable(c, RenderOptions{Size: 64, Camera: Camera{Elevation: 0.3}}, 6)
+	assert.Len(t, frames, 6)
+	for _, f := range frames {
+		assert.Equal(t, image.Rect(0, 0, 64, 64), f.Bounds())
+	}
+	assert.NotEqual(t, frames[0], frames[1])
+	for _, s := range Project(Tree3dSegments(c), Camera{Elevation: 0.3, Distance: 200}) {
+		for _, v := range []float64{s.X1, s.Y1, s.X2, s.Y2} {
+			assert.True(t, v >= 0 && v <= ImageSize, v)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package biomorph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrProgramLimit = errors.New("program limit exceeded")
+	errReturn       = errors.New("return")
+)
+
+// ProgramLimits sandbox a Program: a run stops with ErrProgramLimit once
+// calls nest deeper than MaxDepth, it has drawn MaxSegments or it has run
+// MaxSteps statements and loop iterations.
+type ProgramLimits struct {
+	MaxDepth    int
+	MaxSegments int
+	MaxSteps    int
+}
+
+var DefaultProgramLimits = ProgramLimits{MaxDepth: 64, MaxSegments: 20000, MaxSteps: 1000000}
+
+type turtle struct {
+	ctx           context.Context
+	x, y, heading float64
+	segments      []Segment
+	steps         int
+	limits        ProgramLimits
+	genes         map[string]float64
+	procs         map[string]*procedure
+}
+
+type frame struct {
+	vars  map[string]float64
+	depth int
+}
+
+func (t *turtle) limit(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrProgramLimit, fmt.Sprintf(format, args...))
+}
+
+// Run draws c with the program. On error it returns the segments drawn so
+// far along with it.
+func (p *Program) Run(c *Creature, limits ProgramLimits) ([]Segment, error) {
+	return p.RunContext(context.Background(), c, limits)
+}
+
+// RunContext is Run stopping early, with ctx's error, if ctx is done.
+func (p *Program) RunContext(ctx context.Context, c *Creature, limits ProgramLimits) ([]Segment, error) {
+	t := &turtle{ctx: ctx, x: ImageSize / 2, y: ImageSize * 9 / 10, limits: limits, genes: c.ValuesMap(), procs: p.procs}
+	err := t.call(p.procs["main"], nil, 0)
+	return t.segments, err
+}
+
+func (t *turtle) call(proc *procedure, args []float64, depth int) error {
+	if depth > t.limits.MaxDepth {
+		return t.limit("calls nested deeper than %d", t.limits.MaxDepth)
+	}
+	f := &frame{map[string]float64{}, depth}
+	for i, name := range proc.params {
+		f.vars[name] = args[i]
+	}
+	if err := t.exec(proc.body, f); err != nil && err != errReturn {
+		return err
+	}
+	return nil
+}
+
+// ctx_steps is how often a run checks whether its context is done, starting
+// with its first step.
+const ctx_steps = 1024
+
+// step counts one statement or loop iteration against MaxSteps.
+func (t *turtle) step() error {
+	t.steps++
+	if t.steps > t.limits.MaxSteps {
+		return t.limit("ran more than %d statements", t.limits.MaxSteps)
+	}
+	if t.steps%ctx_steps == 1 {
+		return t.ctx.Err()
+	}
+	return nil
+}
+
+func (t *turtle) exec(body []stmt, f *frame) error {
+	for _, s := range body {
+		if err := t.step(); err != nil {
+			return err
+		}
+		if err := s.exec(t, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type stmt interface {
+	exec(t *turtle, f *frame) error
+}
+
+type turtle_stmt struct {
+	op  string
+	x   expr
+	pos token
+}
+
+func (s *turtle_stmt) exec(t *turtle, f *frame) error {
+	v := s.x.eval(t, f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return &program_error{s.pos.line, s.pos.col, fmt.Sprintf("%s(%v)", s.op, v)}
+	}
+	if s.op == "turn" {
+		t.heading += v
+		return nil
+	}
+	nx, ny := t.x-v*math.Sin(t.heading), t.y-v*math.Cos(t.heading)
+	if s.op == "forward" {
+		if len(t.segments) >= t.limits.MaxSegments {
+			return t.limit("drew more than %d segments", t.limits.MaxSegments)
+		}
+		t.segments = append(t.segments, Segment{t.x, t.y, nx, ny})
+	}
+	t.x, t.y = nx, ny
+	return nil
+}
+
+type branch_stmt struct {
+	body []stmt
+}
+
+func (s *branch_stmt) exec(t *turtle, f *frame) error {
+	x, y, heading := t.x, t.y, t.heading
+	err := t.exec(s.body, f)
+	t.x, t.y, t.heading = x, y, heading
+	return err
+}
+
+type repeat_stmt struct {
+	count expr
+	body  []stmt
+	pos   token
+}
+
+// exec counts each iteration as a step, so an empty body still runs out of
+// MaxSteps.
+func (s *repeat_stmt) exec(t *turtle, f *frame) error {
+	v := s.count.eval(t, f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return &program_error{s.pos.line, s.pos.col, fmt.Sprintf("repeat(%v)", v)}
+	}
+	n := math.Floor(v)
+	outer, had_outer := f.vars["i"]
+	defer func() {
+		if had_outer {
+			f.vars["i"] = outer
+		} else {
+			delete(f.vars, "i")
+		}
+	}()
+	for i := 0.0; i < n; i++ {
+		if err := t.step(); err != nil {
+			return err
+		}
+		f.vars["i"] = i
+		if err := t.exec(s.body, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type if_stmt struct {
+	cond            expr
+	then, otherwise []stmt
+}
+
+func (s *if_stmt) exec(t *turtle, f *frame) error {
+	if s.cond.eval(t, f) != 0 {
+		return t.exec(s.then, f)
+	}
+	return t.exec(s.otherwise, f)
+}
+
+type let_stmt struct {
+	name string
+	x    expr
+}
+
+func (s *let_stmt) exec(t *turtle, f *frame) error {
+	f.vars[s.name] = s.x.eval(t, f)
+	return nil
+}
+
+type return_stmt struct{}
+
+func (return_stmt) exec(t *turtle, f *frame) error {
+	return errReturn
+}
+
+type call_arg struct {
+	name token
+	x    expr
+}
+
+type call_stmt struct {
+	name token
+	args []call_arg
+	proc *procedure
+	// order maps each parameter to the index of its argument.
+	order []int
+}
+
+// resolve binds the call to its procedure once every def has been parsed.
+func (s *call_stmt) resolve(p *program_parser) error {
+	proc, ok := p.procs[s.name.text]
+	if !ok {
+		return p.errorf(s.name, "unknown procedure %q", s.name.text)
+	}
+	if len(s.args) != len(proc.params) {
+		return p.errorf(s.name, "%s takes %d arguments, not %d", proc.name, len(proc.params), len(s.args))
+	}
+	s.proc = proc
+	s.order = make([]int, len(proc.params))
+	for i := range s.order {
+		s.order[i] = -1
+	}
+	named := false
+	for i, a := range s.args {
+		param := i
+		if a.name.text != "" {
+			named = true
+			param = -1
+			for j, name := range proc.params {
+				if name == a.name.text {
+					param = j
+				}
+			}
+			if param < 0 {
+				return p.errorf(a.name, "%s has no parameter %q", proc.name, a.name.text)
+			}
+		} else if named {
+			return p.errorf(s.name, "positional argument after a named one")
+		}
+		if s.order[param] >= 0 {
+			return p.errorf(s.name, "parameter %q given twice", proc.params[param])
+		}
+		s.order[param] = i
+	}
+	return nil
+}
+
+func (s *call_stmt) exec(t *turtle, f *frame) error {
+	args := make([]float64, len(s.order))
+	for param, i := range s.order {
+		args[param] = s.args[i].x.eval(t, f)
+	}
+	return t.call(s.proc, args, f.depth+1)
+}
+
+type expr interface {
+	eval(t *turtle, f *frame) float64
+}
+
+type number_expr float64
+
+func (x number_expr) eval(t *turtle, f *frame) float64 {
+	return float64(x)
+}
+
+type var_expr string
+
+func (x var_expr) eval(t *turtle, f *frame) float64 {
+	return f.vars[string(x)]
+}
+
+type gene_expr string
+
+func (x gene_expr) eval(t *turtle, f *frame) float64 {
+	return t.genes[string(x)]
+}
+
+type depth_expr struct{}
+
+func (depth_expr) eval(t *turtle, f *frame) float64 {
+	return float64(f.depth)
+}
+
+type func_expr struct {
+	f    func(args []float64) float64
+	args []expr
+}
+
+func (x *func_expr) eval(t *turtle, f *frame) float64 {
+	args := make([]float64, len(x.args))
+	for i, a := range x.args {
+		args[i] = a.eval(t, f)
+	}
+	return x.f(args)
+}
+
+type unary_expr struct {
+	op string
+	x  expr
+}
+
+func (x *unary_expr) eval(t *turtle, f *frame) float64 {
+	v := x.x.eval(t, f)
+	switch x.op {
+	case "-":
+		return -v
+	case "!":
+		return truth(v == 0)
+	}
+	return v
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type binary_expr struct {
+	op   string
+	x, y expr
+}
+
+func (x *binary_expr) eval(t *turtle, f *frame) float64 {
+	a := x.x.eval(t, f)
+	// && and || don't evaluate their right side when the left decides.
+	switch x.op {
+	case "&&":
+		return truth(a != 0 && x.y.eval(t, f) != 0)
+	case "||":
+		return truth(a != 0 || x.y.eval(t, f) != 0)
+	}
+	b := x.y.eval(t, f)
+	switch x.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return math.Mod(a, b)
+	case "^":
+		return math.Pow(a, b)
+	case "==":
+		return truth(a == b)
+	case "!=":
+		return truth(a != b)
+	case "<":
+		return truth(a < b)
+	case "<=":
+		return truth(a <= b)
+	case ">":
+		return truth(a > b)
+	case ">=":
+		return truth(a >= b)
+	}
+	panic("unknown operator " + x.op)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"image"
+	"log"
 
 	"github.com/google/gxui"
 	"github.com/google/gxui/drivers/gl"
@@ -21,7 +22,7 @@ func View(im image.Image, driver gxui.Driver) {
 
 func main() {
 	c := biomorph.NewCreature(biomorph.NewTreeSpecies())
-	c.SetValuesFromMap(map[string]float64{
+	err := c.SetValuesFromMap(map[string]float64{
 		"num_branches":    2,
 		"branch_length":   15,
 		"num_gens":        4,
@@ -29,7 +30,13 @@ func main() {
 		"branch_increase": 1.0,
 		"angle_increase":  1.0,
 	})
-	im := biomorph.DrawTreeCreature(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	im, err := biomorph.DrawTreeCreature(c)
+	if err != nil {
+		log.Print(err)
+	}
 	gl.StartDriver(func(driver gxui.Driver) {
 		View(im, driver)
 	})
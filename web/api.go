@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/jackdreilly/biomorph/events"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	api_prefix     = "/api/v1"
+	max_mutations  = 100
+	openapi_path   = "static/openapi.json"
+	default_format = "png"
+	max_genome     = 1 << 20
+)
+
+// ApiCreature is the JSON representation of a creature in the v1 API.
+type ApiCreature struct {
+	Id      uint64             `json:"id"`
+	Values  map[string]float64 `json:"values"`
+	Parents []uint64           `json:"parents"`
+	Image   string             `json:"image"`
+	// Code is the creature as a genome code; /g/{code} draws it.
+	Code string `json:"code"`
+}
+
+type ApiFeatures struct {
+	Id      uint64             `json:"id"`
+	Genes   int                `json:"genes"`
+	Fitness map[string]float64 `json:"fitness"`
+}
+
+type BreedRequest struct {
+	Parents []uint64 `json:"parents"`
+}
+
+// NewApiHandler serves the versioned JSON API described by openapi.json.
+func NewApiHandler() http.Handler {
+	mux := &router{
+		NotFound: func(w http.ResponseWriter, r *http.Request) {
+			write_error(w, status.Errorf(codes.NotFound, "no API route for %s %s", r.Method, r.URL.Path))
+		},
+		MethodNotAllowed: func(w http.ResponseWriter, r *http.Request) {
+			write_error(w, status.Errorf(codes.Unimplemented, "%s not allowed for %s, only %s", r.Method, r.URL.Path, w.Header().Get("Allow")))
+		},
+	}
+	mux.HandleFunc("GET "+api_prefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, openapi_path)
+	})
+	mux.HandleFunc("POST "+api_prefix+"/creatures", ApiCreateCreature)
+	mux.HandleFunc("POST "+api_prefix+"/creatures/breed", ApiBreedCreatures)
+	mux.HandleFunc("POST "+api_prefix+"/creatures/import", ApiImportGenome)
+	mux.HandleFunc("GET "+api_prefix+"/creatures/{id}", ApiGetCreature)
+	mux.HandleFunc("GET "+api_prefix+"/creatures/{id}/lineage", ApiGetLineage)
+	mux.HandleFunc("GET "+api_prefix+"/creatures/{id}/features", ApiGetFeatures)
+	mux.HandleFunc("GET "+api_prefix+"/creatures/{id}/image", ApiGetImage)
+	mux.HandleFunc("GET "+api_prefix+"/creatures/{id}/mesh", ApiGetMesh)
+	mux.HandleFunc("GET "+api_prefix+"/creatures/{id}/genome", ApiGetGenome)
+	mux.HandleFunc("POST "+api_prefix+"/creatures/{id}/mutations", ApiMutateCreature)
+	return mux
+}
+
+func path_id(r *http.Request) (uint64, error) {
+	v := path_value(r, "id")
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || id == 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid creature ID %q", v)
+	}
+	return id, nil
+}
+
+func api_creature(id uint64, c *biomorph.Creature, parents []uint64) ApiCreature {
+	if parents == nil {
+		parents = []uint64{}
+	}
+	return ApiCreature{id, c.ValuesMap(), parents, fmt.Sprintf("%s/creatures/%d/image", api_prefix, id), biomorph.EncodeCode(c)}
+}
+
+func write_json(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// with_creature loads the creature named by the {id} path segment, writing
+// the error response itself if that fails.
+func with_creature(w http.ResponseWriter, r *http.Request, f func(id uint64, c *biomorph.Creature, parents []uint64)) {
+	id, err := path_id(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	c, parents, err := GetCreature(id)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	f(id, c, parents)
+}
+
+func ApiGetCreature(w http.ResponseWriter, r *http.Request) {
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		write_json(w, http.StatusOK, api_creature(id, c, parents))
+	})
+}
+
+func ApiGetLineage(w http.ResponseWriter, r *http.Request) {
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		lineage := make([]ApiCreature, 0, len(parents)+1)
+		for _, pid := range parents {
+			pc, pparents, err := GetCreature(pid)
+			if err != nil {
+				write_error(w, err)
+				return
+			}
+			lineage = append(lineage, api_creature(pid, pc, pparents))
+		}
+		lineage = append(lineage, api_creature(id, c, parents))
+		write_json(w, http.StatusOK, lineage)
+	})
+}
+
+func ApiGetFeatures(w http.ResponseWriter, r *http.Request) {
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		features := ApiFeatures{id, len(c.Values), map[string]float64{}}
+		for name, f := range biomorph.Fitnesses {
+			features.Fitness[name] = f(c)
+		}
+		write_json(w, http.StatusOK, features)
+	})
+}
+
+func ApiGetImage(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = default_format
+	}
+	if _, ok := image_encoders[format]; !ok {
+		write_error(w, status.Errorf(codes.InvalidArgument, "unsupported image format %q", format))
+		return
+	}
+	opts, quality, err := parse_render_options(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		write_creature_image(w, r, c, format, opts, quality)
+	})
+}
+
+func ApiGetMesh(w http.ResponseWriter, r *http.Request) {
+	format, cells, err := parse_mesh(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		write_creature_mesh(w, r, id, c, format, cells)
+	})
+}
+
+func ApiCreateCreature(w http.ResponseWriter, r *http.Request) {
+	c, id, err := NewCreature()
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	write_json(w, http.StatusCreated, api_creature(id, c, nil))
+}
+
+func ApiMutateCreature(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > max_mutations {
+			write_error(w, status.Errorf(codes.InvalidArgument, "count must be between 1 and %d", max_mutations))
+			return
+		}
+		count = n
+	}
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		parents = append(parents, id)
+		mutants := make([]ApiCreature, count)
+		for i := range mutants {
+			nc := biomorph.MutateCreature(c)
+			nid, err := AddCreature(&value_map{nc.CreatureSpecies.Name, nc.ValuesMap(), parents})
+			if err != nil {
+				write_error(w, err)
+				return
+			}
+			record(events.NewEvent(events.Mutation, nid, parents, nc.ValuesMap()))
+			mutants[i] = api_creature(nid, nc, parents)
+		}
+		write_json(w, http.StatusCreated, mutants)
+	})
+}
+
+// ApiBreedCreatures crosses two creatures. The child's lineage follows the
+// first parent; the breed event records both.
+func ApiBreedCreatures(w http.ResponseWriter, r *http.Request) {
+	var req BreedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parents) != 2 {
+		write_error(w, status.Error(codes.InvalidArgument, "body must be {\"parents\": [id, id]}"))
+		return
+	}
+	a, a_parents, err := GetCreature(req.Parents[0])
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	b, _, err := GetCreature(req.Parents[1])
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	child, err := biomorph.BreedCreatures(a, b)
+	if err != nil {
+		write_error(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	parents := append(a_parents, req.Parents[0])
+	id, err := AddCreature(&value_map{child.CreatureSpecies.Name, child.ValuesMap(), parents})
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	record(events.NewEvent(events.Breed, id, req.Parents, child.ValuesMap()))
+	write_json(w, http.StatusCreated, api_creature(id, child, parents))
+}
+
+// ApiGetGenome exports a creature and its lineage as a genome file, JSON by
+// default or the text form with format=text.
+func ApiGetGenome(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		write_error(w, status.Errorf(codes.InvalidArgument, "unsupported genome format %q, want json or text", format))
+		return
+	}
+	with_creature(w, r, func(id uint64, c *biomorph.Creature, parents []uint64) {
+		lineage := make([]*biomorph.Creature, len(parents))
+		for i, pid := range parents {
+			pc, _, err := GetCreature(pid)
+			if err != nil {
+				write_error(w, err)
+				return
+			}
+			lineage[i] = pc
+		}
+		g := biomorph.NewGenome(c, lineage)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"creature-%d.genome\"", id))
+		if format == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			g.WriteText(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		g.WriteJson(w)
+	})
+}
+
+// ApiImportGenome saves a genome file, in either form, as a new creature.
+// Its lineage is saved too, as fresh creatures ending in the new one.
+func ApiImportGenome(w http.ResponseWriter, r *http.Request) {
+	g, err := biomorph.ReadGenome(http.MaxBytesReader(w, r.Body, max_genome))
+	if err != nil {
+		write_error(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	c, lineage, err := g.Creatures()
+	if err != nil {
+		write_error(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	parents := []uint64{}
+	for _, a := range lineage {
+		id, err := AddCreature(&value_map{a.CreatureSpecies.Name, a.ValuesMap(), parents})
+		if err != nil {
+			write_error(w, err)
+			return
+		}
+		parents = append(parents, id)
+	}
+	id, err := AddCreature(&value_map{c.CreatureSpecies.Name, c.ValuesMap(), parents})
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	write_json(w, http.StatusCreated, api_creature(id, c, parents))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/jackdreilly/biomorph/db/service"
+	"github.com/jackdreilly/biomorph/db/store"
+	"github.com/jackdreilly/biomorph/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type discard_sink struct{}
+
+func (discard_sink) Record(e events.Event) error { return nil }
+func (discard_sink) Close() error                { return nil }
+
+func api_server(t *testing.T) *httptest.Server {
+	client = service.NewLocalClient(service.NewServer(store.NewMemoryStore()))
+	sink = discard_sink{}
+	s := httptest.NewServer(NewApiHandler())
+	t.Cleanup(s.Close)
+	return s
+}
+
+func do_json(t *testing.T, method string, url string, body interface{}, want_code int, out interface{}) {
+	var b bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&b).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &b)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, want_code, resp.StatusCode, "%s %s", method, url)
+	if out != nil {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+}
+
+func TestApiCreatures(t *testing.T) {
+	s := api_server(t)
+	base := s.URL + api_prefix
+
+	var root ApiCreature
+	do_json(t, "POST", base+"/creatures", nil, http.StatusCreated, &root)
+	assert.NotZero(t, root.Id)
+	assert.Empty(t, root.Parents)
+
+	var got ApiCreature
+	do_json(t, "GET", base+"/creatures/1", nil, http.StatusOK, &got)
+	assert.Equal(t, root, got)
+
+	var mutants []ApiCreature
+	do_json(t, "POST", base+"/creatures/1/mutations?count=3", nil, http.StatusCreated, &mutants)
+	assert.Len(t, mutants, 3)
+	assert.Equal(t, []uint64{root.Id}, mutants[0].Parents)
+
+	var lineage []ApiCreature
+	do_json(t, "GET", base+"/creatures/2/lineage", nil, http.StatusOK, &lineage)
+	assert.Equal(t, []uint64{root.Id, mutants[0].Id}, []uint64{lineage[0].Id, lineage[1].Id})
+
+	var child ApiCreature
+	do_json(t, "POST", base+"/creatures/breed", BreedRequest{[]uint64{mutants[0].Id, mutants[1].Id}}, http.StatusCreated, &child)
+	assert.Equal(t, []uint64{root.Id, mutants[0].Id}, child.Parents)
+
+	var features ApiFeatures
+	do_json(t, "GET", base+"/creatures/1/features", nil, http.StatusOK, &features)
+	assert.Contains(t, features.Fitness, "ink")
+}
+
+func TestApiErrors(t *testing.T) {
+	s := api_server(t)
+	base := s.URL + api_prefix
+
+	var e ErrorResponse
+	do_json(t, "GET", base+"/creatures/42", nil, http.StatusNotFound, &e)
+	assert.Equal(t, "NotFound", e.Code)
+	do_json(t, "GET", base+"/creatures/abc", nil, http.StatusBadRequest, &e)
+	assert.Equal(t, "InvalidArgument", e.Code)
+	do_json(t, "POST", base+"/creatures", nil, http.StatusCreated, nil)
+	do_json(t, "POST", base+"/creatures/1/mutations?count=1000", nil, http.StatusBadRequest, nil)
+	do_json(t, "POST", base+"/creatures/breed", BreedRequest{[]uint64{1}}, http.StatusBadRequest, nil)
+
+	// A tree can't be bred with a tree3d, in either order.
+	tree3d := biomorph.NewCreature(biomorph.NewTree3dSpecies())
+	id, err := AddCreature(&value_map{tree3d.CreatureSpecies.Name, tree3d.ValuesMap(), []uint64{}})
+	assert.NoError(t, err)
+	for _, parents := range [][]uint64{{1, id}, {id, 1}} {
+		do_json(t, "POST", base+"/creatures/breed", BreedRequest{parents}, http.StatusBadRequest, &e)
+		assert.Equal(t, "InvalidArgument", e.Code)
+		assert.Contains(t, e.Error, "parents are species")
+	}
+	do_json(t, "GET", base+"/creatures/1/image?format=bmp", nil, http.StatusBadRequest, nil)
+	do_json(t, "GET", base+"/nothing", nil, http.StatusNotFound, nil)
+
+	// Known paths asked with the wrong method say which methods they take.
+	resp, err := http.Get(base + "/creatures/1/mutations")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&e))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "POST", resp.Header.Get("Allow"))
+	assert.Equal(t, "Unimplemented", e.Code)
+}
+
+func TestApiImage(t *testing.T) {
+	s := api_server(t)
+	base := s.URL + api_prefix
+	do_json(t, "POST", base+"/creatures", nil, http.StatusCreated, nil)
+	for format, content_type := range map[string]string{"png": "image/png", "gif": "image/gif", "jpeg": "image/jpeg"} {
+		resp, err := http.Get(base + "/creatures/1/image?format=" + format)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content_type, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestApiMesh(t *testing.T) {
+	s := api_server(t)
+	base := s.URL + api_prefix
+	do_json(t, "POST", base+"/creatures", nil, http.StatusCreated, nil)
+	for format, content_type := range mesh_content_types {
+		resp, err := http.Get(base + "/creatures/1/mesh?cells=16&format=" + format)
+		assert.NoError(t, err)
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content_type, resp.Header.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="creature-1.`+format+`"`, resp.Header.Get("Content-Disposition"))
+		assert.NotEmpty(t, b)
+	}
+	for _, bad := range []string{"?format=3mf", "?cells=2", "?cells=1000", "?cells=x"} {
+		do_json(t, "GET", base+"/creatures/1/mesh"+bad, nil, http.StatusBadRequest, nil)
+	}
+	do_json(t, "GET", base+"/creatures/42/mesh", nil, http.StatusNotFound, nil)
+}
+
+func TestApiOpenApi(t *testing.T) {
+	s := api_server(t)
+	var doc map[string]interface{}
+	do_json(t, "GET", s.URL+api_prefix+"/openapi.json", nil, http.StatusOK, &doc)
+	assert.Equal(t, "3.0.3", doc["openapi"])
+}
+
+func TestApiGenome(t *testing.T) {
+	s := api_server(t)
+	base := s.URL + api_prefix
+
+	var root ApiCreature
+	do_json(t, "POST", base+"/creatures", nil, http.StatusCreated, &root)
+	var mutants []ApiCreature
+	do_json(t, "POST", base+"/creatures/1/mutations", nil, http.StatusCreated, &mutants)
+
+	resp, err := http.Get(base + "/creatures/2/genome?format=text")
+	assert.NoError(t, err)
+	g, err := biomorph.ReadGenome(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Len(t, g.Lineage, 1)
+
+	var buff bytes.Buffer
+	assert.NoError(t, g.WriteText(&buff))
+	resp, err = http.Post(base+"/creatures/import", "text/plain", &buff)
+	assert.NoError(t, err)
+	var imported ApiCreature
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&imported))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, mutants[0].Values, imported.Values)
+	assert.Len(t, imported.Parents, 1)
+
+	var e ErrorResponse
+	g.Genes[0].Value = g.Genes[0].Max * 2
+	do_json(t, "POST", base+"/creatures/import", g, http.StatusBadRequest, &e)
+	assert.Contains(t, e.Error, "outside")
+	do_json(t, "GET", base+"/creatures/1/genome?format=yaml", nil, http.StatusBadRequest, &e)
+}
+
+func TestApiImportSpecies(t *testing.T) {
+	s := api_server(t)
+	base := s.URL + api_prefix
+	species, err := biomorph.LoadSpeciesDir("../species")
+	assert.NoError(t, err)
+	biomorph.SetConfiguredSpecies(species)
+	defer biomorph.SetConfiguredSpecies(nil)
+
+	// A fern imported into a tree server stays a fern.
+	fern, err := biomorph.GetSpecies("fern")
+	assert.NoError(t, err)
+	c := biomorph.NewCreature(fern)
+	var imported ApiCreature
+	do_json(t, "POST", base+"/creatures/import", biomorph.NewGenome(c, nil), http.StatusCreated, &imported)
+	assert.Equal(t, c.ValuesMap(), imported.Values)
+
+	resp, err := http.Get(fmt.Sprintf("%s/creatures/%d/genome", base, imported.Id))
+	assert.NoError(t, err)
+	g, err := biomorph.ReadGenome(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "fern", g.Species)
+
+	// Once fern is unloaded the creature can't be shown as any other species.
+	biomorph.SetConfiguredSpecies(nil)
+	var e ErrorResponse
+	do_json(t, "GET", fmt.Sprintf("%s/creatures/%d", base, imported.Id), nil, http.StatusConflict, &e)
+	assert.Equal(t, "FailedPrecondition", e.Code)
+}
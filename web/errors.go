@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorResponse is the JSON body sent with every failed request.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+var http_statuses = map[codes.Code]int{
+	codes.NotFound:           http.StatusNotFound,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.Canceled:           http.StatusServiceUnavailable,
+	codes.Unimplemented:      http.StatusMethodNotAllowed,
+}
+
+// HttpStatus maps an error from the db client to an HTTP status code.
+func HttpStatus(err error) int {
+	if s, ok := http_statuses[status.Code(err)]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+func write_error(w http.ResponseWriter, err error) {
+	code := HttpStatus(err)
+	if code == http.StatusInternalServerError {
+		log.Printf("internal error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorResponse{status.Convert(err).Message(), status.Code(err).String()})
+}
+
+// parse_id reads the creature ID from the id query parameter.
+func parse_id(r *http.Request) (uint64, error) {
+	v := r.URL.Query().Get("id")
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || id == 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid creature ID %q", v)
+	}
+	return id, nil
+}
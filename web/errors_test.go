@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHttpStatus(t *testing.T) {
+	for err, want := range map[error]int{
+		status.Error(codes.NotFound, "x"):           http.StatusNotFound,
+		status.Error(codes.InvalidArgument, "x"):    http.StatusBadRequest,
+		status.Error(codes.FailedPrecondition, "x"): http.StatusConflict,
+		status.Error(codes.Unavailable, "x"):        http.StatusServiceUnavailable,
+		status.Error(codes.DeadlineExceeded, "x"):   http.StatusGatewayTimeout,
+		status.Error(codes.Canceled, "x"):           http.StatusServiceUnavailable,
+		status.Error(codes.Unimplemented, "x"):      http.StatusMethodNotAllowed,
+		status.Error(codes.Internal, "x"):           http.StatusInternalServerError,
+		errors.New("x"):                             http.StatusInternalServerError,
+	} {
+		assert.Equal(t, want, HttpStatus(err), err.Error())
+	}
+}
+
+func TestLegacyHandlerErrors(t *testing.T) {
+	api_server(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get_image", GetImage)
+	mux.HandleFunc("/mutate_image", MutateImage)
+	mux.HandleFunc("/choose_image", ChooseImage)
+	mux.HandleFunc("/evolve", EvolveProgress)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	for _, tc := range []struct {
+		query string
+		want  int
+		code  codes.Code
+	}{
+		{"", http.StatusBadRequest, codes.InvalidArgument},
+		{"?id=", http.StatusBadRequest, codes.InvalidArgument},
+		{"?id=x", http.StatusBadRequest, codes.InvalidArgument},
+		{"?id=-1", http.StatusBadRequest, codes.InvalidArgument},
+		{"?id=0", http.StatusBadRequest, codes.InvalidArgument},
+		{"?id=999", http.StatusNotFound, codes.NotFound},
+	} {
+		for _, path := range []string{"/get_image", "/mutate_image", "/choose_image", "/evolve"} {
+			if path == "/evolve" && (tc.query == "" || tc.query == "?id=") {
+				// Evolution starts from a new creature without an ID.
+				continue
+			}
+			url := path + tc.query
+			resp, err := http.Get(s.URL + url)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if path == "/evolve" && tc.code == codes.NotFound {
+				// The stream has started by the time the ID is looked up.
+				assert.Equal(t, http.StatusOK, resp.StatusCode, url)
+				assert.True(t, strings.HasPrefix(string(body), "event: failed\n"), url)
+				assert.Contains(t, string(body), `"code":"NotFound"`, url)
+				continue
+			}
+			assert.Equal(t, tc.want, resp.StatusCode, url)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), url)
+			var e ErrorResponse
+			if assert.NoError(t, json.Unmarshal(body, &e), url) {
+				assert.Equal(t, tc.code.String(), e.Code, url)
+				assert.NotEmpty(t, e.Error, url)
+			}
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/jackdreilly/biomorph/animate"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	min_image_size = 16
+	max_image_size = 1024
+	// image_cache is sent with every image. A creature's genes never change
+	// but its species' definition can be reloaded, so clients revalidate
+	// against the ETag.
+	image_cache = "public, no-cache"
+)
+
+type image_encoder struct {
+	content_type string
+	encode       func(w io.Writer, img image.Image) error
+}
+
+var image_encoders = map[string]image_encoder{
+	"png": {"image/png", func(w io.Writer, img image.Image) error { return png.Encode(w, img) }},
+	"gif": {"image/gif", func(w io.Writer, img image.Image) error {
+		return gif.Encode(w, img, &gif.Options{NumColors: 2, Quantizer: &my_quant{}})
+	}},
+	"jpeg": {"image/jpeg", func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }},
+}
+
+type my_quant struct{}
+
+func (m *my_quant) Quantize(p color.Palette, _ image.Image) color.Palette {
+	return []color.Color{
+		color.White,
+		color.Black,
+	}
+}
+
+func CreatureImageUrl(id uint64) string {
+	return fmt.Sprintf("/creature/%d.png", id)
+}
+
+func LineageGifUrl(id uint64) string {
+	return fmt.Sprintf("/lineage/%d.gif", id)
+}
+
+// parse_image_name splits "12.png" into its creature ID and format. The
+// format query parameter overrides the extension.
+func parse_image_name(r *http.Request, name string) (uint64, string, error) {
+	ext := path.Ext(name)
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil || id == 0 {
+		return 0, "", status.Errorf(codes.InvalidArgument, "invalid creature image %q", name)
+	}
+	format, err := parse_image_format(r, ext)
+	return id, format, err
+}
+
+// parse_image_format picks the format from the extension, which may be
+// empty for PNG, or the format query parameter.
+func parse_image_format(r *http.Request, ext string) (string, error) {
+	format := strings.TrimPrefix(ext, ".")
+	if f := r.URL.Query().Get("format"); f != "" {
+		format = f
+	}
+	if format == "" {
+		format = default_format
+	}
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if _, ok := image_encoders[format]; !ok {
+		return "", status.Errorf(codes.InvalidArgument, "unsupported image format %q", format)
+	}
+	return format, nil
+}
+
+func parse_size(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("size")
+	if v == "" {
+		return biomorph.ImageSize, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < min_image_size || size > max_image_size {
+		return 0, status.Errorf(codes.InvalidArgument, "size must be between %d and %d", min_image_size, max_image_size)
+	}
+	return size, nil
+}
+
+// parse_render_options reads size and the render quality from the query:
+// supersample, aliased, gray, cap and join. It also returns a key of the
+// quality for ETags.
+func parse_render_options(r *http.Request) (biomorph.RenderOptions, string, error) {
+	size, err := parse_size(r)
+	if err != nil {
+		return biomorph.RenderOptions{}, "", err
+	}
+	opts := biomorph.RenderOptions{Size: size, Budget: biomorph.DefaultRenderBudget}
+	q := r.URL.Query()
+	if v := q.Get("supersample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > biomorph.MaxSupersample {
+			return opts, "", status.Errorf(codes.InvalidArgument, "supersample must be between 1 and %d", biomorph.MaxSupersample)
+		}
+		opts.Supersample = n
+	}
+	opts.Aliased, opts.Gray = query_bool(r, "aliased"), query_bool(r, "gray")
+	if opts.Cap, err = biomorph.ParseLineCap(q.Get("cap")); err != nil {
+		return opts, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	if opts.Join, err = biomorph.ParseLineJoin(q.Get("join")); err != nil {
+		return opts, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return opts, fmt.Sprintf("%d|%v|%v|%s|%s", opts.Supersample, opts.Aliased, opts.Gray, opts.Cap, opts.Join), nil
+}
+
+// image_etag identifies a rendering by everything that goes into it: each
+// creature's species name plus its RenderKey, which covers the species'
+// renderer, program and L-system and the genes. So it stays valid across
+// creature IDs with identical genes and changes when a species is reloaded.
+func image_etag(kind string, creatures []*biomorph.Creature, size int, format string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d|%s", kind, size, format)
+	for _, c := range creatures {
+		fmt.Fprintf(h, "|%s=%s;", c.CreatureSpecies.Name, biomorph.RenderKey(c, biomorph.RenderOptions{}))
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum64())
+}
+
+// not_modified answers a conditional request for etag, returning true if the
+// client's copy is current and nothing more should be written.
+func not_modified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") != etag {
+		return false
+	}
+	w.Header().Set("Cache-Control", image_cache)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func write_cacheable(w http.ResponseWriter, content_type string, b []byte) {
+	w.Header().Set("Cache-Control", image_cache)
+	w.Header().Set("Content-Type", content_type)
+	w.Write(b)
+}
+
+func write_creature_image(w http.ResponseWriter, r *http.Request, c *biomorph.Creature, format string, opts biomorph.RenderOptions, quality string) {
+	if not_modified(w, r, image_etag("creature|"+quality, []*biomorph.Creature{c}, opts.Size, format)) {
+		return
+	}
+	enc := image_encoders[format]
+	img, render_err := render_cache.RenderContext(r.Context(), c, opts)
+	var buff bytes.Buffer
+	if err := enc.encode(&buff, img); err != nil {
+		write_error(w, err)
+		return
+	}
+	if render_err != nil {
+		write_incomplete(w, enc.content_type, buff.Bytes(), render_err)
+		return
+	}
+	write_cacheable(w, enc.content_type, buff.Bytes())
+}
+
+// write_incomplete serves an image cut short by its render budget, saying
+// why in X-Render-Incomplete. It isn't cached, so a later request can draw
+// more of it.
+func write_incomplete(w http.ResponseWriter, content_type string, b []byte, err error) {
+	w.Header().Del("ETag")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", content_type)
+	w.Header().Set("X-Render-Incomplete", err.Error())
+	w.Write(b)
+}
+
+// NewImageHandler serves the image routes under /creature/, /lineage/ and
+// /g/.
+func NewImageHandler() http.Handler {
+	mux := &router{}
+	mux.HandleFunc("GET /creature/{name}", CreatureImage)
+	mux.HandleFunc("GET /lineage/{name}", LineageAnimation)
+	mux.HandleFunc("GET /g/{code}", CodeImage)
+	return mux
+}
+
+// CreatureImage serves /creature/{id}.{png,gif,jpeg}?size=N, with the
+// quality options of parse_render_options.
+func CreatureImage(w http.ResponseWriter, r *http.Request) {
+	id, format, err := parse_image_name(r, path_value(r, "name"))
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	opts, quality, err := parse_render_options(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	c, _, err := GetCreature(id)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	write_creature_image(w, r, c, format, opts, quality)
+}
+
+func CodeImageUrl(code string) string {
+	return "/g/" + code
+}
+
+// CodeImage serves /g/{code}, optionally with an image extension, drawing
+// the creature packed in a genome code without touching the db.
+func CodeImage(w http.ResponseWriter, r *http.Request) {
+	name := path_value(r, "code")
+	ext := path.Ext(name)
+	c, err := biomorph.DecodeCode(strings.TrimSuffix(name, ext))
+	if err != nil {
+		write_error(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	format, err := parse_image_format(r, ext)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	opts, quality, err := parse_render_options(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	write_creature_image(w, r, c, format, opts, quality)
+}
+
+// mesh_content_types are the MIME types of biomorph.MeshFormats.
+var mesh_content_types = map[string]string{
+	"stl": "model/stl",
+	"obj": "model/obj",
+	"ply": "application/octet-stream",
+}
+
+const (
+	min_mesh_cells = 8
+	// max_mesh_cells bounds the sampling grid, which takes memory by its
+	// cube.
+	max_mesh_cells = 128
+)
+
+// parse_mesh reads format (stl, obj or ply) and cells, the grid size, from
+// the query.
+func parse_mesh(r *http.Request) (string, int, error) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "stl"
+	}
+	if _, ok := mesh_content_types[format]; !ok {
+		return "", 0, status.Errorf(codes.InvalidArgument, "unsupported mesh format %q", format)
+	}
+	cells := biomorph.DefaultMeshCells
+	if v := q.Get("cells"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min_mesh_cells || n > max_mesh_cells {
+			return "", 0, status.Errorf(codes.InvalidArgument, "cells must be between %d and %d", min_mesh_cells, max_mesh_cells)
+		}
+		cells = n
+	}
+	return format, cells, nil
+}
+
+// write_creature_mesh serves c as a watertight mesh to download and print.
+func write_creature_mesh(w http.ResponseWriter, r *http.Request, id uint64, c *biomorph.Creature, format string, cells int) {
+	if not_modified(w, r, image_etag(fmt.Sprintf("mesh|%d", cells), []*biomorph.Creature{c}, 0, format)) {
+		return
+	}
+	var buff bytes.Buffer
+	if err := biomorph.CreatureMesh(c, cells).Write(&buff, format); err != nil {
+		write_error(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"creature-%d.%s\"", id, format))
+	write_cacheable(w, mesh_content_types[format], buff.Bytes())
+}
+
+type animation_encoder struct {
+	content_type string
+	encode       func(w io.Writer, frames []animate.Frame, opts animate.Options) error
+}
+
+var animation_encoders = map[string]animation_encoder{
+	".gif": {"image/gif", animate.EncodeGif},
+	".png": {"image/apng", animate.EncodeApng},
+}
+
+func query_bool(r *http.Request, key string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return v
+}
+
+// parse_animation_options reads delay, hold and loop (hundredths of a second
+// and image/gif loop count), palette and labels from the query. It also
+// returns a key of the options for ETags.
+func parse_animation_options(r *http.Request) (animate.Options, string, error) {
+	opts := animate.DefaultOptions()
+	q := r.URL.Query()
+	for key, dst := range map[string]*int{"delay": &opts.Delay, "hold": &opts.Hold, "loop": &opts.LoopCount} {
+		if v := q.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < -1 || n > 10000 {
+				return opts, "", status.Errorf(codes.InvalidArgument, "invalid %s %q", key, v)
+			}
+			*dst = n
+		}
+	}
+	palette_name := q.Get("palette")
+	if palette_name == "" {
+		palette_name = "mono"
+	}
+	p, err := animate.GetPalette(palette_name)
+	if err != nil {
+		return opts, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts.Palette = p
+	opts.Labels = query_bool(r, "labels")
+	return opts, fmt.Sprintf("%d|%d|%d|%s|%v", opts.Delay, opts.Hold, opts.LoopCount, palette_name, opts.Labels), nil
+}
+
+const max_morph_steps = 60
+
+// parse_morph reads morph, the number of in-between frames per generation,
+// and interp (linear or spline) from the query.
+func parse_morph(r *http.Request) (int, biomorph.Interpolation, error) {
+	q := r.URL.Query()
+	steps := 0
+	if v := q.Get("morph"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > max_morph_steps {
+			return 0, biomorph.Linear, status.Errorf(codes.InvalidArgument, "invalid morph %q, want 0 to %d", v, max_morph_steps)
+		}
+		steps = n
+	}
+	interp, err := biomorph.ParseInterpolation(q.Get("interp"))
+	if err != nil {
+		return 0, biomorph.Linear, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return steps, interp, nil
+}
+
+// LineageFrames renders creatures in order, labelling each with its ID and
+// position in the lineage. With steps > 0 the genes are interpolated to add
+// that many in-between frames per generation, which carry the label of the
+// generation they leave. In-between frames skip the render cache so they
+// don't evict creatures that are shown on their own. Frames that don't fit
+// the render budget are drawn as far as they get, and the first such error
+// is returned along with the frames.
+func LineageFrames(ctx context.Context, ids []uint64, creatures []*biomorph.Creature, size int, steps int, interp biomorph.Interpolation) ([]animate.Frame, error) {
+	morphed := biomorph.Morph(creatures, steps, interp)
+	opts := biomorph.RenderOptions{Size: size, Budget: biomorph.DefaultRenderBudget}
+	frames := make([]animate.Frame, len(morphed))
+	var first_err error
+	for i, c := range morphed {
+		gen := i / (steps + 1)
+		var img image.Image
+		var err error
+		if i%(steps+1) == 0 {
+			img, err = render_cache.RenderContext(ctx, c, opts)
+		} else {
+			img, err = biomorph.RenderContext(ctx, c, opts)
+		}
+		if first_err == nil {
+			first_err = err
+		}
+		frames[i] = animate.Frame{Image: img, Label: animate.LineageLabel(ids[gen], gen)}
+	}
+	return frames, first_err
+}
+
+// LineageAnimation serves /lineage/{id}.gif and /lineage/{id}.png (APNG),
+// the animation from the root ancestor down to the creature. Add morph=N to
+// blend smoothly between generations.
+func LineageAnimation(w http.ResponseWriter, r *http.Request) {
+	name := path_value(r, "name")
+	ext := path.Ext(name)
+	enc, ok := animation_encoders[ext]
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil || id == 0 || !ok {
+		write_error(w, status.Errorf(codes.InvalidArgument, "invalid lineage animation %q", name))
+		return
+	}
+	size, err := parse_size(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	opts, opts_key, err := parse_animation_options(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	steps, interp, err := parse_morph(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	_, parents, err := GetCreature(id)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	ids := append(parents, id)
+	creatures := make([]*biomorph.Creature, 0, len(ids))
+	for _, cid := range ids {
+		c, _, err := GetCreature(cid)
+		if err != nil {
+			write_error(w, err)
+			return
+		}
+		creatures = append(creatures, c)
+	}
+	if not_modified(w, r, image_etag(fmt.Sprintf("lineage|%v|%s|%d|%d", ids, opts_key, steps, interp), creatures, size, ext)) {
+		return
+	}
+	frames, render_err := LineageFrames(r.Context(), ids, creatures, size, steps, interp)
+	var buff bytes.Buffer
+	if err := enc.encode(&buff, frames, opts); err != nil {
+		write_error(w, err)
+		return
+	}
+	if render_err != nil {
+		write_incomplete(w, enc.content_type, buff.Bytes(), render_err)
+		return
+	}
+	write_cacheable(w, enc.content_type, buff.Bytes())
+}
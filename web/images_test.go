@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatureImageCaching(t *testing.T) {
+	api_server(t)
+	_, id, err := NewCreature()
+	assert.NoError(t, err)
+	s := httptest.NewServer(NewImageHandler())
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + CreatureImageUrl(id) + "?size=300")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, image_cache, resp.Header.Get("Cache-Control"))
+	img, err := png.Decode(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	req, _ := http.NewRequest("GET", s.URL+CreatureImageUrl(id)+"?size=300", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, err = http.Get(s.URL + LineageGifUrl(id))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/gif", resp.Header.Get("Content-Type"))
+
+	resp, err = http.Get(s.URL + fmt.Sprintf("/lineage/%d.png?delay=10&hold=0&loop=-1&palette=gray&labels=true", id))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/apng", resp.Header.Get("Content-Type"))
+
+	resp, err = http.Get(s.URL + fmt.Sprintf("/lineage/%d.gif?morph=4&interp=spline", id))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(s.URL + CreatureImageUrl(id) + "?size=40&supersample=2&aliased=true&cap=butt&join=bevel")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	img, err = png.Decode(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 40, img.Bounds().Dx())
+
+	for _, bad := range []string{"/creature/x.png", "/creature/1.bmp", "/creature/1.png?size=5", "/creature/1.png?supersample=9", "/creature/1.png?cap=pointy", "/creature/1.png?join=miter", "/lineage/1.jpeg", "/lineage/1.gif?palette=neon", "/lineage/1.gif?delay=x", "/lineage/1.gif?morph=500", "/lineage/1.gif?interp=cubic"} {
+		resp, err = http.Get(s.URL + bad)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, bad)
+	}
+}
+
+func TestCodeImage(t *testing.T) {
+	api_server(t)
+	s := httptest.NewServer(NewImageHandler())
+	defer s.Close()
+
+	code := biomorph.EncodeCode(biomorph.NewCreature(biomorph.NewTreeSpecies()))
+	for url, content_type := range map[string]string{
+		CodeImageUrl(code):                    "image/png",
+		CodeImageUrl(code) + ".gif":           "image/gif",
+		CodeImageUrl(code) + "?format=jpeg":   "image/jpeg",
+		CodeImageUrl(strings.ToUpper(code)):   "image/png",
+		CodeImageUrl(code) + ".png?size=1024": "image/png",
+	} {
+		resp, err := http.Get(s.URL + url)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, url)
+		assert.Equal(t, content_type, resp.Header.Get("Content-Type"), url)
+	}
+	resp, err := http.Get(s.URL + CodeImageUrl(code))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("X-Render-Incomplete"))
+
+	// The biggest tree has more branches than this budget.
+	defer func(b biomorph.RenderBudget) { biomorph.DefaultRenderBudget = b }(biomorph.DefaultRenderBudget)
+	biomorph.DefaultRenderBudget = biomorph.RenderBudget{MaxSegments: 1000}
+	huge := biomorph.NewCreature(biomorph.NewTreeSpecies())
+	assert.NoError(t, huge.SetValuesFromMap(map[string]float64{"num_gens": 5, "num_branches": 9}))
+	resp, err = http.Get(s.URL + CodeImageUrl(biomorph.EncodeCode(huge)))
+	assert.NoError(t, err)
+	_, err = png.Decode(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("X-Render-Incomplete"), "render budget exceeded")
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("ETag"))
+
+	// So does a lineage animation with it in.
+	id, err := AddCreature(&value_map{huge.CreatureSpecies.Name, huge.ValuesMap(), []uint64{}})
+	assert.NoError(t, err)
+	resp, err = http.Get(s.URL + LineageGifUrl(id))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("X-Render-Incomplete"), "render budget exceeded")
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+
+	for _, bad := range []string{"/g/nonsense", "/g/" + code[:8], "/g/" + code + ".bmp"} {
+		resp, err := http.Get(s.URL + bad)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, bad)
+	}
+}
+
+func TestImageEtag(t *testing.T) {
+	c := biomorph.NewCreature(biomorph.NewTreeSpecies())
+	etag := image_etag("creature", []*biomorph.Creature{c}, 300, "png")
+	same := biomorph.NewCreature(biomorph.NewTreeSpecies())
+	same.SetValuesFromMap(c.ValuesMap())
+	assert.Equal(t, etag, image_etag("creature", []*biomorph.Creature{same}, 300, "png"))
+
+	// The same genes under another species name or renderer draw differently.
+	renamed := biomorph.NewCreature(biomorph.NewSpecies("shrub", biomorph.TreeGenes()))
+	renamed.SetValuesFromMap(c.ValuesMap())
+	assert.NotEqual(t, etag, image_etag("creature", []*biomorph.Creature{renamed}, 300, "png"))
+	redrawn := biomorph.NewCreature(biomorph.NewTreeSpecies())
+	redrawn.CreatureSpecies.Renderer = "segmented"
+	redrawn.SetValuesFromMap(c.ValuesMap())
+	assert.NotEqual(t, etag, image_etag("creature", []*biomorph.Creature{redrawn}, 300, "png"))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// router matches patterns like "GET /creatures/{id}/image", where a {name}
+// segment matches any one non-empty path segment, read back with
+// path_value. It does what the Go 1.22 ServeMux patterns do for these
+// routes, but also when GODEBUG=httpmuxgo121=1 makes ServeMux treat them as
+// literal paths, as it does by default outside a go 1.22 module. GET
+// routes also answer HEAD. Requests whose path only matches routes of other
+// methods go to MethodNotAllowed with an Allow header listing them; others
+// no route matches go to NotFound.
+type router struct {
+	routes           []route
+	NotFound         http.HandlerFunc
+	MethodNotAllowed http.HandlerFunc
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  http.HandlerFunc
+}
+
+type path_values_key struct{}
+
+func (rt *router) HandleFunc(pattern string, handler http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	rt.routes = append(rt.routes, route{method, strings.Split(strings.TrimPrefix(path, "/"), "/"), handler})
+}
+
+// match returns the wildcard values of path if it matches the route.
+func (rt *route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if path[i] == "" {
+				return nil, false
+			}
+			values[s[1:len(s)-1]] = path[i]
+		} else if s != path[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	allowed := map[string]bool{}
+	for i := range rt.routes {
+		route := &rt.routes[i]
+		values, ok := route.match(path)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method && !(route.method == http.MethodGet && r.Method == http.MethodHead) {
+			allowed[route.method] = true
+			allowed[http.MethodHead] = allowed[http.MethodHead] || route.method == http.MethodGet
+			continue
+		}
+		route.handler(w, r.WithContext(context.WithValue(r.Context(), path_values_key{}, values)))
+		return
+	}
+	var allow []string
+	for method, ok := range allowed {
+		if ok {
+			allow = append(allow, method)
+		}
+	}
+	if len(allow) > 0 {
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		if rt.MethodNotAllowed != nil {
+			rt.MethodNotAllowed(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if rt.NotFound != nil {
+		rt.NotFound(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// path_value is the path segment matched by the {name} wildcard of the
+// request's route, or "" if there is none.
+func path_value(r *http.Request, name string) string {
+	values, _ := r.Context().Value(path_values_key{}).(map[string]string)
+	return values[name]
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	rt := &router{NotFound: func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}}
+	var got string
+	rt.HandleFunc("GET /a/{id}", func(w http.ResponseWriter, r *http.Request) { got = "get a " + path_value(r, "id") })
+	rt.HandleFunc("POST /a/{id}", func(w http.ResponseWriter, r *http.Request) { got = "post a " + path_value(r, "id") })
+	rt.HandleFunc("GET /a/{id}/b", func(w http.ResponseWriter, r *http.Request) { got = "get b " + path_value(r, "id") })
+
+	for _, tc := range []struct {
+		method, path, want string
+	}{
+		{"GET", "/a/7", "get a 7"},
+		{"HEAD", "/a/7", "get a 7"},
+		{"POST", "/a/7", "post a 7"},
+		{"GET", "/a/7/b", "get b 7"},
+		{"GET", "/a/", ""},
+		{"GET", "/a/7/", ""},
+		{"GET", "/a/7/c", ""},
+		{"DELETE", "/a/7", ""},
+		{"POST", "/a/7/b", ""},
+	} {
+		got = ""
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		assert.Equal(t, tc.want, got, "%s %s", tc.method, tc.path)
+		if tc.want == "" && w.Header().Get("Allow") == "" {
+			assert.Equal(t, http.StatusTeapot, w.Code, "%s %s", tc.method, tc.path)
+		}
+	}
+
+	// A path routed for other methods is answered with the ones it has.
+	for path, allow := range map[string]string{"/a/7": "GET, HEAD, POST", "/a/7/b": "GET, HEAD"} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("DELETE", path, nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, path)
+		assert.Equal(t, allow, w.Header().Get("Allow"), path)
+	}
+}
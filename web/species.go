@@ -0,0 +1,149 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackdreilly/biomorph"
+)
+
+var (
+	species_name   = flag.String("species", "tree", "species of the creatures shown")
+	species_dir    = flag.String("species_dir", "", "directory of species definition files (.yaml, .yml, .json), reloaded when they change")
+	species_reload = flag.Duration("species_reload", 2*time.Second, "how often to check -species_dir for changes")
+
+	species_mu     sync.Mutex
+	species_status SpeciesStatus
+)
+
+// SpeciesStatus is the outcome of the last species load, published on
+// /debug/vars so a broken definition file is easy to spot.
+type SpeciesStatus struct {
+	Species []string  `json:"species"`
+	Loaded  time.Time `json:"loaded"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func init() {
+	expvar.Publish("species", expvar.Func(func() interface{} {
+		species_mu.Lock()
+		defer species_mu.Unlock()
+		return species_status
+	}))
+}
+
+// current_species is the species creatures are shown as. Reloads that would
+// drop it are refused, so it always exists after init_species.
+func current_species() *biomorph.Species {
+	s, err := biomorph.GetSpecies(*species_name)
+	if err != nil {
+		log.Printf("%v, falling back to tree", err)
+		return biomorph.NewTreeSpecies()
+	}
+	return s
+}
+
+// load_species installs the definitions in dir if they are all valid,
+// otherwise keeping the current ones.
+func load_species(dir string) error {
+	species, err := biomorph.LoadSpeciesDir(dir)
+	if err == nil {
+		err = check_species(species)
+	}
+	species_mu.Lock()
+	defer species_mu.Unlock()
+	if err != nil {
+		species_status.Error = err.Error()
+		return err
+	}
+	biomorph.SetConfiguredSpecies(species)
+	species_status.Species = biomorph.SpeciesNames()
+	species_status.Loaded = time.Now()
+	species_status.Error = ""
+	return nil
+}
+
+func check_species(species []*biomorph.Species) error {
+	for _, s := range species {
+		if s.Name == *species_name {
+			return nil
+		}
+	}
+	if *species_name == "tree" {
+		return nil
+	}
+	return fmt.Errorf("species %s is not defined", *species_name)
+}
+
+// species_signature changes whenever a definition file is added, removed or
+// modified.
+func species_signature(dir string) (string, error) {
+	files, err := biomorph.SpeciesFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	sig := ""
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		sig += fmt.Sprintf("%s|%d|%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return sig, nil
+}
+
+// watch_species polls dir every interval and reloads it when it changes,
+// until stop is closed.
+func watch_species(dir string, interval time.Duration, last string, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		sig, err := species_signature(dir)
+		if err != nil {
+			log.Printf("failed to check species in %s: %v", dir, err)
+			continue
+		}
+		if sig == last {
+			continue
+		}
+		last = sig
+		if err := load_species(dir); err != nil {
+			log.Printf("kept the previous species, failed to reload %s:\n%v", dir, err)
+			continue
+		}
+		log.Printf("reloaded species from %s: %v", dir, biomorph.SpeciesNames())
+	}
+}
+
+// init_species loads -species_dir, if set, and starts watching it. The
+// returned function stops watching.
+func init_species() (func(), error) {
+	stop := make(chan struct{})
+	stopper := func() { close(stop) }
+	if *species_dir == "" {
+		_, err := biomorph.GetSpecies(*species_name)
+		return stopper, err
+	}
+	sig, err := species_signature(*species_dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := load_species(*species_dir); err != nil {
+		return nil, fmt.Errorf("failed to load species from %s:\n%v", *species_dir, err)
+	}
+	if *species_reload > 0 {
+		go watch_species(*species_dir, *species_reload, sig, stop)
+	}
+	return stopper, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackdreilly/biomorph"
+	"github.com/stretchr/testify/assert"
+)
+
+const bush_yaml = `name: bush
+genes:
+  - {name: branch_length, min: 10, max: 20}
+  - {name: num_gens, min: 2, max: 4, type: int}
+  - {name: branch_angle, min: 1, max: 3}
+  - {name: branch_increase, min: 0.5, max: 1}
+  - {name: angle_increase, min: 0.5, max: 1}
+  - {name: num_branches, min: 3, max: 6, type: int}
+  - {name: angle_noise, min: -0.1, max: 0.1}
+  - {name: length_noise, min: -0.1, max: 0.1}
+`
+
+func TestSpeciesReload(t *testing.T) {
+	api_server(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bush.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(bush_yaml), 0644))
+	*species_name, *species_dir, *species_reload = "bush", dir, 10*time.Millisecond
+	defer func() {
+		*species_name, *species_dir = "tree", ""
+		biomorph.SetConfiguredSpecies(nil)
+	}()
+	var c *biomorph.Creature
+	var id uint64
+	stop, err := init_species()
+	assert.NoError(t, err)
+	defer stop()
+	c, id, err = NewCreature()
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, c.GetValue("branch_length"))
+
+	// A broken edit is reported and the previous definitions stay.
+	assert.NoError(t, os.WriteFile(path, []byte("name: bush\ngenes: 3\n"), 0644))
+	assert.Eventually(t, func() bool {
+		species_mu.Lock()
+		defer species_mu.Unlock()
+		return species_status.Error != ""
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "bush", current_species().Name)
+
+	// Narrowing a range refits stored creatures to it.
+	narrow := []byte(bush_yaml[:len("name: bush\ngenes:\n")] + "  - {name: branch_length, min: 10, max: 12}\n" + bush_yaml[len("name: bush\ngenes:\n  - {name: branch_length, min: 10, max: 20}\n"):])
+	assert.NoError(t, os.WriteFile(path, narrow, 0644))
+	assert.Eventually(t, func() bool {
+		return current_species().Gene("branch_length").Range.Max == 12
+	}, time.Second, 10*time.Millisecond)
+	c, _, err = GetCreature(id)
+	assert.NoError(t, err)
+	assert.Equal(t, 12.0, c.GetValue("branch_length"))
+
+	*species_name = "fern"
+	assert.Error(t, load_species(dir))
+}
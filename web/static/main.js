@@ -23,6 +23,14 @@ function get_gif() {
     return document.getElementById("gif");
 }
 
+function request_failed(xhr) {
+    var message = 'Returned status of ' + xhr.status;
+    try {
+        message = JSON.parse(xhr.responseText).error;
+    } catch (e) {}
+    alert('Request failed.  ' + message);
+}
+
 function image_clicked() {
     const xhr = new XMLHttpRequest();
     const image = this.image;
@@ -33,7 +41,7 @@ function image_clicked() {
                 id: image.id
             })();
         } else {
-            alert('Request failed.  Returned status of ' + xhr.status);
+            request_failed(xhr);
         }
     }
     xhr.send();
@@ -49,7 +57,7 @@ function history_clicked() {
             draw_images(json.images);
             draw_gif(json.gif);
         } else {
-            alert('Request failed.  Returned status of ' + xhr.status);
+            request_failed(xhr);
         }
     }
     xhr.send();
@@ -60,7 +68,7 @@ function create_image(image) {
     div.setAttribute("class", "container");
     const img_node = document.createElement("img");
     img_node.setAttribute("class", "mutant clickable");
-    img_node.setAttribute("src", "data:image/png;base64," + image.bytes);
+    img_node.setAttribute("src", image.url);
     img_node.onclick = image_clicked.bind({
         image: image
     });
@@ -72,6 +80,13 @@ function create_image(image) {
         image: image
     });
     div.appendChild(a);
+    const e = document.createElement("span");
+    e.setAttribute("class", "clickable top-right");
+    e.innerText = "Evolve";
+    e.onclick = evolve_clicked.bind({
+        image: image
+    });
+    div.appendChild(e);
     return div;
 }
 
@@ -87,7 +102,7 @@ function draw_gif(gif) {
     clear_gif();
     const el = get_gif();
     const im = document.createElement("img");
-    im.setAttribute("src", "data:iamge/gif;base64," + gif);
+    im.setAttribute("src", gif);
     im.setAttribute("class", "mutant");
     const t = document.createElement("div");
     t.innerText = "Evolution";
@@ -106,8 +121,64 @@ function get_images() {
         if (xhr.status === 200) {
             draw_images(JSON.parse(xhr.responseText).images);
         } else {
-            alert('Request failed.  Returned status of ' + xhr.status);
+            request_failed(xhr);
         }
     };
     xhr.send();
 }
+
+function get_progress() {
+    return document.getElementById("progress");
+}
+
+function draw_progress(points) {
+    const canvas = document.getElementById("progress-chart");
+    const ctx = canvas.getContext("2d");
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    if (points.length == 0) {
+        return;
+    }
+    const max_gen = points[points.length - 1].generation;
+    var max_fitness = 0;
+    for (const p of points) {
+        max_fitness = Math.max(max_fitness, p.best_fitness);
+    }
+    ctx.beginPath();
+    for (const p of points) {
+        const x = canvas.width * p.generation / Math.max(max_gen, 1);
+        const y = canvas.height * (1 - p.best_fitness / (max_fitness || 1));
+        ctx.lineTo(x, y);
+    }
+    ctx.stroke();
+}
+
+function evolve_clicked() {
+    const image = this.image;
+    const label = document.getElementById("progress-label");
+    const points = [];
+    get_progress().style.display = "block";
+    draw_progress(points);
+    const source = new EventSource('/evolve?id=' + image.id);
+    var best_id = image.id;
+    source.onmessage = function(event) {
+        const p = JSON.parse(event.data);
+        p.generation = p.generation || 0;
+        p.best_fitness = p.best_fitness || 0;
+        best_id = p.best_id;
+        points.push(p);
+        label.innerText = "Generation " + p.generation + ", best fitness " + p.best_fitness.toFixed(3) + ", best ID: " + p.best_id;
+        draw_progress(points);
+    };
+    source.addEventListener("done", function() {
+        source.close();
+        history_clicked.bind({
+            image: {
+                id: best_id
+            }
+        })();
+    });
+    source.addEventListener("failed", function(event) {
+        source.close();
+        alert('Evolution failed: ' + JSON.parse(event.data).error);
+    });
+}
@@ -1,69 +1,95 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/base64"
 	"encoding/json"
-	"image"
-	"image/color"
-	"image/gif"
-	"image/png"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackdreilly/biomorph"
 	pb "github.com/jackdreilly/biomorph/db"
+	"github.com/jackdreilly/biomorph/db/service"
+	"github.com/jackdreilly/biomorph/db/store"
+	"github.com/jackdreilly/biomorph/events"
 
-	"cloud.google.com/go/logging"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
-	n_images = 30
-	address  = "localhost:50051"
+	n_images           = 30
+	default_generation = 20
+	default_population = 10
+	default_fitness    = "ink"
+	default_cache_size = 1024
 )
 
 var (
-	client pb.DbClient
-	conn   *grpc.ClientConn
-	logger *log.Logger
+	client       pb.DbClient
+	sink         events.Sink
+	render_cache = must_render_cache(biomorph.NewRenderCache(default_cache_size, ""))
+
+	remote  = flag.String("remote", "", "address of a db server, e.g. localhost:50051; empty runs the store in-process")
+	backend = flag.String("store", "sqlite", "in-process creature store backend, one of "+strings.Join(store.Backends, ", "))
+	db_path = flag.String("db", "bio.db", "database file for the in-process sqlite and bolt stores")
+
+	event_sink   = flag.String("events", "stderr", "event sink, one of "+strings.Join(events.Sinks(), ", "))
+	event_target = flag.String("events_target", "", "event sink target: a file path for file, a project ID for cloud")
+
+	cache_size = flag.Int("render_cache_size", default_cache_size, "number of rendered images kept in memory")
+	cache_dir  = flag.String("render_cache_dir", "", "directory for rendered images kept across restarts; empty disables it")
 )
 
-func init() {
-	// Set up a connection to the server.
-	log.SetOutput(os.Stderr)
-	var err error
-	conn, err = grpc.Dial(address, grpc.WithInsecure())
+func must_render_cache(c *biomorph.RenderCache, err error) *biomorph.RenderCache {
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		log.Fatalf("failed to create render cache: %v", err)
 	}
-	client = pb.NewDbClient(conn)
-
-	// Sets your Google Cloud Platform project ID.
-	projectID := "quiklyrics-go"
+	return c
+}
 
-	// Creates a client.
-	ctx := context.Background()
-	c, err := logging.NewClient(ctx, projectID)
+// Connect sets up client, either against a remote db server or an in-process
+// store. The returned function releases the connection or store.
+func Connect() (func() error, error) {
+	if *remote != "" {
+		conn, err := grpc.Dial(*remote, grpc.WithInsecure())
+		if err != nil {
+			return nil, err
+		}
+		client = pb.NewDbClient(conn)
+		return conn.Close, nil
+	}
+	cs, err := store.Open(*backend, *db_path)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, err
 	}
+	srv := service.NewServer(cs)
+	srv.Species = *species_name
+	client = service.NewLocalClient(srv)
+	return cs.Close, nil
+}
 
-	// Sets the name of the log to write to.
-	logName := "biomorph"
-
-	// Selects the log to write to.
-	logger = c.Logger(logName).StandardLogger(logging.Info)
+func init() {
+	log.SetOutput(os.Stderr)
+	expvar.Publish("render_cache", expvar.Func(func() interface{} {
+		return render_cache.Stats()
+	}))
 }
 
 type Image struct {
-	Bytes string `json:"bytes"`
-	Id    uint64 `json:"id"`
+	Url string `json:"url"`
+	Id  uint64 `json:"id"`
 }
 
 type Response struct {
@@ -72,142 +98,263 @@ type Response struct {
 }
 
 type value_map struct {
+	species string
 	values  map[string]float64
 	parents []uint64
 }
 
-func log_err(err error) {
-	if err != nil {
-		logger.Fatal(err)
+func record(e events.Event) {
+	if err := sink.Record(e); err != nil {
+		log.Printf("failed to record %s event: %v", e.Kind, err)
 	}
 }
 
-func AddCreature(v *value_map) uint64 {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	r, err := client.SaveCreature(ctx, &pb.SaveCreatureRequest{Values: v.values, Parents: v.parents})
-	log_err(err)
-	return r.GetId()
-}
-
-func Parents(id uint64) []uint64 {
+func AddCreature(v *value_map) (uint64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	r, err := client.GetCreature(ctx, &pb.GetCreatureRequest{Id: id})
-	log_err(err)
-	return r.GetParents()
+	r, err := client.SaveCreature(ctx, &pb.SaveCreatureRequest{Species: v.species, Values: v.values, Parents: v.parents})
+	if err != nil {
+		return 0, err
+	}
+	return r.GetId(), nil
 }
 
-func HistoryImages(id uint64) []Image {
-	parents := Parents(id)
+func HistoryImages(id uint64) ([]Image, error) {
+	_, parents, err := GetCreature(id)
+	if err != nil {
+		return nil, err
+	}
 	parents = append(parents, id)
 	images := make([]Image, len(parents))
 	for i, cid := range parents {
-		nc, _ := GetCreature(cid)
-		img := biomorph.DrawTreeCreature(nc)
-		var buff bytes.Buffer
-		png.Encode(&buff, img)
-		images[i].Bytes = base64.StdEncoding.EncodeToString(buff.Bytes())
+		images[i].Url = CreatureImageUrl(cid)
 		images[i].Id = cid
 	}
-	return images
+	return images, nil
 }
 
-type my_quant struct{}
+func NewCreature() (*biomorph.Creature, uint64, error) {
+	c := biomorph.NewCreature(current_species())
+	id, err := AddCreature(&value_map{c.CreatureSpecies.Name, c.ValuesMap(), []uint64{}})
+	return c, id, err
+}
 
-func (m *my_quant) Quantize(p color.Palette, _ image.Image) color.Palette {
-	return []color.Color{
-		color.White,
-		color.Black,
+func GetCreature(id uint64) (*biomorph.Creature, []uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := client.GetCreature(ctx, &pb.GetCreatureRequest{Id: id})
+	if err != nil {
+		return nil, nil, err
+	}
+	species, err := biomorph.GetSpecies(r.GetSpecies())
+	if err != nil {
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "creature %d: %v", id, err)
 	}
+	return species.LoadCreature(r.GetValues()), r.GetParents(), nil
 }
 
-func Gif(images []Image) string {
-	outGif := &gif.GIF{}
-	for _, i := range images {
-		b, _ := base64.StdEncoding.DecodeString(i.Bytes)
-		im, _ := png.Decode(bytes.NewReader(b))
-		var buf bytes.Buffer
-		w := bufio.NewWriter(&buf)
-		gif.Encode(w, im, &gif.Options{NumColors: 2, Quantizer: &my_quant{}})
-		gim, _ := gif.Decode(bufio.NewReader(&buf))
-		outGif.Image = append(outGif.Image, gim.(*image.Paletted))
-		outGif.Delay = append(outGif.Delay, 0)
+func GetImages(w http.ResponseWriter, r *http.Request) {
+	c, id, err := NewCreature()
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	if err := WriteImagesOut(id, c, []uint64{}, w); err != nil {
+		write_error(w, err)
 	}
-	var buff bytes.Buffer
-	w := bufio.NewWriter(&buff)
-	gif.EncodeAll(w, outGif)
-	return base64.StdEncoding.EncodeToString(buff.Bytes())
 }
 
-func NewCreature() (*biomorph.Creature, uint64) {
-	c := biomorph.NewCreature(biomorph.NewTreeSpecies())
-	return c, AddCreature(&value_map{c.ValuesMap(), []uint64{}})
+func GetImage(w http.ResponseWriter, r *http.Request) {
+	id, err := parse_id(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	if err := WriteHistoryOut(id, w); err != nil {
+		write_error(w, err)
+	}
 }
 
-func GetCreature(id uint64) (*biomorph.Creature, []uint64) {
-	c := biomorph.NewCreature(biomorph.NewTreeSpecies())
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	r, err := client.GetCreature(ctx, &pb.GetCreatureRequest{Id: id})
-	log_err(err)
-	c.SetValuesFromMap(r.GetValues())
-	return c, r.GetParents()
+func MutateImage(w http.ResponseWriter, r *http.Request) {
+	id, err := parse_id(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	creature, parents, err := GetCreature(id)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	if err := WriteImagesOut(id, creature, parents, w); err != nil {
+		write_error(w, err)
+	}
 }
 
-func GetImages(w http.ResponseWriter, r *http.Request) {
-	c, id := NewCreature()
-	WriteImagesOut(id, c, []uint64{}, w)
+func ChooseImage(w http.ResponseWriter, r *http.Request) {
+	id, err := parse_id(r)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	_, parents, err := GetCreature(id)
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	record(events.NewEvent(events.Selection, id, parents, nil))
 }
 
-func GetImage(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	creature, parents := GetCreature(uint64(id))
-	WriteHistoryOut(uint64(id), creature, parents, w)
+func WriteImagesOut(id uint64, creature *biomorph.Creature, parents []uint64, w http.ResponseWriter) error {
+	images, err := Offspring(creature, append(parents, id), n_images)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(Response{Images: images})
 }
 
-func MutateImage(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	creature, parents := GetCreature(uint64(id))
-	WriteImagesOut(uint64(id), creature, parents, w)
+// Offspring saves n mutants of creature on a bounded pool of workers, each
+// with its own random source, and pre-renders them into the render cache.
+// The images come back in job order whatever order the workers finish in.
+func Offspring(creature *biomorph.Creature, parents []uint64, n int) ([]Image, error) {
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	images := make([]Image, n)
+	errs := make([]error, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		rng := rand.New(rand.NewSource(rand.Int63()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				nc := biomorph.MutateCreatureRand(creature, rng)
+				values := nc.ValuesMap()
+				nid, err := AddCreature(&value_map{nc.CreatureSpecies.Name, values, parents})
+				if err != nil {
+					errs[j] = err
+					continue
+				}
+				render_cache.Render(nc, biomorph.DefaultRenderOptions())
+				images[j] = Image{CreatureImageUrl(nid), nid}
+				record(events.NewEvent(events.Mutation, nid, parents, values))
+			}
+		}()
+	}
+	for j := 0; j < n; j++ {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
 }
 
-func WriteImagesOut(id uint64, creature *biomorph.Creature, parents []uint64, w http.ResponseWriter) {
-	parents = append(parents, id)
-	response := Response{Images: make([]Image, n_images)}
-	var vm value_map
-	vm.parents = parents
-	for i := 0; i < n_images; i++ {
-		nc := biomorph.MutateCreature(creature)
-		img := biomorph.DrawTreeCreature(nc)
-		var buff bytes.Buffer
-		png.Encode(&buff, img)
-		response.Images[i].Bytes = base64.StdEncoding.EncodeToString(buff.Bytes())
-		vm.values = nc.ValuesMap()
-		nid := AddCreature(&vm)
-		response.Images[i].Id = nid
-	}
-	json.NewEncoder(w).Encode(response)
-}
-
-func WriteHistoryOut(id uint64, creature *biomorph.Creature, parents []uint64, w http.ResponseWriter) {
-	parents = append(parents, id)
-	images := HistoryImages(id)
-	json.NewEncoder(w).Encode(&Response{images, Gif(images)})
+func WriteHistoryOut(id uint64, w http.ResponseWriter) error {
+	images, err := HistoryImages(id)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(&Response{images, LineageGifUrl(id)})
+}
+
+func query_int(r *http.Request, key string, def int) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// EvolveProgress runs a headless evolution on the db server and streams each
+// generation to the browser as a Server-Sent Event.
+func EvolveProgress(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	fitness := r.URL.Query().Get("fitness")
+	if fitness == "" {
+		fitness = default_fitness
+	}
+	var id uint64
+	if r.URL.Query().Get("id") != "" {
+		var err error
+		if id, err = parse_id(r); err != nil {
+			write_error(w, err)
+			return
+		}
+	}
+	stream, err := client.Evolve(r.Context(), &pb.EvolveRequest{
+		Id:          id,
+		Generations: uint32(query_int(r, "generations", default_generation)),
+		Population:  uint32(query_int(r, "population", default_population)),
+		Fitness:     fitness,
+	})
+	if err != nil {
+		write_error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			fmt.Fprint(w, "event: done\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+		if err != nil {
+			b, _ := json.Marshal(ErrorResponse{status.Convert(err).Message(), status.Code(err).String()})
+			fmt.Fprintf(w, "event: failed\ndata: %s\n\n", b)
+			flusher.Flush()
+			return
+		}
+		b, _ := json.Marshal(p)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		flusher.Flush()
+	}
 }
 
 func main() {
-	defer conn.Close()
+	flag.Parse()
+	stop_species, err := init_species()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop_species()
+	closer, err := Connect()
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer closer()
+	sink, err = events.Open(*event_sink, *event_target)
+	if err != nil {
+		log.Fatalf("failed to open %s event sink: %v", *event_sink, err)
+	}
+	defer sink.Close()
+	render_cache = must_render_cache(biomorph.NewRenderCache(*cache_size, *cache_dir))
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/", fs)
 
 	http.HandleFunc("/get_images", GetImages)
 	http.HandleFunc("/get_image", GetImage)
 	http.HandleFunc("/mutate_image", MutateImage)
-
-	http.HandleFunc("/choose_image", func(w http.ResponseWriter, r *http.Request) {
-		logger.Println(r.URL.Query().Get("id"))
-	})
+	http.HandleFunc("/evolve", EvolveProgress)
+	http.HandleFunc("/choose_image", ChooseImage)
+	images := NewImageHandler()
+	http.Handle("/creature/", images)
+	http.Handle("/lineage/", images)
+	http.Handle("/g/", images)
+	http.Handle(api_prefix+"/", NewApiHandler())
 
 	http.ListenAndServe(":8080", nil)
 }
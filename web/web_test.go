@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "github.com/jackdreilly/biomorph/db"
+	"github.com/jackdreilly/biomorph/db/service"
+	"github.com/jackdreilly/biomorph/db/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffspring(t *testing.T) {
+	cs, err := store.NewSqliteStore(filepath.Join(t.TempDir(), "bio.db"))
+	assert.NoError(t, err)
+	defer cs.Close()
+	client = service.NewLocalClient(service.NewServer(cs))
+	sink = discard_sink{}
+
+	creature, id, err := NewCreature()
+	assert.NoError(t, err)
+	images, err := Offspring(creature, []uint64{id}, n_images)
+	assert.NoError(t, err)
+	assert.Len(t, images, n_images)
+	seen := map[uint64]bool{}
+	for _, img := range images {
+		assert.False(t, seen[img.Id])
+		seen[img.Id] = true
+		assert.Equal(t, CreatureImageUrl(img.Id), img.Url)
+		_, parents, err := GetCreature(img.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{id}, parents)
+	}
+	assert.GreaterOrEqual(t, render_cache.Stats().Entries, 1)
+}
+
+// sse_events splits a Server-Sent Events body into its events.
+func sse_events(body string) []string {
+	var events []string
+	for _, e := range strings.Split(body, "\n\n") {
+		if e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestEvolveProgress(t *testing.T) {
+	api_server(t)
+	s := httptest.NewServer(http.HandlerFunc(EvolveProgress))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?generations=3&population=2&fitness=spread")
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := sse_events(string(body))
+	if assert.Len(t, events, 4, string(body)) {
+		for i, e := range events[:3] {
+			var p pb.EvolveProgress
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(e, "data: ")), &p))
+			assert.Equal(t, uint32(i+1), p.GetGeneration())
+			assert.NotZero(t, p.GetBestId())
+		}
+		assert.Equal(t, "event: done\ndata: {}", events[3])
+	}
+
+	resp, err = http.Get(s.URL + "?fitness=beauty")
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	events = sse_events(string(body))
+	if assert.Len(t, events, 1) {
+		assert.True(t, strings.HasPrefix(events[0], "event: failed\n"), events[0])
+		assert.Contains(t, events[0], "InvalidArgument")
+	}
+}